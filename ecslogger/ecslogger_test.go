@@ -2,6 +2,7 @@ package ecslogger
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"io"
 	"os"
@@ -10,6 +11,8 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/encypher-studio/newsware-utils/nwtrace"
+	"github.com/encypher-studio/newsware-utils/nwtrace/nwtracetest"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap/zapcore"
@@ -227,3 +230,45 @@ func TestLogger_conforms_to_interface(t *testing.T) {
 
 	_ = wrapper{logger: logger}
 }
+
+func TestLogger_Error_withTraceFields(t *testing.T) {
+	defer os.RemoveAll("./test")
+	nwtracetest.Install(t)
+
+	logger, err := New(Config{
+		Service: ServiceConfig{
+			Id:   "test_id",
+			Name: "test_name",
+		},
+		Level: zapcore.InfoLevel,
+		Path:  "./test/log.log",
+	})
+	if !assert.NoError(t, err) {
+		assert.FailNow(t, "")
+	}
+
+	ctx, span := nwtrace.Start(context.Background(), "test")
+	defer span.End()
+	logger.Error("error", errors.New("error"), TraceFields(ctx)...)
+
+	logFileBytes, err := os.ReadFile("./test/log.log")
+	if !assert.NoError(t, err) {
+		assert.FailNow(t, "")
+	}
+
+	actualLog := struct {
+		TraceId string `json:"trace.id"`
+		SpanId  string `json:"span.id"`
+	}{}
+	err = json.Unmarshal(logFileBytes, &actualLog)
+	if !assert.NoError(t, err) {
+		assert.FailNow(t, "")
+	}
+
+	assert.Equal(t, span.SpanContext().TraceID().String(), actualLog.TraceId)
+	assert.Equal(t, span.SpanContext().SpanID().String(), actualLog.SpanId)
+}
+
+func TestTraceFields_noSpan(t *testing.T) {
+	assert.Nil(t, TraceFields(context.Background()))
+}
@@ -0,0 +1,22 @@
+package ecslogger
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// TraceFields returns the ECS trace.id and span.id fields for the span in ctx, so log lines can be correlated with
+// traces. It returns nil if ctx carries no valid span.
+func TraceFields(ctx context.Context) []zap.Field {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return nil
+	}
+
+	return []zap.Field{
+		zap.String("trace.id", spanContext.TraceID().String()),
+		zap.String("span.id", spanContext.SpanID().String()),
+	}
+}
@@ -4,17 +4,24 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"runtime/debug"
+	"time"
 
 	"github.com/encypher-studio/newsware-utils/ecslogger"
 	"github.com/encypher-studio/newsware-utils/indexer"
 	"github.com/encypher-studio/newsware-utils/indexmetrics"
 	"github.com/encypher-studio/newsware-utils/nwelastic"
 	"github.com/encypher-studio/newsware-utils/nwfs"
+	"github.com/encypher-studio/newsware-utils/nwtrace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
 var (
 	ErrIgnorableNews = fmt.Errorf("ignorable news")
+	ErrParsePanic    = fmt.Errorf("parse function panicked")
+	ErrParseTimeout  = fmt.Errorf("parse function timed out")
 )
 
 type ParseFunc func(newFile nwfs.NewFile) (nwelastic.News, error)
@@ -23,21 +30,40 @@ type IIndexer interface {
 	Index(news *nwelastic.News) error
 }
 
+// contextIndexer is implemented by indexers that cancel requests and propagate the trace context, such as
+// indexer.Indexer. FileWatcher uses IndexContext when the IIndexer implements it.
+type contextIndexer interface {
+	IndexContext(ctx context.Context, news *nwelastic.News) error
+}
+
 // FileWatcher watches for new files in a directory, parses them using parseFunc and indexes them using indexer. If PreIndexProcessor is set, it is called before indexing.
 type FileWatcher struct {
 	fs        nwfs.IFs
 	indexer   IIndexer
 	logger    ecslogger.ILogger
 	parseFunc ParseFunc
+	opts      Opts
+	// stop ends Run, it's set when Run starts
+	stop context.CancelFunc
+}
+
+type Opts struct {
+	// ParseTimeout is the maximum time a single parseFunc call can take before the file is moved to the unprocessable
+	// directory. The parseFunc goroutine can't be stopped, so it keeps running in the background. Zero means no timeout.
+	ParseTimeout time.Duration `yaml:"parseTimeout"`
 }
 
 // New creates a new Fly instance.
-func New(fsConfig nwfs.Config, indexer indexer.Indexer, parseFunc ParseFunc, logger ecslogger.ILogger) (FileWatcher, error) {
+func New(fsConfig nwfs.Config, indexer indexer.Indexer, parseFunc ParseFunc, logger ecslogger.ILogger, opts ...Opts) (FileWatcher, error) {
 	fs, err := nwfs.NewFs(fsConfig, logger)
 	if err != nil {
 		return FileWatcher{}, err
 	}
-	return FileWatcher{fs: fs, indexer: indexer, logger: logger, parseFunc: parseFunc}, nil
+	f := FileWatcher{fs: fs, indexer: indexer, logger: logger, parseFunc: parseFunc}
+	if len(opts) > 0 {
+		f.opts = opts[0]
+	}
+	return f, nil
 }
 
 // Run starts the FileWatcher instance.
@@ -45,6 +71,7 @@ func (f *FileWatcher) Run() {
 	chanFiles := make(chan nwfs.NewFile, 100)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	f.stop = cancel
 
 	go func() {
 		err := f.fs.Watch(ctx, chanFiles)
@@ -60,52 +87,129 @@ func (f *FileWatcher) Run() {
 			f.logger.Info("file received for processing", zap.String("path", newFile.Path))
 			f.logger.Debug("file received", zap.String("path", newFile.Path), zap.String("data", string(newFile.Bytes)))
 			// Process asynchronously
-			go func() {
-				news, err := f.parseFunc(newFile)
-				if err != nil {
-					if errors.Is(err, ErrIgnorableNews) {
-						f.logger.Info("ignorable news", zap.String("path", newFile.Path))
-						err = f.fs.Delete(newFile)
-						if err != nil {
-							f.logger.Error("deleting ignorable file", err, zap.String("path", newFile.Path))
-						} else {
-							f.logger.Info("file deleted", zap.String("path", newFile.Path))
-						}
-						return
-					}
-
-					// Move file to unprocessable directory
-					f.logger.Error("parsing news", err, zap.String("path", newFile.Path))
-					err = f.fs.Unprocessable(newFile)
-					if err != nil {
-						f.logger.Error("moving file to unprocessable directory", err, zap.String("path", newFile.Path))
-					}
-					return
-				}
-
-				news.ReceivedTime = newFile.ReceivedTime
-
-				err = f.indexer.Index(&news)
-				if err != nil {
-					// Send file again to the channel, so it can be processed again
-					f.logger.Error("indexing news", err, zap.String("path", newFile.Path))
-					chanFiles <- newFile
-					return
-				}
-
-				f.logger.Info("file indexed", zap.String("path", newFile.Path))
-
-				err = f.fs.Delete(newFile)
-				if err != nil {
-					f.logger.Error("deleting indexed file", err, zap.String("path", newFile.Path))
-				} else {
-					f.logger.Info("file deleted", zap.String("path", newFile.Path))
-				}
-
-				indexmetrics.MetricDocumentsIndexed.WithLabelValues().Inc()
-			}()
+			go f.process(newFile, chanFiles)
 		case <-ctx.Done():
 			return
 		}
 	}
 }
+
+// process parses and indexes newFile within a span that is a child of the span that emitted the file. If indexing
+// fails, the file is sent to chanFiles again.
+func (f *FileWatcher) process(newFile nwfs.NewFile, chanFiles chan nwfs.NewFile) {
+	ctx := trace.ContextWithSpanContext(context.Background(), newFile.SpanContext)
+	ctx, span := nwtrace.Start(ctx, "filewatcher process", trace.WithAttributes(attribute.String("file.path", newFile.Path)))
+	defer span.End()
+	logFields := append(ecslogger.TraceFields(ctx), zap.String("path", newFile.Path))
+
+	_, parseSpan := nwtrace.Start(ctx, "filewatcher parse")
+	news, err := f.parse(newFile)
+	nwtrace.EndWithError(parseSpan, err)
+	if err != nil {
+		if errors.Is(err, ErrIgnorableNews) {
+			f.logger.Info("ignorable news", logFields...)
+			err = f.fs.Delete(newFile)
+			if err != nil {
+				f.logger.Error("deleting ignorable file", err, logFields...)
+			} else {
+				f.logger.Info("file deleted", logFields...)
+			}
+			return
+		}
+
+		// Move file to unprocessable directory
+		f.logger.Error("parsing news", err, logFields...)
+		err = f.fs.Unprocessable(newFile)
+		if err != nil {
+			f.logger.Error("moving file to unprocessable directory", err, logFields...)
+		}
+		return
+	}
+
+	news.ReceivedTime = newFile.ReceivedTime
+
+	indexCtx, indexSpan := nwtrace.Start(ctx, "filewatcher index")
+	err = f.index(indexCtx, &news)
+	nwtrace.EndWithError(indexSpan, err)
+	if err != nil {
+		if indexer.IsConfigurationError(err) {
+			// Every news would fail the same way until the indexer configuration is fixed. The file is left in place,
+			// it's processed again when the FileWatcher is restarted.
+			f.logger.Error("indexer configuration error, stopping the file watcher", err, logFields...)
+			if f.stop != nil {
+				f.stop()
+			}
+			return
+		}
+		if indexer.IsPermanent(err) {
+			// The indexer rejected the news, sending it again would fail the same way
+			f.logger.Error("indexing news rejected", err, logFields...)
+			err = f.fs.Unprocessable(newFile)
+			if err != nil {
+				f.logger.Error("moving file to unprocessable directory", err, logFields...)
+			}
+			return
+		}
+
+		// Send file again to the channel, so it can be processed again
+		f.logger.Error("indexing news", err, logFields...)
+		chanFiles <- newFile
+		return
+	}
+
+	f.logger.Info("file indexed", logFields...)
+
+	err = f.fs.Delete(newFile)
+	if err != nil {
+		f.logger.Error("deleting indexed file", err, logFields...)
+	} else {
+		f.logger.Info("file deleted", logFields...)
+	}
+
+	indexmetrics.MetricDocumentsIndexed.WithLabelValues().Inc()
+}
+
+// index indexes news with IndexContext if the indexer supports it, Index otherwise
+func (f *FileWatcher) index(ctx context.Context, news *nwelastic.News) error {
+	if idx, ok := f.indexer.(contextIndexer); ok {
+		return idx.IndexContext(ctx, news)
+	}
+	return f.indexer.Index(news)
+}
+
+type parseResult struct {
+	news nwelastic.News
+	err  error
+}
+
+// parse calls parseFunc converting panics to ErrParsePanic errors and enforcing opts.ParseTimeout
+func (f *FileWatcher) parse(newFile nwfs.NewFile) (nwelastic.News, error) {
+	if f.opts.ParseTimeout <= 0 {
+		return f.safeParse(newFile)
+	}
+
+	// Buffered so the goroutine can finish after a timeout
+	chanResult := make(chan parseResult, 1)
+	go func() {
+		news, err := f.safeParse(newFile)
+		chanResult <- parseResult{news: news, err: err}
+	}()
+
+	select {
+	case result := <-chanResult:
+		return result.news, result.err
+	case <-time.After(f.opts.ParseTimeout):
+		return nwelastic.News{}, fmt.Errorf("%w after %s", ErrParseTimeout, f.opts.ParseTimeout)
+	}
+}
+
+// safeParse calls parseFunc, a panic is recovered and returned as an ErrParsePanic error including the stack trace
+func (f *FileWatcher) safeParse(newFile nwfs.NewFile) (news nwelastic.News, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v\n%s", ErrParsePanic, r, debug.Stack())
+		}
+	}()
+
+	return f.parseFunc(newFile)
+}
@@ -1,12 +1,19 @@
 package filewatcher
 
 import (
+	"context"
 	"errors"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/encypher-studio/newsware-utils/indexer"
 	"github.com/encypher-studio/newsware-utils/nwelastic"
 	"github.com/encypher-studio/newsware-utils/nwfs"
+	"github.com/encypher-studio/newsware-utils/nwtrace"
+	"github.com/encypher-studio/newsware-utils/nwtrace/nwtracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestFileWatcher_Run(t *testing.T) {
@@ -122,3 +129,177 @@ func TestFileWatcher_Run(t *testing.T) {
 		})
 	}
 }
+
+func TestFileWatcher_process_tracing(t *testing.T) {
+	exporter := nwtracetest.Install(t)
+
+	_, emitSpan := nwtrace.Start(context.Background(), "nwfs emit")
+	emitSpan.End()
+
+	mock := &mockContextIndexer{}
+	f := FileWatcher{
+		fs:      NewMockFs(),
+		indexer: mock,
+		logger:  mockLogger{},
+		parseFunc: func(nwfs.NewFile) (nwelastic.News, error) {
+			return nwelastic.News{}, nil
+		},
+	}
+	f.process(nwfs.NewFile{SpanContext: emitSpan.SpanContext()}, make(chan nwfs.NewFile, 1))
+
+	// The context of the index span is passed to IndexContext
+	if mock.indexCalls != 1 || trace.SpanContextFromContext(mock.argIndexCtx).TraceID() != emitSpan.SpanContext().TraceID() {
+		t.Fatalf("IndexContext wasn't called with the trace context, calls = %d", mock.indexCalls)
+	}
+
+	expectedNames := []string{"nwfs emit", "filewatcher parse", "filewatcher index", "filewatcher process"}
+	if !slices.Equal(expectedNames, nwtracetest.SpanNames(exporter)) {
+		t.Fatalf("spans = %v, expected %v", nwtracetest.SpanNames(exporter), expectedNames)
+	}
+
+	for _, span := range exporter.GetSpans()[1:] {
+		if span.SpanContext.TraceID() != emitSpan.SpanContext().TraceID() {
+			t.Fatalf("span %s does not belong to the emitting trace", span.Name)
+		}
+	}
+}
+
+func TestFileWatcher_process_quarantine(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        Opts
+		parseFunc   ParseFunc
+		expectedErr error
+	}{
+		{
+			name: "panic",
+			parseFunc: func(nwfs.NewFile) (nwelastic.News, error) {
+				panic("malformed file")
+			},
+			expectedErr: ErrParsePanic,
+		},
+		{
+			name: "timeout",
+			opts: Opts{ParseTimeout: 10 * time.Millisecond},
+			parseFunc: func(nwfs.NewFile) (nwelastic.News, error) {
+				time.Sleep(time.Second)
+				return nwelastic.News{}, nil
+			},
+			expectedErr: ErrParseTimeout,
+		},
+		{
+			name: "within timeout",
+			opts: Opts{ParseTimeout: time.Second},
+			parseFunc: func(nwfs.NewFile) (nwelastic.News, error) {
+				return nwelastic.News{}, nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := FileWatcher{
+				fs:        NewMockFs(),
+				indexer:   &mockIndexer{},
+				logger:    mockLogger{},
+				parseFunc: tt.parseFunc,
+				opts:      tt.opts,
+			}
+
+			_, err := f.parse(nwfs.NewFile{})
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("parse() error = %v, expected %v", err, tt.expectedErr)
+			}
+
+			f.process(nwfs.NewFile{}, make(chan nwfs.NewFile, 1))
+
+			expectedMoveCalls, expectedIndexCalls := 1, 0
+			if tt.expectedErr == nil {
+				expectedMoveCalls, expectedIndexCalls = 0, 1
+			}
+			if f.fs.(*mockFs).moveCalls != expectedMoveCalls {
+				t.Fatalf("process() moveCalls = %v, expected %v", f.fs.(*mockFs).moveCalls, expectedMoveCalls)
+			}
+			if f.indexer.(*mockIndexer).indexCalls != expectedIndexCalls {
+				t.Fatalf("process() indexCalls = %v, expected %v", f.indexer.(*mockIndexer).indexCalls, expectedIndexCalls)
+			}
+		})
+	}
+}
+
+func TestFileWatcher_parse_panicIncludesStack(t *testing.T) {
+	f := FileWatcher{
+		parseFunc: func(nwfs.NewFile) (nwelastic.News, error) {
+			var news *nwelastic.News
+			return *news, nil
+		},
+	}
+
+	_, err := f.parse(nwfs.NewFile{})
+	if !errors.Is(err, ErrParsePanic) {
+		t.Fatalf("parse() error = %v, expected %v", err, ErrParsePanic)
+	}
+	if !strings.Contains(err.Error(), "runtime/debug.Stack") {
+		t.Fatalf("parse() error does not include the stack trace: %v", err)
+	}
+}
+
+func TestFileWatcher_process_indexErrors(t *testing.T) {
+	tests := []struct {
+		name              string
+		indexErr          error
+		expectedMoveCalls int
+		expectedRequeued  bool
+		expectedStopped   bool
+	}{
+		{
+			name:              "permanent",
+			indexErr:          &indexer.Error{StatusCode: 400, Retryable: false},
+			expectedMoveCalls: 1,
+		},
+		{
+			name:             "retryable",
+			indexErr:         &indexer.Error{StatusCode: 503, Retryable: true},
+			expectedRequeued: true,
+		},
+		{
+			name:             "unclassified",
+			indexErr:         errors.New("error"),
+			expectedRequeued: true,
+		},
+		{
+			name:            "configuration",
+			indexErr:        &indexer.Error{StatusCode: 401, Retryable: false},
+			expectedStopped: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stopped := false
+			f := FileWatcher{
+				fs:      NewMockFs(),
+				indexer: &mockIndexer{rets: []error{tt.indexErr}},
+				logger:  mockLogger{},
+				parseFunc: func(nwfs.NewFile) (nwelastic.News, error) {
+					return nwelastic.News{}, nil
+				},
+				stop: func() { stopped = true },
+			}
+
+			chanFiles := make(chan nwfs.NewFile, 1)
+			f.process(nwfs.NewFile{}, chanFiles)
+
+			if f.fs.(*mockFs).moveCalls != tt.expectedMoveCalls {
+				t.Fatalf("process() moveCalls = %v, expected %v", f.fs.(*mockFs).moveCalls, tt.expectedMoveCalls)
+			}
+			if f.fs.(*mockFs).deleteCalls != 0 {
+				t.Fatalf("process() deleteCalls = %v, expected 0", f.fs.(*mockFs).deleteCalls)
+			}
+			if (len(chanFiles) == 1) != tt.expectedRequeued {
+				t.Fatalf("process() requeued = %v, expected %v", len(chanFiles) == 1, tt.expectedRequeued)
+			}
+			if stopped != tt.expectedStopped {
+				t.Fatalf("process() stopped = %v, expected %v", stopped, tt.expectedStopped)
+			}
+		})
+	}
+}
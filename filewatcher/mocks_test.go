@@ -68,3 +68,14 @@ func (m *mockIndexer) Index(news *nwelastic.News) error {
 	}
 	return m.rets[m.indexCalls-1]
 }
+
+// mockContextIndexer is a mockIndexer that also implements IndexContext
+type mockContextIndexer struct {
+	mockIndexer
+	argIndexCtx context.Context
+}
+
+func (m *mockContextIndexer) IndexContext(ctx context.Context, news *nwelastic.News) error {
+	m.argIndexCtx = ctx
+	return m.Index(news)
+}
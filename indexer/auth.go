@@ -0,0 +1,134 @@
+package indexer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// AuthModeHeader sends the api key in the HeaderApiKey header, it is the default
+	AuthModeHeader = "header"
+	// AuthModeQuery sends the api key in the apiKey query parameter, where it ends up in access and proxy logs. It is
+	// only kept for indexer deployments that don't read the header.
+	AuthModeQuery = "query"
+
+	HeaderApiKey    = "X-Api-Key"
+	HeaderTimestamp = "X-Nw-Timestamp"
+	HeaderSignature = "X-Nw-Signature"
+
+	redacted = "[REDACTED]"
+)
+
+var (
+	ErrInvalidAuthMode  = errors.New("invalid auth mode")
+	ErrInvalidSignature = errors.New("invalid request signature")
+	ErrExpiredSignature = errors.New("request signature timestamp out of range")
+)
+
+// Sign returns the hex encoded HMAC-SHA256 of the request using secret. The timestamp, method and path are signed
+// along with the body so a captured request can't be replayed later or against another endpoint.
+func Sign(secret string, timestamp string, method string, path string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + method + "\n" + path + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks a signature produced by Sign, timestamp is a unix time in seconds that must be within maxSkew
+// of now.
+func VerifySignature(secret string, timestamp string, signature string, method string, path string, body []byte, maxSkew time.Duration, now time.Time) error {
+	unixTimestamp, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	skew := now.Sub(time.Unix(unixTimestamp, 0))
+	if skew > maxSkew || skew < -maxSkew {
+		return ErrExpiredSignature
+	}
+
+	expected := Sign(secret, timestamp, method, path, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// authenticate adds the credentials and, if a signing secret is configured, the signature to req
+func (i Indexer) authenticate(req *http.Request, body []byte) {
+	if i.apiKey != "" {
+		switch i.authMode {
+		case AuthModeQuery:
+			query := req.URL.Query()
+			query.Set("apiKey", i.apiKey)
+			req.URL.RawQuery = query.Encode()
+		default:
+			req.Header.Set(HeaderApiKey, i.apiKey)
+		}
+	}
+
+	if i.signingSecret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(HeaderTimestamp, timestamp)
+		req.Header.Set(HeaderSignature, Sign(i.signingSecret, timestamp, req.Method, req.URL.Path, body))
+	}
+}
+
+// scrubError hides the api key and the signing secret from the message of err
+func (i Indexer) scrubError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var secrets []string
+	for _, secret := range []string{i.apiKey, i.signingSecret} {
+		if secret != "" {
+			secrets = append(secrets, secret, url.QueryEscape(secret))
+		}
+	}
+	if len(secrets) == 0 {
+		return err
+	}
+
+	return scrubbedError{err: err, secrets: secrets}
+}
+
+// scrubbedError replaces secrets in the message of err, the original error can still be inspected with errors.As
+type scrubbedError struct {
+	err     error
+	secrets []string
+}
+
+func (e scrubbedError) Error() string {
+	msg := e.err.Error()
+	for _, secret := range e.secrets {
+		msg = strings.ReplaceAll(msg, secret, redacted)
+	}
+	return msg
+}
+
+func (e scrubbedError) Unwrap() error {
+	return e.err
+}
+
+// String hides the credentials so the config can be logged
+func (c Config) String() string {
+	type alias Config
+	if c.ApiKey != "" {
+		c.ApiKey = redacted
+	}
+	if c.SigningSecret != "" {
+		c.SigningSecret = redacted
+	}
+	return fmt.Sprintf("%+v", alias(c))
+}
@@ -0,0 +1,136 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/encypher-studio/newsware-utils/api/response"
+	"github.com/encypher-studio/newsware-utils/nwelastic"
+)
+
+func TestIndexer_authentication(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         Config
+		expectedHeader string
+		expectedQuery  string
+		expectSigned   bool
+	}{
+		{
+			name:           "header",
+			config:         Config{ApiKey: "key"},
+			expectedHeader: "key",
+		},
+		{
+			name:          "legacy query",
+			config:        Config{ApiKey: "key&=", AuthMode: AuthModeQuery},
+			expectedQuery: "key&=",
+		},
+		{
+			name:           "signed",
+			config:         Config{ApiKey: "key", SigningSecret: "secret"},
+			expectedHeader: "key",
+			expectSigned:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if actual := r.Header.Get(HeaderApiKey); actual != tt.expectedHeader {
+					t.Errorf("api key header = '%s', expected '%s'", actual, tt.expectedHeader)
+				}
+				if actual := r.URL.Query().Get("apiKey"); actual != tt.expectedQuery {
+					t.Errorf("api key query = '%s', expected '%s'", actual, tt.expectedQuery)
+				}
+
+				signature := r.Header.Get(HeaderSignature)
+				if tt.expectSigned {
+					err := VerifySignature(tt.config.SigningSecret, r.Header.Get(HeaderTimestamp), signature, r.Method, r.URL.Path, body, time.Minute, time.Now())
+					if err != nil {
+						t.Errorf("verifying signature: %s", err)
+					}
+				} else if signature != "" {
+					t.Errorf("unexpected signature")
+				}
+
+				w.WriteHeader(http.StatusOK)
+				w.Write(marshalUnsafe(response.Response[*int, *int]{}))
+			}))
+			defer server.Close()
+
+			tt.config.Host = server.URL
+			i := mustNew(t, tt.config)
+			err := i.Index(&nwelastic.News{Id: "1"})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestIndexer_invalidAuthMode(t *testing.T) {
+	_, err := new(Config{AuthMode: "cookie"})
+	if !errors.Is(err, ErrInvalidAuthMode) {
+		t.Fatalf("error is not as expected, got '%s', expected '%s'", err, ErrInvalidAuthMode)
+	}
+}
+
+func TestIndexer_scrubsSecretsFromErrors(t *testing.T) {
+	// Nothing listens on port 1
+	i := mustNew(t, Config{Host: "http://127.0.0.1:1", ApiKey: "super-secret", AuthMode: AuthModeQuery, SigningSecret: "signing-secret"})
+	err := i.Ping()
+	if err == nil {
+		t.Fatal("expected connection error")
+	}
+	if strings.Contains(err.Error(), "super-secret") || strings.Contains(err.Error(), "signing-secret") {
+		t.Fatalf("error leaks a secret: %s", err)
+	}
+	if !strings.Contains(err.Error(), redacted) {
+		t.Fatalf("error is not redacted: %s", err)
+	}
+
+	config := fmt.Sprint(Config{ApiKey: "super-secret", SigningSecret: "signing-secret"})
+	if strings.Contains(config, "super-secret") || strings.Contains(config, "signing-secret") {
+		t.Fatalf("config string leaks a secret: %s", config)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	body := []byte(`{"id":"1"}`)
+	signature := Sign("secret", timestamp, http.MethodPost, "/api/v1/index", body)
+
+	tests := []struct {
+		name        string
+		secret      string
+		timestamp   string
+		path        string
+		body        []byte
+		now         time.Time
+		expectedErr error
+	}{
+		{"valid", "secret", timestamp, "/api/v1/index", body, now, nil},
+		{"wrong secret", "other", timestamp, "/api/v1/index", body, now, ErrInvalidSignature},
+		{"tampered body", "secret", timestamp, "/api/v1/index", []byte(`{"id":"2"}`), now, ErrInvalidSignature},
+		{"other endpoint", "secret", timestamp, "/api/v1/index/batch", body, now, ErrInvalidSignature},
+		{"replayed later", "secret", timestamp, "/api/v1/index", body, now.Add(time.Hour), ErrExpiredSignature},
+		{"invalid timestamp", "secret", "abc", "/api/v1/index", body, now, ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifySignature(tt.secret, tt.timestamp, signature, http.MethodPost, tt.path, tt.body, time.Minute, tt.now)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("error is not as expected, got '%s', expected '%s'", err, tt.expectedErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/encypher-studio/newsware-utils/nwelastic"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultBatcherMaxItems   = 500
+	defaultBatcherMaxLatency = time.Second
+)
+
+var ErrBatcherClosed = errors.New("batcher is closed")
+
+type BatcherOpts struct {
+	// MaxItems is the number of news that triggers a flush, defaults to 500
+	MaxItems int `yaml:"maxItems"`
+	// MaxBytes is the serialized size of the news that triggers a flush, defaults to the MaxBatchSize of the Indexer
+	MaxBytes int `yaml:"maxBytes"`
+	// MaxLatency is the maximum time a news waits in the buffer before it is flushed, defaults to 1s
+	MaxLatency time.Duration `yaml:"maxLatency"`
+	// QueueSize is the number of news that can be added while a flush is in progress before Add blocks, defaults to
+	// MaxItems
+	QueueSize int `yaml:"queueSize"`
+}
+
+// Batcher buffers news and indexes them with Indexer.IndexBatch when MaxItems or MaxBytes is reached, or when the
+// oldest buffered news has waited MaxLatency.
+type Batcher struct {
+	indexer Indexer
+	opts    BatcherOpts
+	items   chan batcherItem
+	// closing is closed when Close is called to stop accepting news
+	closing chan struct{}
+	// drain is closed once no Add can send to items anymore
+	drain chan struct{}
+	done  chan struct{}
+	// addMutex is held for reading by Add while sending to items
+	addMutex  sync.RWMutex
+	closeOnce sync.Once
+	// ctx is canceled if Close times out, aborting the flush in progress
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type batcherItem struct {
+	news   *nwelastic.News
+	size   int
+	future *Future
+}
+
+// Future is the result of indexing a news added to a Batcher
+type Future struct {
+	done chan struct{}
+	err  error
+}
+
+func newFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+func (f *Future) resolve(err error) {
+	f.err = err
+	close(f.done)
+}
+
+// Done is closed once the news is indexed or failed
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Err returns the result of indexing the news, it must only be called after Done is closed
+func (f *Future) Err() error {
+	return f.err
+}
+
+// Wait blocks until the news is indexed or failed, or ctx is done
+func (f *Future) Wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NewBatcher creates a Batcher that indexes news using indexer, Close must be called to flush the buffered news
+func NewBatcher(indexer Indexer, opts BatcherOpts) *Batcher {
+	if opts.MaxItems <= 0 {
+		opts.MaxItems = defaultBatcherMaxItems
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = indexer.maxBatchSize
+	}
+	if opts.MaxLatency <= 0 {
+		opts.MaxLatency = defaultBatcherMaxLatency
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = opts.MaxItems
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Batcher{
+		indexer: indexer,
+		opts:    opts,
+		items:   make(chan batcherItem, opts.QueueSize),
+		closing: make(chan struct{}),
+		drain:   make(chan struct{}),
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	go b.run()
+
+	return b
+}
+
+// Add buffers news to be indexed, the returned Future is resolved once it's flushed. Add blocks if the queue is full
+// until there is room or ctx is done. ErrBatcherClosed is returned after Close is called.
+func (b *Batcher) Add(ctx context.Context, news *nwelastic.News) (*Future, error) {
+	newsJson, err := json.Marshal(news)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshaling news item")
+	}
+
+	b.addMutex.RLock()
+	defer b.addMutex.RUnlock()
+
+	select {
+	case <-b.closing:
+		return nil, ErrBatcherClosed
+	default:
+	}
+
+	item := batcherItem{news: news, size: len(newsJson), future: newFuture()}
+	select {
+	case b.items <- item:
+		return item.future, nil
+	case <-b.closing:
+		return nil, ErrBatcherClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close stops accepting news and flushes the buffered news. If ctx is done before the flush finishes, the flush is
+// aborted, the pending futures fail and ctx error is returned.
+func (b *Batcher) Close(ctx context.Context) error {
+	b.closeOnce.Do(func() {
+		close(b.closing)
+		// Wait for the Add calls in progress to return
+		b.addMutex.Lock()
+		b.addMutex.Unlock()
+		close(b.drain)
+	})
+
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		<-b.done
+		return ctx.Err()
+	}
+}
+
+func (b *Batcher) run() {
+	defer close(b.done)
+	defer b.cancel()
+
+	var (
+		batch     []batcherItem
+		batchSize int
+	)
+	timer := time.NewTimer(b.opts.MaxLatency)
+	timer.Stop()
+
+	add := func(item batcherItem) {
+		// +1 for the separator
+		if len(batch) > 0 && batchSize+item.size+1 > b.opts.MaxBytes {
+			b.flush(batch)
+			batch, batchSize = nil, 0
+		}
+		if len(batch) == 0 {
+			timer.Reset(b.opts.MaxLatency)
+		}
+		batch = append(batch, item)
+		batchSize += item.size + 1
+		if len(batch) >= b.opts.MaxItems {
+			timer.Stop()
+			b.flush(batch)
+			batch, batchSize = nil, 0
+		}
+	}
+
+	for {
+		select {
+		case item := <-b.items:
+			add(item)
+		case <-timer.C:
+			if len(batch) > 0 {
+				b.flush(batch)
+				batch, batchSize = nil, 0
+			}
+		case <-b.drain:
+			timer.Stop()
+			for {
+				select {
+				case item := <-b.items:
+					add(item)
+					continue
+				default:
+				}
+				break
+			}
+			if len(batch) > 0 {
+				b.flush(batch)
+			}
+			return
+		}
+	}
+}
+
+// flush indexes batch and resolves the futures of its items. The news the indexer rejected fail with their
+// IndexBatchFailure, the news that weren't processed fail with the error.
+func (b *Batcher) flush(batch []batcherItem) {
+	news := make([]*nwelastic.News, len(batch))
+	for i, item := range batch {
+		news[i] = item.news
+	}
+
+	processed := 0
+	err := b.indexer.IndexBatchContext(b.ctx, news, func(totalIndexed int, lastIndex int) {
+		processed = lastIndex + 1
+	})
+
+	failed := make(map[int]error)
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) {
+		for _, failure := range batchErr.Failures {
+			failed[failure.Index] = failure.Err()
+		}
+		err = nil
+	}
+	if err == nil && processed < len(batch) {
+		err = errors.New("indexer did not report all news as indexed")
+	}
+
+	for i, item := range batch {
+		if i >= processed {
+			item.future.resolve(err)
+		} else {
+			item.future.resolve(failed[i])
+		}
+	}
+}
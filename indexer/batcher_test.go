@@ -0,0 +1,213 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/encypher-studio/newsware-utils/api/response"
+	"github.com/encypher-studio/newsware-utils/nwelastic"
+)
+
+func TestBatcher(t *testing.T) {
+	tests := []struct {
+		name string
+		opts BatcherOpts
+		news []*nwelastic.News
+		// failId makes the indexer fail the request containing that news after indexing the previous ones
+		failId string
+		// rejectId makes the indexer report that news as a failure of the batch
+		rejectId string
+		// waitBeforeClose waits for every future before calling Close
+		waitBeforeClose    bool
+		expectedBatches    [][]string
+		expectedFailedNews []string
+	}{
+		{
+			name:            "flush by count",
+			opts:            BatcherOpts{MaxItems: 2, MaxLatency: time.Hour},
+			news:            []*nwelastic.News{{Id: "1"}, {Id: "2"}, {Id: "3"}, {Id: "4"}},
+			waitBeforeClose: true,
+			expectedBatches: [][]string{{"1", "2"}, {"3", "4"}},
+		},
+		{
+			name:            "flush by size",
+			opts:            BatcherOpts{MaxBytes: 300, MaxLatency: time.Hour},
+			news:            []*nwelastic.News{{Id: "1"}, {Id: "2"}, {Id: "3"}},
+			expectedBatches: [][]string{{"1"}, {"2"}, {"3"}},
+		},
+		{
+			name:            "flush by latency",
+			opts:            BatcherOpts{MaxLatency: 10 * time.Millisecond},
+			news:            []*nwelastic.News{{Id: "1"}, {Id: "2"}},
+			waitBeforeClose: true,
+			expectedBatches: [][]string{{"1", "2"}},
+		},
+		{
+			name:            "close flushes remaining",
+			opts:            BatcherOpts{MaxItems: 2, MaxLatency: time.Hour},
+			news:            []*nwelastic.News{{Id: "1"}, {Id: "2"}, {Id: "3"}},
+			expectedBatches: [][]string{{"1", "2"}, {"3"}},
+		},
+		{
+			name:               "partial failure",
+			opts:               BatcherOpts{MaxLatency: time.Hour},
+			news:               []*nwelastic.News{{Id: "1"}, {Id: "2"}, {Id: "3"}},
+			failId:             "2",
+			expectedBatches:    [][]string{{"1", "2", "3"}, {"2", "3"}},
+			expectedFailedNews: []string{"2", "3"},
+		},
+		{
+			name:               "rejected news",
+			opts:               BatcherOpts{MaxLatency: time.Hour},
+			news:               []*nwelastic.News{{Id: "1"}, {Id: "2"}, {Id: "3"}},
+			rejectId:           "2",
+			expectedBatches:    [][]string{{"1", "2", "3"}},
+			expectedFailedNews: []string{"2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mutex   sync.Mutex
+				batches [][]string
+			)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var news []*nwelastic.News
+				if err := json.NewDecoder(r.Body).Decode(&news); err != nil {
+					t.Errorf("decoding request: %s", err)
+				}
+
+				ids := make([]string, len(news))
+				for i, n := range news {
+					ids[i] = n.Id
+				}
+				mutex.Lock()
+				batches = append(batches, ids)
+				mutex.Unlock()
+
+				for i, n := range news {
+					if n.Id != tt.rejectId {
+						continue
+					}
+					w.WriteHeader(http.StatusUnprocessableEntity)
+					w.Write(marshalUnsafe(response.ErrorExplicit("test_code", "test", IndexBatchData{
+						TotalIndexed: len(news) - 1,
+						LastIndex:    len(news) - 1,
+						Failures:     []IndexBatchFailure{{Index: i, Id: n.Id, Status: http.StatusBadRequest, Reason: "rejected"}},
+					})))
+					return
+				}
+
+				for i, n := range news {
+					if n.Id != tt.failId {
+						continue
+					}
+					w.WriteHeader(http.StatusBadRequest)
+					if i == 0 {
+						w.Write(marshalUnsafe(response.ErrorExplicit[*int]("test_code", "test", nil)))
+						return
+					}
+					w.Write(marshalUnsafe(response.ErrorExplicit("test_code", "test", IndexBatchData{
+						TotalIndexed: i,
+						LastIndex:    i - 1,
+					})))
+					return
+				}
+
+				w.WriteHeader(http.StatusOK)
+				w.Write(marshalUnsafe(response.SuccessWithData(IndexBatchData{
+					TotalIndexed: len(news),
+					LastIndex:    len(news) - 1,
+				})))
+			}))
+			defer server.Close()
+
+			b := NewBatcher(mustNew(t, Config{Host: server.URL}), tt.opts)
+			futures := make([]*Future, len(tt.news))
+			for i, news := range tt.news {
+				future, err := b.Add(context.Background(), news)
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				futures[i] = future
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if tt.waitBeforeClose {
+				for _, future := range futures {
+					if err := future.Wait(ctx); err != nil {
+						t.Fatalf("unexpected error: %s", err)
+					}
+				}
+			}
+
+			if err := b.Close(ctx); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			var actualFailedNews []string
+			for i, future := range futures {
+				select {
+				case <-future.Done():
+				default:
+					t.Fatalf("future %d is not resolved after Close", i)
+				}
+				if future.Err() != nil {
+					actualFailedNews = append(actualFailedNews, tt.news[i].Id)
+				}
+			}
+
+			if !reflect.DeepEqual(tt.expectedBatches, batches) {
+				t.Fatalf("batches = %v, expected %v", batches, tt.expectedBatches)
+			}
+			if !reflect.DeepEqual(tt.expectedFailedNews, actualFailedNews) {
+				t.Fatalf("failed news = %v, expected %v", actualFailedNews, tt.expectedFailedNews)
+			}
+		})
+	}
+}
+
+func TestBatcher_Add_closed(t *testing.T) {
+	b := NewBatcher(Indexer{}, BatcherOpts{})
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	_, err := b.Add(context.Background(), &nwelastic.News{})
+	if !errors.Is(err, ErrBatcherClosed) {
+		t.Fatalf("error is not as expected, got '%v', expected '%s'", err, ErrBatcherClosed)
+	}
+}
+
+func TestBatcher_Close_timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	b := NewBatcher(mustNew(t, Config{Host: server.URL}), BatcherOpts{MaxLatency: time.Hour})
+	future, err := b.Add(context.Background(), &nwelastic.News{Id: "1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = b.Close(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error is not as expected, got '%v', expected '%s'", err, context.DeadlineExceeded)
+	}
+	if future.Err() == nil {
+		t.Fatalf("expected the future to fail")
+	}
+}
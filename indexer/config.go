@@ -1,6 +1,83 @@
 package indexer
 
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultMaxBatchSize is set to 10MB
+	defaultMaxBatchSize        = 10e6
+	defaultRequestTimeout      = 30 * time.Second
+	defaultDialTimeout         = 30 * time.Second
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 100
+	// defaultOutboxSegmentSize is set to 64MB
+	defaultOutboxSegmentSize       = 64e6
+	defaultOutboxReplayInterval    = 5 * time.Second
+	defaultOutboxMaxBackoff        = time.Minute
+	defaultHealthCheckInterval     = 10 * time.Second
+	defaultCircuitBreakerThreshold = 3
+	defaultCircuitBreakerCooldown  = 30 * time.Second
+)
+
 type Config struct {
-	Host   string
+	// Host is the indexer host, including the port if applies. It's ignored if Hosts is set.
+	Host string
+	// Hosts are the indexer hosts to fail over across, requests that fail with a retryable error are retried on the
+	// next host
+	Hosts []string `yaml:"hosts"`
+	// HostStrategy is HostStrategyPriority or HostStrategyRoundRobin, defaults to HostStrategyPriority
+	HostStrategy string `yaml:"hostStrategy"`
+	// HealthCheckInterval is how often every host is pinged when there are several, hosts are skipped while their
+	// ping fails. Defaults to 10s.
+	HealthCheckInterval time.Duration `yaml:"healthCheckInterval"`
+	// CircuitBreakerThreshold is the number of consecutive retryable failures after which a host is skipped for
+	// CircuitBreakerCooldown, defaults to 3
+	CircuitBreakerThreshold int `yaml:"circuitBreakerThreshold"`
+	// CircuitBreakerCooldown defaults to 30s
+	CircuitBreakerCooldown time.Duration `yaml:"circuitBreakerCooldown"`
+
 	ApiKey string `yaml:"apiKey"`
+	// AuthMode is AuthModeHeader or AuthModeQuery, defaults to AuthModeHeader
+	AuthMode string `yaml:"authMode"`
+	// SigningSecret, if set, is used to sign every request with HMAC-SHA256, see Sign
+	SigningSecret string `yaml:"signingSecret"`
+	// MaxBatchSize is the maximum size in bytes of a request to the batch endpoint, defaults to 10MB
+	MaxBatchSize int `yaml:"maxBatchSize"`
+
+	// RequestTimeout limits the time of a request including reading the response body, defaults to 30s
+	RequestTimeout time.Duration `yaml:"requestTimeout"`
+	// DialTimeout limits the time to establish a connection, defaults to 30s
+	DialTimeout time.Duration `yaml:"dialTimeout"`
+	// MaxIdleConns is the maximum number of idle connections kept open, defaults to 100
+	MaxIdleConns int `yaml:"maxIdleConns"`
+	// MaxIdleConnsPerHost is the maximum number of idle connections kept open to the indexer host, defaults to 100
+	MaxIdleConnsPerHost int `yaml:"maxIdleConnsPerHost"`
+	// CaCertPath is a PEM file with the certificate authorities used to verify the indexer certificate, the system
+	// pool is used if empty
+	CaCertPath string `yaml:"caCertPath"`
+	// ClientCertPath and ClientKeyPath are PEM files with the certificate presented to the indexer for mutual TLS
+	ClientCertPath string `yaml:"clientCertPath"`
+	ClientKeyPath  string `yaml:"clientKeyPath"`
+	// InsecureSkipVerify disables verification of the indexer certificate, only use it for development
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
+	// ProxyUrl is the proxy used to reach the indexer, if empty the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+	// variables are used
+	ProxyUrl string `yaml:"proxyUrl"`
+	// DisableProxy ignores ProxyUrl and the proxy environment variables
+	DisableProxy bool `yaml:"disableProxy"`
+
+	// HttpClient is used to send requests if set, all the transport options above are ignored
+	HttpClient *http.Client `yaml:"-"`
+
+	// OutboxDir enables the outbox. When Index fails with a retryable error the news is appended to a segment file in
+	// this directory and Index succeeds, the outbox is replayed in order in the background once Ping succeeds.
+	OutboxDir string `yaml:"outboxDir"`
+	// OutboxSegmentSize is the size in bytes after which a new segment file is started, defaults to 64MB
+	OutboxSegmentSize int64 `yaml:"outboxSegmentSize"`
+	// OutboxReplayInterval is how often the outbox is checked for pending news, defaults to 5s
+	OutboxReplayInterval time.Duration `yaml:"outboxReplayInterval"`
+	// OutboxMaxBackoff is the maximum interval between replays while the indexer is unavailable, defaults to 1m
+	OutboxMaxBackoff time.Duration `yaml:"outboxMaxBackoff"`
 }
@@ -0,0 +1,169 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// maxErrorBodyLength limits how much of a non JSON error body is kept in Error.Message
+const maxErrorBodyLength = 512
+
+// CodeNotFound is the response.ResponseError code of a 404 for a news that doesn't exist
+const CodeNotFound = "not_found"
+
+// Error is returned by Indexer when a request fails, either because the indexer service responded with a non 2xx
+// status or because no response was received.
+type Error struct {
+	// StatusCode is the HTTP status of the response, zero if no response was received
+	StatusCode int
+	// Code and Data come from the response.ResponseError returned by the indexer service. If the body wasn't a
+	// response envelope, Code is empty and Message contains the beginning of the body.
+	Code    string
+	Message string
+	Data    json.RawMessage
+	// Retryable is true if the request might succeed if sent again, such as on timeouts, 429 or 5xx responses
+	Retryable bool
+	// err is the transport error when no response was received
+	err error
+}
+
+func (e *Error) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	if e.Message != "" {
+		return e.Message
+	}
+	return http.StatusText(e.StatusCode)
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// BatchError is returned by Indexer.IndexBatch when the indexer couldn't index some news of the batch, the other news
+// were indexed. Failures are in the order of the batch.
+type BatchError struct {
+	Failures []IndexBatchFailure
+}
+
+func (e *BatchError) Error() string {
+	first := e.Failures[0]
+	return fmt.Sprintf("%d news failed to index, the first is %s: %s", len(e.Failures), first.Id, first.Reason)
+}
+
+// Err returns the failure as an *Error, to be classified with IsRetryable and IsPermanent
+func (f IndexBatchFailure) Err() error {
+	return &Error{
+		StatusCode: f.Status,
+		Code:       f.Type,
+		Message:    fmt.Sprintf("news %s failed to index: %s", f.Id, f.Reason),
+		Retryable:  isRetryableStatus(f.Status),
+	}
+}
+
+// IsRetryable returns true if err is an *Error that might succeed if the request is sent again
+func IsRetryable(err error) bool {
+	var indexerErr *Error
+	return errors.As(err, &indexerErr) && indexerErr.Retryable
+}
+
+// IsPermanent returns true if err is an *Error that will fail again if the same request is sent, such as a validation
+// rejection. Callers should stop retrying and quarantine the news. Configuration errors aren't permanent, see
+// IsConfigurationError.
+func IsPermanent(err error) bool {
+	var indexerErr *Error
+	return errors.As(err, &indexerErr) && !indexerErr.Retryable && !indexerErr.configurationError()
+}
+
+// IsConfigurationError returns true if err is an *Error caused by the client configuration rather than the news, such
+// as an invalid api key, a missing permission or a wrong route. Every request fails the same way until the
+// configuration is fixed, so callers should stop and alert instead of quarantining the news.
+func IsConfigurationError(err error) bool {
+	var indexerErr *Error
+	return errors.As(err, &indexerErr) && indexerErr.configurationError()
+}
+
+// StatusCode returns the HTTP status of err if it is an *Error, zero otherwise
+func StatusCode(err error) int {
+	var indexerErr *Error
+	if errors.As(err, &indexerErr) {
+		return indexerErr.StatusCode
+	}
+	return 0
+}
+
+// IsNotFound returns true if err is an *Error for a news that doesn't exist, such as when updating an unknown id. A 404
+// without CodeNotFound is a route that doesn't exist, see IsConfigurationError.
+func IsNotFound(err error) bool {
+	var indexerErr *Error
+	return errors.As(err, &indexerErr) && indexerErr.notFound()
+}
+
+func (e *Error) notFound() bool {
+	return e.StatusCode == http.StatusNotFound && e.Code == CodeNotFound
+}
+
+func (e *Error) configurationError() bool {
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusMethodNotAllowed, http.StatusProxyAuthRequired:
+		return true
+	case http.StatusNotFound:
+		return !e.notFound()
+	}
+	return false
+}
+
+// newTransportError classifies an error returned while sending a request. Every failure is retryable except a
+// cancellation by the caller.
+func newTransportError(err error) *Error {
+	return &Error{
+		Retryable: !errors.Is(err, context.Canceled),
+		err:       err,
+	}
+}
+
+// newResponseError builds an Error from a non 2xx response body
+func newResponseError(statusCode int, body []byte) *Error {
+	indexerErr := &Error{
+		StatusCode: statusCode,
+		Retryable:  isRetryableStatus(statusCode),
+	}
+
+	var respApi struct {
+		Error *struct {
+			Code    string          `json:"code"`
+			Message string          `json:"message"`
+			Data    json.RawMessage `json:"data"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &respApi); err != nil || respApi.Error == nil {
+		if len(body) > maxErrorBodyLength {
+			body = body[:maxErrorBodyLength]
+		}
+		indexerErr.Message = string(body)
+		return indexerErr
+	}
+
+	indexerErr.Code = respApi.Error.Code
+	indexerErr.Message = respApi.Error.Message
+	if len(respApi.Error.Data) > 0 && string(respApi.Error.Data) != "null" {
+		indexerErr.Data = respApi.Error.Data
+	}
+
+	return indexerErr
+}
+
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+		return false
+	}
+	return statusCode >= 500
+}
@@ -0,0 +1,153 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/encypher-studio/newsware-utils/api/response"
+	"github.com/encypher-studio/newsware-utils/nwelastic"
+)
+
+func TestIndexer_Index_errorClassification(t *testing.T) {
+	tests := []struct {
+		name              string
+		responseCode      int
+		body              []byte
+		expectedCode      string
+		expectedMessage   string
+		expectedData      string
+		expectedRetryable bool
+		expectedConfigErr bool
+	}{
+		{
+			name:              "validation rejection",
+			responseCode:      http.StatusBadRequest,
+			body:              marshalUnsafe(response.ErrorExplicit("invalid_news", "headline is required", "headline")),
+			expectedCode:      "invalid_news",
+			expectedMessage:   "headline is required",
+			expectedData:      `"headline"`,
+			expectedRetryable: false,
+		},
+		{
+			name:              "outage",
+			responseCode:      http.StatusServiceUnavailable,
+			body:              marshalUnsafe(response.ErrorExplicit[*int]("unavailable", "elastic is down", nil)),
+			expectedCode:      "unavailable",
+			expectedMessage:   "elastic is down",
+			expectedRetryable: true,
+		},
+		{
+			name:              "rate limited",
+			responseCode:      http.StatusTooManyRequests,
+			body:              marshalUnsafe(response.ErrorExplicit[*int]("rate_limited", "slow down", nil)),
+			expectedCode:      "rate_limited",
+			expectedMessage:   "slow down",
+			expectedRetryable: true,
+		},
+		{
+			name:              "non json body from proxy",
+			responseCode:      http.StatusBadGateway,
+			body:              []byte("<html>bad gateway</html>"),
+			expectedMessage:   "<html>bad gateway</html>",
+			expectedRetryable: true,
+		},
+		{
+			name:              "empty body",
+			responseCode:      http.StatusUnauthorized,
+			expectedMessage:   "Unauthorized",
+			expectedRetryable: false,
+			expectedConfigErr: true,
+		},
+		{
+			name:              "forbidden",
+			responseCode:      http.StatusForbidden,
+			body:              marshalUnsafe(response.ErrorExplicit[*int]("403", "Forbidden", nil)),
+			expectedCode:      "403",
+			expectedMessage:   "Forbidden",
+			expectedConfigErr: true,
+		},
+		{
+			name:              "unknown route",
+			responseCode:      http.StatusNotFound,
+			body:              marshalUnsafe(response.ErrorExplicit[*int]("404", "Cannot POST /api/v1/index", nil)),
+			expectedCode:      "404",
+			expectedMessage:   "Cannot POST /api/v1/index",
+			expectedConfigErr: true,
+		},
+		{
+			name:            "news not found",
+			responseCode:    http.StatusNotFound,
+			body:            marshalUnsafe(response.ErrorExplicit[*int](CodeNotFound, "news not found", nil)),
+			expectedCode:    CodeNotFound,
+			expectedMessage: "news not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.responseCode)
+				w.Write(tt.body)
+			}))
+			defer server.Close()
+
+			i := mustNew(t, Config{Host: server.URL})
+			err := i.Index(&nwelastic.News{})
+
+			var indexerErr *Error
+			if !errors.As(err, &indexerErr) {
+				t.Fatalf("error is not an *Error: %v", err)
+			}
+			if indexerErr.StatusCode != tt.responseCode {
+				t.Fatalf("StatusCode = %d, expected %d", indexerErr.StatusCode, tt.responseCode)
+			}
+			if indexerErr.Code != tt.expectedCode {
+				t.Fatalf("Code = '%s', expected '%s'", indexerErr.Code, tt.expectedCode)
+			}
+			if err.Error() != tt.expectedMessage {
+				t.Fatalf("Error() = '%s', expected '%s'", err.Error(), tt.expectedMessage)
+			}
+			if string(indexerErr.Data) != tt.expectedData {
+				t.Fatalf("Data = '%s', expected '%s'", indexerErr.Data, tt.expectedData)
+			}
+			expectedPermanent := !tt.expectedRetryable && !tt.expectedConfigErr
+			if IsRetryable(err) != tt.expectedRetryable || IsPermanent(err) != expectedPermanent {
+				t.Fatalf("IsRetryable() = %v, IsPermanent() = %v, expected retryable %v", IsRetryable(err), IsPermanent(err), tt.expectedRetryable)
+			}
+			if IsConfigurationError(err) != tt.expectedConfigErr {
+				t.Fatalf("IsConfigurationError() = %v, expected %v", IsConfigurationError(err), tt.expectedConfigErr)
+			}
+			if StatusCode(err) != tt.responseCode {
+				t.Fatalf("StatusCode() = %d, expected %d", StatusCode(err), tt.responseCode)
+			}
+		})
+	}
+}
+
+func TestIndexer_Index_transportErrorClassification(t *testing.T) {
+	// Nothing listens on port 1
+	i := mustNew(t, Config{Host: "http://127.0.0.1:1"})
+	err := i.Index(&nwelastic.News{})
+	if !IsRetryable(err) {
+		t.Fatalf("connection error should be retryable: %v", err)
+	}
+	if StatusCode(err) != 0 {
+		t.Fatalf("StatusCode() = %d, expected 0", StatusCode(err))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = i.IndexContext(ctx, &nwelastic.News{})
+	if IsRetryable(err) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled request should not be retryable: %v", err)
+	}
+}
+
+func TestIsRetryable_otherErrors(t *testing.T) {
+	err := errors.New("other")
+	if IsRetryable(err) || IsPermanent(err) || IsConfigurationError(err) {
+		t.Fatalf("only *Error should be classified")
+	}
+}
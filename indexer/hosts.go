@@ -0,0 +1,199 @@
+package indexer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/encypher-studio/newsware-utils/indexmetrics"
+	"github.com/pkg/errors"
+)
+
+const (
+	// HostStrategyPriority sends requests to the first available host in the order of Config.Hosts
+	HostStrategyPriority = "priority"
+	// HostStrategyRoundRobin spreads requests across the available hosts
+	HostStrategyRoundRobin = "roundRobin"
+)
+
+var (
+	ErrInvalidHostStrategy = errors.New("invalid host strategy")
+	ErrNoHosts             = errors.New("no indexer host configured")
+)
+
+// hostPool selects the indexer host of each request. A host is skipped while its circuit is open, which happens
+// after circuitBreakerThreshold consecutive retryable failures and lasts circuitBreakerCooldown, or while the health
+// check fails.
+type hostPool struct {
+	hosts    []*host
+	strategy string
+	// next is the round-robin counter
+	next                    *atomic.Uint64
+	circuitBreakerThreshold int
+	circuitBreakerCooldown  time.Duration
+	stop                    chan struct{}
+	done                    chan struct{}
+	// running is true once the health check goroutine is started
+	running bool
+}
+
+type host struct {
+	url       string
+	mutex     sync.Mutex
+	failures  int
+	openUntil time.Time
+	unhealthy bool
+}
+
+func newHostPool(config Config) (*hostPool, error) {
+	urls := config.Hosts
+	if len(urls) == 0 && config.Host != "" {
+		urls = []string{config.Host}
+	}
+	if len(urls) == 0 {
+		return nil, ErrNoHosts
+	}
+
+	switch config.HostStrategy {
+	case "":
+		config.HostStrategy = HostStrategyPriority
+	case HostStrategyPriority, HostStrategyRoundRobin:
+	default:
+		return nil, errors.Wrap(ErrInvalidHostStrategy, config.HostStrategy)
+	}
+
+	if config.CircuitBreakerThreshold <= 0 {
+		config.CircuitBreakerThreshold = defaultCircuitBreakerThreshold
+	}
+	if config.CircuitBreakerCooldown <= 0 {
+		config.CircuitBreakerCooldown = defaultCircuitBreakerCooldown
+	}
+
+	p := &hostPool{
+		strategy:                config.HostStrategy,
+		next:                    &atomic.Uint64{},
+		circuitBreakerThreshold: config.CircuitBreakerThreshold,
+		circuitBreakerCooldown:  config.CircuitBreakerCooldown,
+		stop:                    make(chan struct{}),
+		done:                    make(chan struct{}),
+	}
+	for _, url := range urls {
+		p.hosts = append(p.hosts, &host{url: url})
+		indexmetrics.MetricIndexerHostAvailable.WithLabelValues(url).Set(1)
+	}
+
+	return p, nil
+}
+
+// candidates returns the hosts to try for a request in order. The available hosts come first, ordered by the
+// strategy, followed by the unavailable ones so a request is still attempted if every host is down.
+func (p *hostPool) candidates() []*host {
+	ordered := p.hosts
+	if p.strategy == HostStrategyRoundRobin && len(p.hosts) > 1 {
+		start := int(p.next.Add(1)-1) % len(p.hosts)
+		ordered = append(append([]*host{}, p.hosts[start:]...), p.hosts[:start]...)
+	}
+
+	now := time.Now()
+	available := make([]*host, 0, len(ordered))
+	var unavailable []*host
+	for _, h := range ordered {
+		if h.available(now) {
+			available = append(available, h)
+		} else {
+			unavailable = append(unavailable, h)
+		}
+	}
+
+	return append(available, unavailable...)
+}
+
+// record updates the circuit of h with the result of a request
+func (p *hostPool) record(h *host, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !IsRetryable(err) {
+		h.failures = 0
+		h.openUntil = time.Time{}
+	} else {
+		h.failures++
+		if h.failures >= p.circuitBreakerThreshold {
+			h.openUntil = time.Now().Add(p.circuitBreakerCooldown)
+		}
+	}
+	h.updateMetrics(time.Now())
+}
+
+func (p *hostPool) setHealthy(h *host, healthy bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.unhealthy = !healthy
+	if healthy {
+		h.failures = 0
+		h.openUntil = time.Time{}
+	}
+	h.updateMetrics(time.Now())
+}
+
+// startHealthCheck pings every host each interval, a host is skipped while its ping fails
+func (p *hostPool) startHealthCheck(interval time.Duration, ping func(ctx context.Context, h *host) error) {
+	p.running = true
+	go func() {
+		defer close(p.done)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-p.stop
+			cancel()
+		}()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+			}
+
+			for _, h := range p.hosts {
+				err := ping(ctx, h)
+				if ctx.Err() != nil {
+					return
+				}
+				p.setHealthy(h, err == nil)
+			}
+		}
+	}()
+}
+
+func (p *hostPool) close() {
+	select {
+	case <-p.stop:
+		return
+	default:
+		close(p.stop)
+	}
+	if p.running {
+		<-p.done
+	}
+}
+
+func (h *host) available(now time.Time) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return !h.unhealthy && !now.Before(h.openUntil)
+}
+
+// updateMetrics must be called with the mutex locked
+func (h *host) updateMetrics(now time.Time) {
+	available := 0.0
+	if !h.unhealthy && !now.Before(h.openUntil) {
+		available = 1
+	}
+	indexmetrics.MetricIndexerHostAvailable.WithLabelValues(h.url).Set(available)
+}
@@ -0,0 +1,237 @@
+package indexer
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/encypher-studio/newsware-utils/api/response"
+	"github.com/encypher-studio/newsware-utils/indexmetrics"
+	"github.com/encypher-studio/newsware-utils/nwelastic"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestIndexer_Index_failover(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		// statuses are the response status of each host
+		statuses []int
+		requests int
+		// expectedCalls are the /index requests received by each host
+		expectedCalls []int
+		expectedErr   bool
+	}{
+		{
+			name:          "priority uses the first host",
+			statuses:      []int{http.StatusOK, http.StatusOK},
+			requests:      3,
+			expectedCalls: []int{3, 0},
+		},
+		{
+			name:          "priority fails over and opens the circuit",
+			statuses:      []int{http.StatusServiceUnavailable, http.StatusOK},
+			requests:      4,
+			expectedCalls: []int{2, 4},
+		},
+		{
+			name:          "round robin",
+			strategy:      HostStrategyRoundRobin,
+			statuses:      []int{http.StatusOK, http.StatusOK, http.StatusOK},
+			requests:      6,
+			expectedCalls: []int{2, 2, 2},
+		},
+		{
+			name:          "permanent error is not retried",
+			statuses:      []int{http.StatusBadRequest, http.StatusOK},
+			requests:      2,
+			expectedCalls: []int{2, 0},
+			expectedErr:   true,
+		},
+		{
+			name:          "every host down",
+			statuses:      []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			requests:      3,
+			expectedCalls: []int{3, 3},
+			expectedErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			actualCalls := make([]int, len(tt.statuses))
+			hosts := make([]string, len(tt.statuses))
+			for h, status := range tt.statuses {
+				server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					mutex.Lock()
+					actualCalls[h]++
+					mutex.Unlock()
+
+					w.WriteHeader(status)
+					if status == http.StatusOK {
+						w.Write(marshalUnsafe(response.Success()))
+						return
+					}
+					w.Write(marshalUnsafe(response.ErrorExplicit[*int]("test_code", "test", nil)))
+				}))
+				defer server.Close()
+				hosts[h] = server.URL
+			}
+
+			i := mustNew(t, Config{
+				Hosts:                   hosts,
+				HostStrategy:            tt.strategy,
+				HealthCheckInterval:     time.Hour,
+				CircuitBreakerThreshold: 2,
+				CircuitBreakerCooldown:  time.Hour,
+			})
+			defer i.Close()
+
+			for range tt.requests {
+				err := i.Index(&nwelastic.News{})
+				if (err != nil) != tt.expectedErr {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			if !reflect.DeepEqual(tt.expectedCalls, actualCalls) {
+				t.Fatalf("calls = %v, expected %v", actualCalls, tt.expectedCalls)
+			}
+			for h, host := range hosts {
+				result := "success"
+				if tt.statuses[h] == http.StatusServiceUnavailable {
+					result = "retryable_error"
+				} else if tt.statuses[h] != http.StatusOK {
+					result = "permanent_error"
+				}
+				actual := testutil.ToFloat64(indexmetrics.MetricIndexerRequests.WithLabelValues(host, "/index", result))
+				if actual != float64(tt.expectedCalls[h]) {
+					t.Fatalf("requests metric of host %d = %v, expected %d", h, actual, tt.expectedCalls[h])
+				}
+			}
+		})
+	}
+}
+
+func TestIndexer_healthCheck(t *testing.T) {
+	var (
+		mutex          sync.Mutex
+		primaryHealthy = true
+		primaryIndexed int
+	)
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !primaryHealthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write(marshalUnsafe(response.ErrorExplicit[*int]("test_code", "test", nil)))
+			return
+		}
+		if r.URL.Path == "/api/v1/index" {
+			primaryIndexed++
+		}
+		w.Write(marshalUnsafe(response.Success()))
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(marshalUnsafe(response.Success()))
+	}))
+	defer secondary.Close()
+
+	i := mustNew(t, Config{Hosts: []string{primary.URL, secondary.URL}, HealthCheckInterval: 5 * time.Millisecond})
+	defer i.Close()
+
+	setHealthy := func(healthy bool) {
+		mutex.Lock()
+		primaryHealthy = healthy
+		mutex.Unlock()
+	}
+	waitAvailable := func(available float64) {
+		deadline := time.Now().Add(5 * time.Second)
+		for testutil.ToFloat64(indexmetrics.MetricIndexerHostAvailable.WithLabelValues(primary.URL)) != available {
+			if time.Now().After(deadline) {
+				t.Fatalf("primary host availability did not become %v", available)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	setHealthy(false)
+	waitAvailable(0)
+	setHealthy(true)
+	if err := i.Index(&nwelastic.News{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if primaryIndexed != 0 {
+		t.Fatalf("expected the unhealthy primary host to be skipped")
+	}
+
+	waitAvailable(1)
+	if err := i.Index(&nwelastic.News{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if primaryIndexed != 1 {
+		t.Fatalf("expected the primary host to be used once healthy")
+	}
+}
+
+func TestIndexer_invalidHosts(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      Config
+		expectedErr error
+	}{
+		{"no hosts", Config{}, ErrNoHosts},
+		{"invalid strategy", Config{Host: "http://localhost", HostStrategy: "random"}, ErrInvalidHostStrategy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := new(tt.config)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("error is not as expected, got '%v', expected '%s'", err, tt.expectedErr)
+			}
+		})
+	}
+}
+
+func TestNewContext_pingFails(t *testing.T) {
+	var (
+		mutex sync.Mutex
+		pings int
+	)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		pings++
+		mutex.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write(marshalUnsafe(response.ErrorExplicit[*int]("test_code", "test", nil)))
+	})
+	primary := httptest.NewServer(handler)
+	defer primary.Close()
+	secondary := httptest.NewServer(handler)
+	defer secondary.Close()
+
+	_, err := New(Config{
+		Hosts:               []string{primary.URL, secondary.URL},
+		HealthCheckInterval: time.Millisecond,
+		OutboxDir:           t.TempDir(),
+	})
+	if !IsRetryable(err) {
+		t.Fatalf("expected a retryable error, got '%v'", err)
+	}
+
+	// The health check must be stopped, no host is pinged after New returns
+	mutex.Lock()
+	expected := pings
+	mutex.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if pings != expected {
+		t.Fatalf("expected %d pings, got %d", expected, pings)
+	}
+}
@@ -2,76 +2,473 @@ package indexer
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
 
 	"github.com/encypher-studio/newsware-utils/api/response"
+	"github.com/encypher-studio/newsware-utils/indexmetrics"
 	"github.com/encypher-studio/newsware-utils/nwelastic"
+	"github.com/encypher-studio/newsware-utils/nwtrace"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Indexer helps sending news to the indexer service: https://github.com/encypher-studio/newsware-indexer
 type Indexer struct {
-	// The host where the indexer service is reachable (must include port if applies)
-	host        string
+	// hosts selects the host where the indexer service is reachable for each request
+	hosts       *hostPool
 	pathPrefix  string
 	contentType string
 	apiKey      string
+	// authMode is AuthModeHeader or AuthModeQuery
+	authMode      string
+	signingSecret string
+	// maxBatchSize is the maximum size in bytes of the body sent to the batch endpoint
+	maxBatchSize int
+	client       *http.Client
+	// outbox is nil unless Config.OutboxDir is set
+	outbox *outbox
 }
 
 func New(config Config) (Indexer, error) {
-	i := new(config)
-	return i, i.Ping()
+	return NewContext(context.Background(), config)
 }
 
-func new(config Config) Indexer {
-	return Indexer{
-		host:        config.Host,
-		pathPrefix:  "/api/v1",
-		contentType: "application/json",
-		apiKey:      config.ApiKey,
+// NewContext is like New, ctx is used for the initial ping
+func NewContext(ctx context.Context, config Config) (Indexer, error) {
+	i, err := new(config)
+	if err != nil {
+		return Indexer{}, err
+	}
+
+	err = i.PingContext(ctx)
+	if err != nil {
+		// Stop the health check and the outbox replay started by new
+		i.Close()
+		return Indexer{}, err
 	}
+
+	return i, nil
+}
+
+func new(config Config) (Indexer, error) {
+	if config.MaxBatchSize <= 0 {
+		config.MaxBatchSize = defaultMaxBatchSize
+	}
+
+	switch config.AuthMode {
+	case "":
+		config.AuthMode = AuthModeHeader
+	case AuthModeHeader, AuthModeQuery:
+	default:
+		return Indexer{}, errors.Wrap(ErrInvalidAuthMode, config.AuthMode)
+	}
+
+	hosts, err := newHostPool(config)
+	if err != nil {
+		return Indexer{}, err
+	}
+
+	client, err := newHttpClient(config)
+	if err != nil {
+		return Indexer{}, errors.Wrap(err, "creating http client")
+	}
+
+	i := Indexer{
+		hosts:         hosts,
+		pathPrefix:    "/api/v1",
+		contentType:   "application/json",
+		apiKey:        config.ApiKey,
+		authMode:      config.AuthMode,
+		signingSecret: config.SigningSecret,
+		maxBatchSize:  config.MaxBatchSize,
+		client:        client,
+	}
+
+	if len(hosts.hosts) > 1 {
+		if config.HealthCheckInterval <= 0 {
+			config.HealthCheckInterval = defaultHealthCheckInterval
+		}
+		hosts.startHealthCheck(config.HealthCheckInterval, func(ctx context.Context, h *host) error {
+			return i.postHost(ctx, h, "/ping", nil, nil)
+		})
+	}
+
+	if config.OutboxDir != "" {
+		i.outbox, err = newOutbox(config)
+		if err != nil {
+			hosts.close()
+			return Indexer{}, err
+		}
+		i.outbox.start(config.OutboxReplayInterval, config.OutboxMaxBackoff, i.PingContext, i.sendEntry)
+	}
+
+	return i, nil
+}
+
+func newOutbox(config Config) (*outbox, error) {
+	if config.OutboxSegmentSize <= 0 {
+		config.OutboxSegmentSize = defaultOutboxSegmentSize
+	}
+	if config.OutboxReplayInterval <= 0 {
+		config.OutboxReplayInterval = defaultOutboxReplayInterval
+	}
+	if config.OutboxMaxBackoff <= 0 {
+		config.OutboxMaxBackoff = defaultOutboxMaxBackoff
+	}
+
+	o, err := openOutbox(config.OutboxDir, config.OutboxSegmentSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening outbox")
+	}
+
+	return o, nil
+}
+
+// Close stops the host health check and replaying the outbox, if enabled. News left in the outbox are replayed by
+// the next Indexer using the same OutboxDir.
+func (i Indexer) Close() error {
+	if i.hosts != nil {
+		i.hosts.close()
+	}
+	if i.outbox == nil {
+		return nil
+	}
+	return i.outbox.close()
 }
 
 func (i Indexer) Index(news *nwelastic.News) error {
+	return i.IndexContext(context.Background(), news)
+}
+
+// IndexContext is like Index, the request is canceled if ctx is done and the trace context in ctx is propagated to
+// the indexer service in the request headers.
+//
+// If the outbox is enabled, news is appended to the outbox instead of returning a retryable error. While the outbox
+// has pending entries, news is appended to it directly so it is indexed after them. Concurrent calls are sent
+// concurrently while the outbox is empty.
+func (i Indexer) IndexContext(ctx context.Context, news *nwelastic.News) error {
+	_, err := i.sendOrAppend(outboxEntry{News: news}, func() error {
+		return i.index(ctx, news)
+	})
+	return err
+}
+
+// sendOrAppend calls send, or appends entry to the outbox if it's enabled and send fails with a retryable error or the
+// outbox has pending entries. queued is true if entry was appended.
+func (i Indexer) sendOrAppend(entry outboxEntry, send func() error) (queued bool, err error) {
+	if i.outbox == nil {
+		return false, send()
+	}
+
+	// Entries are sent concurrently while the outbox is empty. Once it has pending entries, they are appended one at a
+	// time so none is sent directly before the ones queued earlier.
+	i.outbox.ordering.Lock()
+	if !i.outbox.pending() {
+		i.outbox.ordering.Unlock()
+		err = send()
+		if !IsRetryable(err) {
+			return false, err
+		}
+		i.outbox.ordering.Lock()
+	}
+	defer i.outbox.ordering.Unlock()
+
+	err = i.outbox.append(entry)
+	if err != nil {
+		return false, errors.Wrap(err, "appending to outbox")
+	}
+
+	return true, nil
+}
+
+// sendEntry sends the news, delete, update or upsert of an outbox entry
+func (i Indexer) sendEntry(ctx context.Context, entry outboxEntry) error {
+	switch {
+	case entry.Delete != nil:
+		_, err := i.delete(ctx, *entry.Delete)
+		return err
+	case entry.Update != nil:
+		_, err := i.update(ctx, *entry.Update)
+		return err
+	case entry.Upsert != nil:
+		_, err := i.upsert(ctx, entry.Upsert)
+		return err
+	default:
+		return i.index(ctx, entry.News)
+	}
+}
+
+func (i Indexer) index(ctx context.Context, news *nwelastic.News) error {
 	newsJson, err := json.Marshal(news)
 	if err != nil {
 		return errors.Wrap(err, "marshaling news item")
 	}
-	resp, err := http.Post(i.urlWithAuth("/index"), i.contentType, bytes.NewReader(newsJson))
+
+	return i.post(ctx, "/index", newsJson, nil)
+}
+
+// IndexBatch indexes news using the batch endpoint. news is sent in sub-batches of at most maxBatchSize serialized
+// bytes, if the indexer reports a partial failure, indexing resumes after the last news it indexed. The
+// indexedCallback is called after each sub-batch, same as nwelastic.NewsRepository.InsertBatch, with the amount of news
+// indexed and the index in news of the last item processed, it may be nil. News the indexer rejected individually are
+// returned in a *BatchError once the whole batch is processed.
+func (i Indexer) IndexBatch(news []*nwelastic.News, indexedCallback func(totalIndexed int, lastIndex int)) error {
+	return i.IndexBatchContext(context.Background(), news, indexedCallback)
+}
+
+// IndexBatchContext is like IndexBatch, requests are canceled if ctx is done and the trace context in ctx is
+// propagated to the indexer service
+func (i Indexer) IndexBatchContext(ctx context.Context, news []*nwelastic.News, indexedCallback func(totalIndexed int, lastIndex int)) error {
+	var failures []IndexBatchFailure
+	fromIndex := 0
+	for fromIndex < len(news) {
+		batchJson, toIndex, err := i.marshalBatch(news, fromIndex)
+		if err != nil {
+			return err
+		}
+
+		var data IndexBatchData
+		err = i.post(ctx, "/index/batch", batchJson, &data)
+		if err != nil {
+			var indexerErr *Error
+			if !errors.As(err, &indexerErr) || indexerErr.Data == nil {
+				return err
+			}
+
+			// Partial failure, resume after the last processed item
+			if unmarshalErr := json.Unmarshal(indexerErr.Data, &data); unmarshalErr != nil || (data.TotalIndexed <= 0 && len(data.Failures) == 0) {
+				return err
+			}
+		}
+
+		if (data.TotalIndexed <= 0 && len(data.Failures) == 0) || data.LastIndex < 0 || fromIndex+data.LastIndex >= toIndex {
+			return errors.Errorf("indexer reported invalid progress: totalIndexed %d, lastIndex %d", data.TotalIndexed, data.LastIndex)
+		}
+		for _, failure := range data.Failures {
+			failure.Index += fromIndex
+			failures = append(failures, failure)
+		}
+
+		if indexedCallback != nil {
+			indexedCallback(data.TotalIndexed, fromIndex+data.LastIndex)
+		}
+		fromIndex += data.LastIndex + 1
+	}
+
+	if len(failures) > 0 {
+		return &BatchError{Failures: failures}
+	}
+	return nil
+}
+
+// Delete deletes the news with id, DeleteData.Found is false if it didn't exist. Like Index, the delete is appended to
+// the outbox if it's enabled and the indexer is unavailable or news are pending, DeleteData.Queued is then true.
+func (i Indexer) Delete(id string) (DeleteData, error) {
+	return i.DeleteContext(context.Background(), id)
+}
+
+// DeleteContext is like Delete, the request is canceled if ctx is done
+func (i Indexer) DeleteContext(ctx context.Context, id string) (DeleteData, error) {
+	req := DeleteRequest{Id: id}
+	var data DeleteData
+	queued, err := i.sendOrAppend(outboxEntry{Delete: &req}, func() (err error) {
+		data, err = i.delete(ctx, req)
+		return err
+	})
+	if queued {
+		return DeleteData{Id: id, Queued: true}, nil
+	}
+	return data, err
+}
+
+func (i Indexer) delete(ctx context.Context, req DeleteRequest) (DeleteData, error) {
+	body, err := json.Marshal(req)
 	if err != nil {
-		return errors.Wrap(err, "calling /index")
+		return DeleteData{}, errors.Wrap(err, "marshaling delete request")
 	}
 
-	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
-		return handleEmptyResponse(resp)
+	var data DeleteData
+	err = i.post(ctx, "/delete", body, &data)
+	return data, err
+}
+
+// Update sets partialFields on the news with id, the keys are the JSON fields of nwelastic.News. If there is no news
+// with id, the error satisfies IsNotFound. Like Index, the update is appended to the outbox if it's enabled and the
+// indexer is unavailable or news are pending, UpdateData.Queued is then true.
+func (i Indexer) Update(id string, partialFields map[string]any) (UpdateData, error) {
+	return i.UpdateContext(context.Background(), id, partialFields)
+}
+
+// UpdateContext is like Update, the request is canceled if ctx is done
+func (i Indexer) UpdateContext(ctx context.Context, id string, partialFields map[string]any) (UpdateData, error) {
+	req := UpdateRequest{Id: id, Fields: partialFields}
+	var data UpdateData
+	queued, err := i.sendOrAppend(outboxEntry{Update: &req}, func() (err error) {
+		data, err = i.update(ctx, req)
+		return err
+	})
+	if queued {
+		return UpdateData{Id: id, Queued: true}, nil
 	}
+	return data, err
+}
 
-	respApi, err := handleErrorResponse[*int](resp)
+func (i Indexer) update(ctx context.Context, req UpdateRequest) (UpdateData, error) {
+	body, err := json.Marshal(req)
 	if err != nil {
-		return errors.Wrap(err, "handling error response")
+		return UpdateData{}, errors.Wrap(err, "marshaling update request")
 	}
 
-	return errors.New(respApi.Error.Message)
+	var data UpdateData
+	err = i.post(ctx, "/update", body, &data)
+	return data, err
 }
 
-func (i Indexer) Ping() error {
-	resp, err := http.Post(i.urlWithAuth("/ping"), i.contentType, nil)
+// Upsert indexes news replacing the existing news with the same id, if any. Like Index, the upsert is appended to the
+// outbox if it's enabled and the indexer is unavailable or news are pending, UpsertData.Queued is then true.
+func (i Indexer) Upsert(news *nwelastic.News) (UpsertData, error) {
+	return i.UpsertContext(context.Background(), news)
+}
+
+// UpsertContext is like Upsert, the request is canceled if ctx is done
+func (i Indexer) UpsertContext(ctx context.Context, news *nwelastic.News) (UpsertData, error) {
+	var data UpsertData
+	queued, err := i.sendOrAppend(outboxEntry{Upsert: news}, func() (err error) {
+		data, err = i.upsert(ctx, news)
+		return err
+	})
+	if queued {
+		return UpsertData{Id: news.Id, Queued: true}, nil
+	}
+	return data, err
+}
+
+func (i Indexer) upsert(ctx context.Context, news *nwelastic.News) (UpsertData, error) {
+	body, err := json.Marshal(news)
 	if err != nil {
-		return errors.Wrap(err, "calling /ping")
+		return UpsertData{}, errors.Wrap(err, "marshaling news item")
 	}
 
-	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
-		return handleEmptyResponse(resp)
+	var data UpsertData
+	err = i.post(ctx, "/upsert", body, &data)
+	return data, err
+}
+
+// marshalBatch marshals news from fromIndex into a JSON array of at most maxBatchSize bytes, toIndex is the
+// exclusive end of the marshaled items. The array always contains at least one item.
+func (i Indexer) marshalBatch(news []*nwelastic.News, fromIndex int) (batchJson []byte, toIndex int, err error) {
+	buf := bytes.NewBufferString("[")
+	for toIndex = fromIndex; toIndex < len(news); toIndex++ {
+		newsJson, err := json.Marshal(news[toIndex])
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "marshaling news item %d", toIndex)
+		}
+
+		// +1 for the separator or the closing bracket
+		if toIndex > fromIndex && buf.Len()+len(newsJson)+1 > i.maxBatchSize {
+			break
+		}
+
+		if toIndex > fromIndex {
+			buf.WriteByte(',')
+		}
+		buf.Write(newsJson)
+	}
+	buf.WriteByte(']')
+
+	return buf.Bytes(), toIndex, nil
+}
+
+func (i Indexer) Ping() error {
+	return i.PingContext(context.Background())
+}
+
+// PingContext is like Ping, the request is canceled if ctx is done
+func (i Indexer) PingContext(ctx context.Context) error {
+	return i.post(ctx, "/ping", nil, nil)
+}
+
+// post sends body to endpoint within an "indexer <endpoint>" client span, the data of a successful response is
+// decoded into data if it isn't nil. If the request fails with a retryable error, it's retried on the next host.
+func (i Indexer) post(ctx context.Context, endpoint string, body []byte, data any) (err error) {
+	ctx, span := nwtrace.Start(ctx, "indexer "+endpoint, trace.WithSpanKind(trace.SpanKindClient))
+	defer func() {
+		err = i.scrubError(err)
+		nwtrace.EndWithError(span, err)
+	}()
+	span.SetAttributes(
+		attribute.String("http.request.method", http.MethodPost),
+		attribute.String("url.path", i.pathPrefix+endpoint),
+	)
+
+	for _, h := range i.hosts.candidates() {
+		span.SetAttributes(attribute.String("server.address", h.url))
+		err = i.postHost(ctx, h, endpoint, body, data)
+		if ctx.Err() != nil {
+			return err
+		}
+
+		i.hosts.record(h, err)
+		indexmetrics.MetricIndexerRequests.WithLabelValues(h.url, endpoint, requestResult(err)).Inc()
+		if !IsRetryable(err) {
+			return err
+		}
 	}
 
-	respApi, err := handleErrorResponse[*int](resp)
+	return err
+}
+
+// postHost sends body to endpoint of h
+func (i Indexer) postHost(ctx context.Context, h *host, endpoint string, body []byte, data any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, i.generateUrl(h, endpoint), bytes.NewReader(body))
 	if err != nil {
-		return errors.Wrap(err, "handling error response")
+		return errors.Wrap(err, "creating request")
+	}
+	req.Header.Set("Content-Type", i.contentType)
+	i.authenticate(req, body)
+	nwtrace.InjectHttp(ctx, req.Header)
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return newTransportError(errors.Wrap(err, "calling "+endpoint))
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		if data == nil {
+			return handleEmptyResponse(resp)
+		}
+
+		respApi, err := handleResponse[json.RawMessage](resp)
+		if err != nil {
+			return errors.Wrap(err, "handling response")
+		}
+
+		err = json.Unmarshal(respApi.Data, data)
+		if err != nil {
+			return errors.Wrap(err, "unmarshaling response data")
+		}
+
+		return nil
 	}
 
-	return errors.New(respApi.Error.Message)
+	return handleErrorResponse(resp)
+}
+
+// requestResult is the result label of MetricIndexerRequests
+func requestResult(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case IsRetryable(err):
+		return "retryable_error"
+	default:
+		return "permanent_error"
+	}
 }
 
 func handleResponse[T any](resp *http.Response) (response.Response[T, *int], error) {
@@ -84,26 +481,21 @@ func handleResponse[T any](resp *http.Response) (response.Response[T, *int], err
 
 	err = json.Unmarshal(respBytes, &respApi)
 	if err != nil {
-		return response.Response[T, *int]{}, errors.Wrap(err, "unmarshaling error response")
+		return response.Response[T, *int]{}, errors.Wrap(err, "unmarshaling response")
 	}
 
 	return respApi, nil
 }
 
-func handleErrorResponse[T any](resp *http.Response) (response.Response[*int, T], error) {
+// handleErrorResponse reads a non 2xx response into an *Error
+func handleErrorResponse(resp *http.Response) error {
 	defer resp.Body.Close()
-	var respApi response.Response[*int, T]
 	respBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return response.Response[*int, T]{}, errors.Wrap(err, "reading response body")
+		return newTransportError(errors.Wrap(err, "reading error response body"))
 	}
 
-	err = json.Unmarshal(respBytes, &respApi)
-	if err != nil {
-		return response.Response[*int, T]{}, errors.Wrap(err, "unmarshaling error response")
-	}
-
-	return respApi, nil
+	return newResponseError(resp.StatusCode, respBytes)
 }
 
 // handleEmptyResponse makes sure that response Body is read and closed so that the tcp connection can be reused
@@ -113,10 +505,6 @@ func handleEmptyResponse(resp *http.Response) error {
 	return nil
 }
 
-func (i Indexer) urlWithAuth(endpoint string) string {
-	return i.generateUrl(endpoint) + "?apiKey=" + i.apiKey
-}
-
-func (i Indexer) generateUrl(endpoint string) string {
-	return i.host + i.pathPrefix + endpoint
+func (i Indexer) generateUrl(h *host, endpoint string) string {
+	return h.url + i.pathPrefix + endpoint
 }
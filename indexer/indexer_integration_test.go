@@ -52,11 +52,14 @@ func TestIndexer_Index_integration(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			i := new(Config{
+			i, err := new(Config{
 				Host:   integrationCfg().Indexer.Host,
 				ApiKey: integrationCfg().Indexer.ApiKey,
 			})
-			err := i.Index(&nwelastic.News{Id: "1"})
+			if err != nil {
+				t.Fatalf("creating indexer: %s", err)
+			}
+			err = i.Index(&nwelastic.News{Id: "1"})
 			if err != nil || tt.expectedErr != nil {
 				if tt.expectedErr == nil || err.Error() != tt.expectedErr.Error() {
 					t.Fatalf("error is not as expected, got '%s', expected '%s'", err, tt.expectedErr)
@@ -1,14 +1,19 @@
 package indexer
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 
 	"github.com/encypher-studio/newsware-utils/api/response"
 	"github.com/encypher-studio/newsware-utils/nwelastic"
+	"github.com/encypher-studio/newsware-utils/nwtrace"
+	"github.com/encypher-studio/newsware-utils/nwtrace/nwtracetest"
 )
 
 func init() {
@@ -47,7 +52,7 @@ func TestIndexer_Index(t *testing.T) {
 				w.Write(marshalUnsafe(tt.response))
 			}))
 
-			i := new(Config{
+			i := mustNew(t, Config{
 				Host:   server.URL,
 				ApiKey: "",
 			})
@@ -91,7 +96,7 @@ func TestIndexer_Index_Load(t *testing.T) {
 				r.Body.Close()
 			}))
 
-			i := new(Config{
+			i := mustNew(t, Config{
 				Host:   server.URL,
 				ApiKey: "",
 			})
@@ -110,6 +115,186 @@ func TestIndexer_Index_Load(t *testing.T) {
 	}
 }
 
+func TestIndexer_IndexContext_tracing(t *testing.T) {
+	exporter := nwtracetest.Install(t)
+
+	var actualTraceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actualTraceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusOK)
+		w.Write(marshalUnsafe(response.Response[*int, *int]{}))
+	}))
+	defer server.Close()
+
+	ctx, parent := nwtrace.Start(context.Background(), "parent")
+	i := mustNew(t, Config{Host: server.URL})
+	err := i.IndexContext(ctx, &nwelastic.News{})
+	parent.End()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %v", nwtracetest.SpanNames(exporter))
+	}
+	indexSpan := spans[0]
+	if indexSpan.Name != "indexer /index" {
+		t.Fatalf("span name is not as expected, got '%s'", indexSpan.Name)
+	}
+	if indexSpan.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Fatalf("index span is not a child of the caller span")
+	}
+
+	expectedTraceparent := "00-" + indexSpan.SpanContext.TraceID().String() + "-" + indexSpan.SpanContext.SpanID().String() + "-01"
+	if actualTraceparent != expectedTraceparent {
+		t.Fatalf("traceparent header is not as expected, got '%s', expected '%s'", actualTraceparent, expectedTraceparent)
+	}
+}
+
+func TestIndexer_IndexBatch(t *testing.T) {
+	type callbackArgs struct {
+		totalIndexed int
+		lastIndex    int
+	}
+	tests := []struct {
+		name              string
+		news              []*nwelastic.News
+		maxBatchSize      int
+		maxIndexedPerCall int // Simulates a partial failure when a request has more news
+		failWithoutData   bool
+		rejectedId        string // Rejected by the indexer as a failure of the batch
+		expectedCalls     int
+		expectedCallbacks []callbackArgs
+		expectedErr       error
+	}{
+		{
+			name:              "single batch",
+			news:              []*nwelastic.News{{Id: "1"}, {Id: "2"}, {Id: "3"}},
+			expectedCalls:     1,
+			expectedCallbacks: []callbackArgs{{3, 2}},
+		},
+		{
+			name:              "split by size",
+			news:              []*nwelastic.News{{Id: "1"}, {Id: "2"}, {Id: "3"}},
+			maxBatchSize:      10,
+			expectedCalls:     3,
+			expectedCallbacks: []callbackArgs{{1, 0}, {1, 1}, {1, 2}},
+		},
+		{
+			name:              "resume after partial failure",
+			news:              []*nwelastic.News{{Id: "1"}, {Id: "2"}, {Id: "3"}},
+			maxIndexedPerCall: 2,
+			expectedCalls:     2,
+			expectedCallbacks: []callbackArgs{{2, 1}, {1, 2}},
+		},
+		{
+			name:              "rejected news",
+			news:              []*nwelastic.News{{Id: "1"}, {Id: "2"}, {Id: "3"}},
+			maxBatchSize:      10,
+			rejectedId:        "2",
+			expectedCalls:     3,
+			expectedCallbacks: []callbackArgs{{1, 0}, {0, 1}, {1, 2}},
+			expectedErr:       errors.New("1 news failed to index, the first is 2: rejected"),
+		},
+		{
+			name:              "failure without progress",
+			news:              []*nwelastic.News{{Id: "1"}, {Id: "2"}},
+			failWithoutData:   true,
+			expectedCalls:     1,
+			expectedCallbacks: nil,
+			expectedErr:       errors.New("test"),
+		},
+		{
+			name:          "empty",
+			news:          []*nwelastic.News{},
+			expectedCalls: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if r.URL.Path != "/api/v1/index/batch" {
+					t.Fatalf("unexpected path %s", r.URL.Path)
+				}
+
+				var news []*nwelastic.News
+				if err := json.NewDecoder(r.Body).Decode(&news); err != nil {
+					t.Fatalf("decoding request: %s", err)
+				}
+
+				if tt.failWithoutData {
+					w.WriteHeader(http.StatusInternalServerError)
+					w.Write(marshalUnsafe(response.ErrorExplicit[*int]("test_code", "test", nil)))
+					return
+				}
+
+				for i, n := range news {
+					if n.Id != tt.rejectedId {
+						continue
+					}
+					w.WriteHeader(http.StatusUnprocessableEntity)
+					w.Write(marshalUnsafe(response.ErrorExplicit("test_code", "test", IndexBatchData{
+						TotalIndexed: len(news) - 1,
+						LastIndex:    len(news) - 1,
+						Failures:     []IndexBatchFailure{{Index: i, Id: n.Id, Status: http.StatusBadRequest, Reason: "rejected"}},
+					})))
+					return
+				}
+
+				if tt.maxIndexedPerCall > 0 && len(news) > tt.maxIndexedPerCall {
+					w.WriteHeader(http.StatusInternalServerError)
+					w.Write(marshalUnsafe(response.ErrorExplicit("test_code", "test", IndexBatchData{
+						TotalIndexed: tt.maxIndexedPerCall,
+						LastIndex:    tt.maxIndexedPerCall - 1,
+					})))
+					return
+				}
+
+				w.WriteHeader(http.StatusOK)
+				w.Write(marshalUnsafe(response.SuccessWithData(IndexBatchData{
+					TotalIndexed: len(news),
+					LastIndex:    len(news) - 1,
+				})))
+			}))
+			defer server.Close()
+
+			i := mustNew(t, Config{Host: server.URL, MaxBatchSize: tt.maxBatchSize})
+			var actualCallbacks []callbackArgs
+			err := i.IndexBatch(tt.news, func(totalIndexed int, lastIndex int) {
+				actualCallbacks = append(actualCallbacks, callbackArgs{totalIndexed, lastIndex})
+			})
+			if err != nil || tt.expectedErr != nil {
+				if tt.expectedErr == nil || err == nil || err.Error() != tt.expectedErr.Error() {
+					t.Fatalf("error is not as expected, got '%s', expected '%s'", err, tt.expectedErr)
+				}
+			}
+
+			if calls != tt.expectedCalls {
+				t.Fatalf("calls = %d, expected %d", calls, tt.expectedCalls)
+			}
+			if !reflect.DeepEqual(tt.expectedCallbacks, actualCallbacks) {
+				t.Fatalf("callbacks = %v, expected %v", actualCallbacks, tt.expectedCallbacks)
+			}
+		})
+	}
+}
+
+func TestIndexer_IndexBatch_nilCallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(marshalUnsafe(response.SuccessWithData(IndexBatchData{TotalIndexed: 1, LastIndex: 0})))
+	}))
+	defer server.Close()
+
+	i := mustNew(t, Config{Host: server.URL})
+	err := i.IndexBatch([]*nwelastic.News{{Id: "1"}}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
 func TestIndexer_New(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -155,6 +340,97 @@ func TestIndexer_New(t *testing.T) {
 	}
 }
 
+func TestIndexer_writeOperations(t *testing.T) {
+	tests := []struct {
+		name             string
+		call             func(i Indexer) (any, error)
+		responseCode     int
+		responseData     any
+		expectedPath     string
+		expectedBody     string
+		expectedData     any
+		expectedNotFound bool
+	}{
+		{
+			name:         "delete",
+			call:         func(i Indexer) (any, error) { return i.Delete("1") },
+			responseCode: http.StatusOK,
+			responseData: DeleteData{Id: "1", Found: true},
+			expectedPath: "/api/v1/delete",
+			expectedBody: `{"id":"1"}`,
+			expectedData: DeleteData{Id: "1", Found: true},
+		},
+		{
+			name: "update",
+			call: func(i Indexer) (any, error) {
+				return i.Update("1", map[string]any{"headline": "corrected"})
+			},
+			responseCode: http.StatusOK,
+			responseData: UpdateData{Id: "1", Updated: true},
+			expectedPath: "/api/v1/update",
+			expectedBody: `{"id":"1","fields":{"headline":"corrected"}}`,
+			expectedData: UpdateData{Id: "1", Updated: true},
+		},
+		{
+			name: "update not found",
+			call: func(i Indexer) (any, error) {
+				return i.Update("1", map[string]any{"headline": "corrected"})
+			},
+			responseCode:     http.StatusNotFound,
+			expectedPath:     "/api/v1/update",
+			expectedBody:     `{"id":"1","fields":{"headline":"corrected"}}`,
+			expectedData:     UpdateData{},
+			expectedNotFound: true,
+		},
+		{
+			name:         "upsert",
+			call:         func(i Indexer) (any, error) { return i.Upsert(&nwelastic.News{Id: "1"}) },
+			responseCode: http.StatusOK,
+			responseData: UpsertData{Id: "1", Created: true},
+			expectedPath: "/api/v1/upsert",
+			expectedBody: string(marshalUnsafe(&nwelastic.News{Id: "1"})),
+			expectedData: UpsertData{Id: "1", Created: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tt.expectedPath {
+					t.Errorf("path = %s, expected %s", r.URL.Path, tt.expectedPath)
+				}
+				body, _ := io.ReadAll(r.Body)
+				if string(body) != tt.expectedBody {
+					t.Errorf("body = %s, expected %s", body, tt.expectedBody)
+				}
+
+				w.WriteHeader(tt.responseCode)
+				if tt.responseCode != http.StatusOK {
+					w.Write(marshalUnsafe(response.ErrorExplicit[*int]("not_found", "news not found", nil)))
+					return
+				}
+				w.Write(marshalUnsafe(response.SuccessWithData(tt.responseData)))
+			}))
+			defer server.Close()
+
+			actualData, err := tt.call(mustNew(t, Config{Host: server.URL}))
+			if IsNotFound(err) != tt.expectedNotFound || (err != nil && !tt.expectedNotFound) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(tt.expectedData, actualData) {
+				t.Fatalf("data = %+v, expected %+v", actualData, tt.expectedData)
+			}
+		})
+	}
+}
+
+func mustNew(t *testing.T, config Config) Indexer {
+	i, err := new(config)
+	if err != nil {
+		t.Fatalf("creating indexer: %s", err)
+	}
+	return i
+}
+
 func marshalUnsafe(value interface{}) []byte {
 	bytes, _ := json.Marshal(value)
 	return bytes
@@ -0,0 +1,312 @@
+// Package indexerserver serves the indexer API used by indexer.Indexer, writing news through a Writer such as
+// nwelastic.NewsRepository. It can run a local indexer for tests and small deployments.
+package indexerserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/encypher-studio/newsware-utils/api"
+	"github.com/encypher-studio/newsware-utils/api/apierror"
+	"github.com/encypher-studio/newsware-utils/api/response"
+	"github.com/encypher-studio/newsware-utils/ecslogger"
+	"github.com/encypher-studio/newsware-utils/indexer"
+	"github.com/encypher-studio/newsware-utils/nwelastic"
+	"github.com/gofiber/fiber/v3"
+)
+
+const (
+	defaultMaxSignatureSkew = 5 * time.Minute
+	// defaultBodyLimit is above the default indexer.Config.MaxBatchSize of 10MB
+	defaultBodyLimit = 16 << 20
+)
+
+var (
+	ErrUnauthorized     = apierror.New("unauthorized", "invalid api key", fiber.StatusUnauthorized)
+	ErrInvalidSignature = apierror.New("invalid_signature", "invalid request signature", fiber.StatusUnauthorized)
+	ErrInvalidBody      = apierror.New("invalid_body", "invalid request body", fiber.StatusBadRequest)
+	ErrIndexingFailed   = apierror.New("indexing_failed", "indexing failed", fiber.StatusInternalServerError)
+	// ErrBatchIndexingFailed has the indexer.IndexBatchData of the news indexed before the failure, if any
+	ErrBatchIndexingFailed = apierror.NewWithData[*indexer.IndexBatchData]("indexing_failed", "indexing failed", fiber.StatusInternalServerError)
+	// ErrBatchNewsFailed has the indexer.IndexBatchData of the batch, with the news the writer rejected as Failures
+	ErrBatchNewsFailed = apierror.NewWithData[*indexer.IndexBatchData]("news_failed", "some news weren't indexed", fiber.StatusUnprocessableEntity)
+	ErrNewsNotFound    = apierror.New(indexer.CodeNotFound, "news not found", fiber.StatusNotFound)
+	ErrDeleteFailed    = apierror.New("delete_failed", "delete failed", fiber.StatusInternalServerError)
+	ErrUpdateFailed    = apierror.New("update_failed", "update failed", fiber.StatusInternalServerError)
+	ErrNotSupported    = apierror.New("not_supported", "the writer doesn't support this operation", fiber.StatusNotImplemented)
+)
+
+// Writer stores news, it's implemented by nwelastic.NewsRepository
+type Writer interface {
+	Insert(news *nwelastic.News) error
+	InsertBatch(news []*nwelastic.News, insertedCallback func(totalIndexed int, lastIndex int)) error
+}
+
+// writerErrors maps the errors of the Writer that would fail again if the request is sent again to a status the client
+// doesn't retry, other errors are answered with a 500 and retried
+var writerErrors = []struct {
+	code    string
+	message string
+	status  int
+	match   func(err error) bool
+}{
+	{"conflict", "news conflicts with the stored news", fiber.StatusConflict, func(err error) bool {
+		return errors.Is(err, nwelastic.ErrVersionConflict) || errors.Is(err, nwelastic.ErrAlreadyExists)
+	}},
+	{"news_too_large", "news is too large", fiber.StatusRequestEntityTooLarge, func(err error) bool {
+		return errors.Is(err, nwelastic.ErrNewsTooLarge)
+	}},
+	{"invalid_news", "news rejected by the writer", fiber.StatusBadRequest, func(err error) bool {
+		var esErr *types.ElasticsearchError
+		return errors.As(err, &esErr) && esErr.Status == fiber.StatusBadRequest
+	}},
+}
+
+// writerError returns the error of writerErrors matching err, or fallback
+func writerError[T any](err error, fallback apierror.ApiError[T]) apierror.ApiError[T] {
+	for _, writerErr := range writerErrors {
+		if writerErr.match(err) {
+			return apierror.NewWithData[T](writerErr.code, writerErr.message, writerErr.status).With(err)
+		}
+	}
+	return fallback.With(err)
+}
+
+// Updater deletes and updates stored news, it's implemented by nwelastic.NewsRepository. The delete, update and upsert
+// routes respond with ErrNotSupported if the Writer doesn't implement it.
+type Updater interface {
+	Upsert(ctx context.Context, news *nwelastic.News) (created bool, err error)
+	Delete(ctx context.Context, id string) error
+	Update(ctx context.Context, id string, fields map[string]any) (bool, error)
+}
+
+var (
+	_ Writer  = nwelastic.NewsRepository{}
+	_ Updater = nwelastic.NewsRepository{}
+)
+
+type Config struct {
+	// ApiKeys are the keys accepted in the indexer.HeaderApiKey header, authentication is disabled if empty
+	ApiKeys []string `yaml:"apiKeys"`
+	// AllowQueryApiKey also accepts the key in the apiKey query parameter, for clients using indexer.AuthModeQuery
+	AllowQueryApiKey bool `yaml:"allowQueryApiKey"`
+	// SigningSecret, if set, requires every request to be signed with indexer.Sign
+	SigningSecret string `yaml:"signingSecret"`
+	// MaxSignatureSkew is the maximum difference between the signature timestamp and the server time, defaults to 5m
+	MaxSignatureSkew time.Duration `yaml:"maxSignatureSkew"`
+	// BodyLimit is the maximum request size in bytes, defaults to 16MB
+	BodyLimit int `yaml:"bodyLimit"`
+}
+
+// New returns a fiber app serving the indexer API under /api/v1
+func New(writer Writer, config Config, logger ecslogger.ILogger) *fiber.App {
+	if config.MaxSignatureSkew <= 0 {
+		config.MaxSignatureSkew = defaultMaxSignatureSkew
+	}
+	if config.BodyLimit <= 0 {
+		config.BodyLimit = defaultBodyLimit
+	}
+
+	app := fiber.New(fiber.Config{
+		ErrorHandler: api.ErrorHandler(logger),
+		BodyLimit:    config.BodyLimit,
+	})
+
+	h := handlers{writer: writer}
+	router := app.Group("/api/v1", authenticate(config))
+	router.Post("/ping", h.ping)
+	router.Post("/index", h.index)
+	router.Post("/index/batch", h.indexBatch)
+	router.Post("/delete", h.delete)
+	router.Post("/update", h.update)
+	router.Post("/upsert", h.upsert)
+
+	return app
+}
+
+// authenticate checks the api key and, if a signing secret is configured, the request signature
+func authenticate(config Config) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if len(config.ApiKeys) > 0 {
+			apiKey := c.Get(indexer.HeaderApiKey)
+			if apiKey == "" && config.AllowQueryApiKey {
+				apiKey = c.Query("apiKey")
+			}
+			if !validApiKey(config.ApiKeys, apiKey) {
+				return ErrUnauthorized
+			}
+		}
+
+		if config.SigningSecret != "" {
+			err := indexer.VerifySignature(
+				config.SigningSecret,
+				c.Get(indexer.HeaderTimestamp),
+				c.Get(indexer.HeaderSignature),
+				c.Method(),
+				c.Path(),
+				c.Body(),
+				config.MaxSignatureSkew,
+				time.Now(),
+			)
+			if err != nil {
+				return ErrInvalidSignature.With(err)
+			}
+		}
+
+		return c.Next()
+	}
+}
+
+func validApiKey(apiKeys []string, apiKey string) bool {
+	valid := false
+	for _, key := range apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+type handlers struct {
+	writer Writer
+}
+
+func (h handlers) ping(c fiber.Ctx) error {
+	return c.JSON(response.Success())
+}
+
+func (h handlers) index(c fiber.Ctx) error {
+	var news nwelastic.News
+	if err := json.Unmarshal(c.Body(), &news); err != nil {
+		return ErrInvalidBody.With(err)
+	}
+
+	if err := h.writer.Insert(&news); err != nil {
+		return writerError(err, ErrIndexingFailed)
+	}
+
+	return c.JSON(response.Success())
+}
+
+// indexBatch responds with the indexer.IndexBatchData of the request, if indexing fails after some news were indexed
+// the error has it as data so the client resumes after the last one. News rejected individually are answered with
+// ErrBatchNewsFailed.
+func (h handlers) indexBatch(c fiber.Ctx) error {
+	var news []*nwelastic.News
+	if err := json.Unmarshal(c.Body(), &news); err != nil {
+		return ErrInvalidBody.With(err)
+	}
+	if len(news) == 0 {
+		return apierror.New(ErrInvalidBody.Code(), "batch is empty", ErrInvalidBody.StatusCode())
+	}
+
+	data := indexer.IndexBatchData{LastIndex: -1}
+	err := h.writer.InsertBatch(news, func(totalIndexed int, lastIndex int) {
+		data.TotalIndexed += totalIndexed
+		data.LastIndex = lastIndex
+	})
+	var bulkErr *nwelastic.BulkError
+	if errors.As(err, &bulkErr) {
+		for _, failure := range bulkErr.Failures {
+			data.Failures = append(data.Failures, indexer.IndexBatchFailure{
+				Index:  failure.Index,
+				Id:     failure.Id,
+				Status: failureStatus(failure),
+				Type:   failure.Type,
+				Reason: failure.Reason,
+			})
+		}
+		return ErrBatchNewsFailed.With(err).SetData(&data)
+	}
+	if err != nil {
+		if data.TotalIndexed == 0 {
+			return writerError(err, ErrBatchIndexingFailed)
+		}
+		return writerError(err, ErrBatchIndexingFailed).SetData(&data)
+	}
+
+	return c.JSON(response.SuccessWithData(data))
+}
+
+// failureStatus returns the status of a news rejected in a batch, failures of whole bulk requests have no status and
+// are answered as unavailable so the client retries them
+func failureStatus(failure nwelastic.BulkFailure) int {
+	switch {
+	case failure.Status != 0:
+		return failure.Status
+	case failure.Type == "news_too_large":
+		return fiber.StatusRequestEntityTooLarge
+	}
+	return fiber.StatusServiceUnavailable
+}
+
+// delete responds with indexer.DeleteData, Found is false if there was no news with the id
+func (h handlers) delete(c fiber.Ctx) error {
+	updater, ok := h.writer.(Updater)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	var req indexer.DeleteRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil || req.Id == "" {
+		return ErrInvalidBody.With(err)
+	}
+
+	data := indexer.DeleteData{Id: req.Id, Found: true}
+	err := updater.Delete(c.Context(), req.Id)
+	if errors.Is(err, nwelastic.ErrNewsNotFound) {
+		data.Found = false
+	} else if err != nil {
+		return writerError(err, ErrDeleteFailed)
+	}
+
+	return c.JSON(response.SuccessWithData(data))
+}
+
+// update responds with indexer.UpdateData, or ErrNewsNotFound if there is no news with the id
+func (h handlers) update(c fiber.Ctx) error {
+	updater, ok := h.writer.(Updater)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	var req indexer.UpdateRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil || req.Id == "" || len(req.Fields) == 0 {
+		return ErrInvalidBody.With(err)
+	}
+
+	updated, err := updater.Update(c.Context(), req.Id, req.Fields)
+	if errors.Is(err, nwelastic.ErrNewsNotFound) {
+		return ErrNewsNotFound.With(err)
+	}
+	if err != nil {
+		return writerError(err, ErrUpdateFailed)
+	}
+
+	return c.JSON(response.SuccessWithData(indexer.UpdateData{Id: req.Id, Updated: updated}))
+}
+
+// upsert writes the news through Updater.Upsert and responds with indexer.UpsertData. Created is false if a news with
+// the same id existed before.
+func (h handlers) upsert(c fiber.Ctx) error {
+	updater, ok := h.writer.(Updater)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	var news nwelastic.News
+	if err := json.Unmarshal(c.Body(), &news); err != nil {
+		return ErrInvalidBody.With(err)
+	}
+
+	created, err := updater.Upsert(c.Context(), &news)
+	if err != nil {
+		return writerError(err, ErrIndexingFailed)
+	}
+
+	return c.JSON(response.SuccessWithData(indexer.UpsertData{Id: news.Id, Created: created}))
+}
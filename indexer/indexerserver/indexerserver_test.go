@@ -0,0 +1,348 @@
+package indexerserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/encypher-studio/newsware-utils/ecslogger"
+	"github.com/encypher-studio/newsware-utils/indexer"
+	"github.com/encypher-studio/newsware-utils/nwelastic"
+	"github.com/gofiber/fiber/v3"
+	"go.uber.org/zap/zapcore"
+)
+
+// memoryWriter stores news in memory, it fails every insert after maxInserts news if maxInserts is positive, or with
+// err if it's set. News with rejectId are reported as failures of the batch.
+type memoryWriter struct {
+	mutex      sync.Mutex
+	news       []string
+	maxInserts int
+	err        error
+	rejectId   string
+}
+
+func (w *memoryWriter) Insert(news *nwelastic.News) error {
+	return w.InsertBatch([]*nwelastic.News{news}, func(int, int) {})
+}
+
+// InsertBatch inserts news one by one like sub-batches of nwelastic.NewsRepository.InsertBatch
+func (w *memoryWriter) InsertBatch(news []*nwelastic.News, insertedCallback func(totalIndexed int, lastIndex int)) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	var failures []nwelastic.BulkFailure
+	for i, n := range news {
+		if n.Id == w.rejectId {
+			failures = append(failures, nwelastic.BulkFailure{Id: n.Id, Index: i, Status: http.StatusBadRequest, Type: "mapper_parsing_exception", Reason: "rejected"})
+			insertedCallback(0, i)
+			continue
+		}
+		if w.maxInserts > 0 && len(w.news) >= w.maxInserts {
+			return errors.New("writer is full")
+		}
+		w.news = append(w.news, n.Id)
+		insertedCallback(1, i)
+	}
+	if len(failures) > 0 {
+		return &nwelastic.BulkError{Failures: failures}
+	}
+	return nil
+}
+
+func (w *memoryWriter) Upsert(ctx context.Context, news *nwelastic.News) (bool, error) {
+	exists, _ := w.Exists(ctx, news.Id)
+	if err := w.Insert(news); err != nil {
+		return false, err
+	}
+	return !exists, nil
+}
+
+func (w *memoryWriter) Exists(ctx context.Context, id string) (bool, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return slices.Contains(w.news, id), nil
+}
+
+func (w *memoryWriter) Delete(ctx context.Context, id string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !slices.Contains(w.news, id) {
+		return nwelastic.ErrNewsNotFound
+	}
+	w.news = slices.DeleteFunc(w.news, func(newsId string) bool { return newsId == id })
+	return nil
+}
+
+func (w *memoryWriter) Update(ctx context.Context, id string, fields map[string]any) (bool, error) {
+	exists, _ := w.Exists(ctx, id)
+	if !exists {
+		return false, nwelastic.ErrNewsNotFound
+	}
+	return true, nil
+}
+
+func TestServer_indexerClient(t *testing.T) {
+	tests := []struct {
+		name         string
+		serverConfig Config
+		clientConfig indexer.Config
+		maxInserts   int
+		batch        []*nwelastic.News
+		expectedNews []string
+		expectedErr  bool
+	}{
+		{
+			name:         "no authentication",
+			batch:        []*nwelastic.News{{Id: "2"}, {Id: "3"}},
+			expectedNews: []string{"1", "2", "3"},
+		},
+		{
+			name:         "api key in header",
+			serverConfig: Config{ApiKeys: []string{"old", "key"}},
+			clientConfig: indexer.Config{ApiKey: "key"},
+			batch:        []*nwelastic.News{{Id: "2"}},
+			expectedNews: []string{"1", "2"},
+		},
+		{
+			name:         "api key in query",
+			serverConfig: Config{ApiKeys: []string{"key"}, AllowQueryApiKey: true},
+			clientConfig: indexer.Config{ApiKey: "key", AuthMode: indexer.AuthModeQuery},
+			batch:        []*nwelastic.News{{Id: "2"}},
+			expectedNews: []string{"1", "2"},
+		},
+		{
+			name:         "signed requests",
+			serverConfig: Config{ApiKeys: []string{"key"}, SigningSecret: "secret"},
+			clientConfig: indexer.Config{ApiKey: "key", SigningSecret: "secret"},
+			batch:        []*nwelastic.News{{Id: "2"}},
+			expectedNews: []string{"1", "2"},
+		},
+		{
+			name:         "partial batch failure",
+			maxInserts:   2,
+			batch:        []*nwelastic.News{{Id: "2"}, {Id: "3"}},
+			expectedNews: []string{"1", "2"},
+			expectedErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer := &memoryWriter{maxInserts: tt.maxInserts}
+			tt.clientConfig.Host = serve(t, New(writer, tt.serverConfig, newLogger(t)))
+
+			client, err := indexer.New(tt.clientConfig)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			err = client.Index(&nwelastic.News{Id: "1"})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			indexed := 0
+			err = client.IndexBatch(tt.batch, func(totalIndexed int, lastIndex int) {
+				indexed += totalIndexed
+			})
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if indexed != len(tt.expectedNews)-1 {
+				t.Fatalf("indexed = %d, expected %d", indexed, len(tt.expectedNews)-1)
+			}
+
+			if !reflect.DeepEqual(tt.expectedNews, writer.news) {
+				t.Fatalf("news = %v, expected %v", writer.news, tt.expectedNews)
+			}
+		})
+	}
+}
+
+func TestServer_rejectedNews(t *testing.T) {
+	writer := &memoryWriter{rejectId: "2"}
+	client, err := indexer.New(indexer.Config{Host: serve(t, New(writer, Config{}, newLogger(t)))})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	err = client.IndexBatch([]*nwelastic.News{{Id: "1"}, {Id: "2"}, {Id: "3"}}, nil)
+	var batchErr *indexer.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected a batch error, got '%v'", err)
+	}
+	expectedFailures := []indexer.IndexBatchFailure{{Index: 1, Id: "2", Status: http.StatusBadRequest, Type: "mapper_parsing_exception", Reason: "rejected"}}
+	if !reflect.DeepEqual(expectedFailures, batchErr.Failures) {
+		t.Fatalf("failures = %+v, expected %+v", batchErr.Failures, expectedFailures)
+	}
+	if !indexer.IsPermanent(batchErr.Failures[0].Err()) {
+		t.Fatalf("expected a permanent failure, got '%v'", batchErr.Failures[0].Err())
+	}
+	if expectedNews := []string{"1", "3"}; !reflect.DeepEqual(expectedNews, writer.news) {
+		t.Fatalf("news = %v, expected %v", writer.news, expectedNews)
+	}
+}
+
+func TestServer_writeOperations(t *testing.T) {
+	writer := &memoryWriter{}
+	client, err := indexer.New(indexer.Config{Host: serve(t, New(writer, Config{}, newLogger(t)))})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	upsertData, err := client.Upsert(&nwelastic.News{Id: "1"})
+	if err != nil || upsertData != (indexer.UpsertData{Id: "1", Created: true}) {
+		t.Fatalf("unexpected upsert result %+v, '%v'", upsertData, err)
+	}
+	upsertData, err = client.Upsert(&nwelastic.News{Id: "1"})
+	if err != nil || upsertData != (indexer.UpsertData{Id: "1", Created: false}) {
+		t.Fatalf("unexpected upsert result %+v, '%v'", upsertData, err)
+	}
+
+	updateData, err := client.Update("1", map[string]any{"headline": "corrected"})
+	if err != nil || updateData != (indexer.UpdateData{Id: "1", Updated: true}) {
+		t.Fatalf("unexpected update result %+v, '%v'", updateData, err)
+	}
+	_, err = client.Update("2", map[string]any{"headline": "corrected"})
+	if !indexer.IsNotFound(err) {
+		t.Fatalf("expected a not found error, got '%v'", err)
+	}
+
+	deleteData, err := client.Delete("1")
+	if err != nil || deleteData != (indexer.DeleteData{Id: "1", Found: true}) {
+		t.Fatalf("unexpected delete result %+v, '%v'", deleteData, err)
+	}
+	deleteData, err = client.Delete("1")
+	if err != nil || deleteData != (indexer.DeleteData{Id: "1", Found: false}) {
+		t.Fatalf("unexpected delete result %+v, '%v'", deleteData, err)
+	}
+}
+
+func TestServer_writerErrors(t *testing.T) {
+	tests := []struct {
+		name              string
+		err               error
+		expectedStatus    int
+		expectedRetryable bool
+	}{
+		{"version conflict", fmt.Errorf("news 1: %w", nwelastic.ErrVersionConflict), http.StatusConflict, false},
+		{"already exists", fmt.Errorf("news 1: %w", nwelastic.ErrAlreadyExists), http.StatusConflict, false},
+		{"too large", fmt.Errorf("news 1: %w", nwelastic.ErrNewsTooLarge), http.StatusRequestEntityTooLarge, false},
+		{"mapping error", &types.ElasticsearchError{Status: http.StatusBadRequest, ErrorCause: types.ErrorCause{Type: "mapper_parsing_exception"}}, http.StatusBadRequest, false},
+		{"unavailable", errors.New("connection refused"), http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer := &memoryWriter{err: tt.err}
+			client, err := indexer.New(indexer.Config{Host: serve(t, New(writer, Config{}, newLogger(t)))})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			for _, err := range []error{
+				client.Index(&nwelastic.News{Id: "1"}),
+				client.IndexBatch([]*nwelastic.News{{Id: "1"}}, nil),
+			} {
+				if indexer.StatusCode(err) != tt.expectedStatus || indexer.IsRetryable(err) != tt.expectedRetryable {
+					t.Fatalf("expected status %d, retryable %v, got '%v'", tt.expectedStatus, tt.expectedRetryable, err)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_authentication(t *testing.T) {
+	tests := []struct {
+		name         string
+		serverConfig Config
+		clientConfig indexer.Config
+	}{
+		{
+			name:         "missing api key",
+			serverConfig: Config{ApiKeys: []string{"key"}},
+		},
+		{
+			name:         "wrong api key",
+			serverConfig: Config{ApiKeys: []string{"key"}},
+			clientConfig: indexer.Config{ApiKey: "wrong"},
+		},
+		{
+			name:         "query api key not allowed",
+			serverConfig: Config{ApiKeys: []string{"key"}},
+			clientConfig: indexer.Config{ApiKey: "key", AuthMode: indexer.AuthModeQuery},
+		},
+		{
+			name:         "unsigned request",
+			serverConfig: Config{SigningSecret: "secret"},
+		},
+		{
+			name:         "wrong signing secret",
+			serverConfig: Config{SigningSecret: "secret"},
+			clientConfig: indexer.Config{SigningSecret: "wrong"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer := &memoryWriter{}
+			tt.clientConfig.Host = serve(t, New(writer, tt.serverConfig, newLogger(t)))
+
+			_, err := indexer.New(tt.clientConfig)
+			if indexer.StatusCode(err) != http.StatusUnauthorized || !indexer.IsConfigurationError(err) {
+				t.Fatalf("expected an unauthorized configuration error, got '%v'", err)
+			}
+		})
+	}
+}
+
+func TestServer_invalidBody(t *testing.T) {
+	host := serve(t, New(&memoryWriter{}, Config{}, newLogger(t)))
+	client, err := indexer.New(indexer.Config{Host: host})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	err = client.IndexBatch([]*nwelastic.News{}, func(int, int) {})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	resp, err := http.Post(host+"/api/v1/index", "application/json", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status = %d, expected %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+// serve starts app on a random port and returns its url
+func serve(t *testing.T, app *fiber.App) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+	go app.Listener(listener, fiber.ListenConfig{DisableStartupMessage: true})
+	t.Cleanup(func() {
+		app.Shutdown()
+	})
+
+	return "http://" + listener.Addr().String()
+}
+
+func newLogger(t *testing.T) ecslogger.ILogger {
+	logger, err := ecslogger.New(ecslogger.Config{Level: zapcore.FatalLevel})
+	if err != nil {
+		t.Fatalf("creating logger: %s", err)
+	}
+	return logger
+}
@@ -0,0 +1,499 @@
+package indexer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/encypher-studio/newsware-utils/indexmetrics"
+	"github.com/encypher-studio/newsware-utils/nwelastic"
+	"github.com/pkg/errors"
+)
+
+const (
+	outboxSegmentExt  = ".ndjson"
+	outboxCursorFile  = "cursor.json"
+	outboxDeadLetters = "dead.ndjson"
+)
+
+// outboxEntry is a line of a segment file, it holds the news to index or the delete or update request to send
+type outboxEntry struct {
+	EnqueuedAt time.Time       `json:"enqueuedAt"`
+	News       *nwelastic.News `json:"news,omitempty"`
+	Delete     *DeleteRequest  `json:"delete,omitempty"`
+	Update     *UpdateRequest  `json:"update,omitempty"`
+	Upsert     *nwelastic.News `json:"upsert,omitempty"`
+}
+
+// outboxDeadLetter is a line of the dead letters file, it holds entries the indexer rejected during replay
+type outboxDeadLetter struct {
+	FailedAt time.Time `json:"failedAt"`
+	Error    string    `json:"error"`
+	outboxEntry
+}
+
+// outboxCursor is the position of the next entry to replay
+type outboxCursor struct {
+	Segment int64 `json:"segment"`
+	Offset  int64 `json:"offset"`
+}
+
+// outbox durably stores news, deletes, updates and upserts in append-only segment files while the indexer is unavailable. Entries are replayed in
+// order and the position of the next entry is persisted in the cursor file, so delivery is at-least-once across
+// restarts.
+type outbox struct {
+	dir         string
+	segmentSize int64
+	mutex       *sync.Mutex
+	// ordering is held by Indexer.sendOrAppend from checking pending until the entry is appended, it isn't held while
+	// sending to an empty outbox
+	ordering *sync.Mutex
+	// segments are the sequence numbers of the segment files, oldest first. The last one is appended to.
+	segments   []int64
+	writer     *os.File
+	writerSize int64
+	reader     *os.File
+	cursor     outboxCursor
+	depth      int
+	// oldest is the enqueue time of the next entry to replay
+	oldest time.Time
+	// running is true once the replay goroutine is started
+	running bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func openOutbox(dir string, segmentSize int64) (*outbox, error) {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating outbox directory")
+	}
+
+	o := &outbox{
+		dir:         dir,
+		segmentSize: segmentSize,
+		mutex:       &sync.Mutex{},
+		ordering:    &sync.Mutex{},
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading outbox directory")
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), outboxSegmentExt) || file.Name() == outboxDeadLetters {
+			continue
+		}
+		segment, err := strconv.ParseInt(strings.TrimSuffix(file.Name(), outboxSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		o.segments = append(o.segments, segment)
+	}
+	slices.Sort(o.segments)
+
+	cursorBytes, err := os.ReadFile(filepath.Join(dir, outboxCursorFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "reading outbox cursor")
+	}
+	if len(cursorBytes) > 0 {
+		err = json.Unmarshal(cursorBytes, &o.cursor)
+		if err != nil {
+			return nil, errors.Wrap(err, "unmarshaling outbox cursor")
+		}
+	}
+
+	// Segments before the cursor were fully replayed but not removed
+	for len(o.segments) > 0 && o.segments[0] < o.cursor.Segment {
+		os.Remove(o.segmentPath(o.segments[0]))
+		o.segments = o.segments[1:]
+	}
+	if len(o.segments) > 0 && o.segments[0] > o.cursor.Segment {
+		o.cursor = outboxCursor{Segment: o.segments[0]}
+	}
+
+	err = o.countPending()
+	if err != nil {
+		return nil, err
+	}
+	o.updateMetrics()
+
+	return o, nil
+}
+
+// countPending initializes depth and oldest from the entries after the cursor
+func (o *outbox) countPending() error {
+	for _, segment := range o.segments {
+		file, err := os.Open(o.segmentPath(segment))
+		if err != nil {
+			return errors.Wrap(err, "opening outbox segment")
+		}
+
+		if segment == o.cursor.Segment {
+			_, err = file.Seek(o.cursor.Offset, io.SeekStart)
+			if err != nil {
+				file.Close()
+				return errors.Wrap(err, "seeking outbox segment")
+			}
+		}
+
+		// Only complete lines are counted, a partial line is left by a crash while appending
+		reader := bufio.NewReader(file)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err != nil {
+				file.Close()
+				if err != io.EOF {
+					return errors.Wrap(err, "reading outbox segment")
+				}
+				break
+			}
+
+			if o.depth == 0 {
+				var entry outboxEntry
+				if json.Unmarshal(line, &entry) == nil {
+					o.oldest = entry.EnqueuedAt
+				}
+			}
+			o.depth++
+		}
+	}
+
+	return nil
+}
+
+// pending returns true if there are entries waiting to be replayed
+func (o *outbox) pending() bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.depth > 0
+}
+
+// append durably stores entry at the end of the outbox
+func (o *outbox) append(entry outboxEntry) error {
+	entry.EnqueuedAt = time.Now()
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "marshaling outbox entry")
+	}
+	entryBytes = append(entryBytes, '\n')
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.writer == nil || o.writerSize+int64(len(entryBytes)) > o.segmentSize {
+		err = o.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = o.writer.Write(entryBytes)
+	if err != nil {
+		return errors.Wrap(err, "writing outbox entry")
+	}
+	err = o.writer.Sync()
+	if err != nil {
+		return errors.Wrap(err, "syncing outbox segment")
+	}
+	o.writerSize += int64(len(entryBytes))
+
+	if o.depth == 0 {
+		o.oldest = time.Now()
+	}
+	o.depth++
+	o.updateMetrics()
+
+	return nil
+}
+
+// rotate closes the segment being written and opens a new one, it must be called with the mutex locked
+func (o *outbox) rotate() error {
+	if o.writer != nil {
+		err := o.writer.Close()
+		if err != nil {
+			return errors.Wrap(err, "closing outbox segment")
+		}
+		o.writer = nil
+	}
+
+	segment := o.cursor.Segment
+	if len(o.segments) > 0 {
+		segment = o.segments[len(o.segments)-1] + 1
+	}
+
+	writer, err := os.OpenFile(o.segmentPath(segment), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "creating outbox segment")
+	}
+	o.writer = writer
+	o.writerSize = 0
+	o.segments = append(o.segments, segment)
+
+	return nil
+}
+
+// replay sends every pending entry in order using send. It stops at the first retryable error, entries that fail with
+// any other error are moved to the dead letters file so they don't block the outbox.
+func (o *outbox) replay(send func(entry outboxEntry) error) error {
+	for {
+		entry, next, ok, err := o.next()
+		if err != nil || !ok {
+			return err
+		}
+
+		err = send(entry)
+		if err != nil {
+			// A configuration error would fail every entry, they are kept until it's fixed
+			if IsRetryable(err) || IsConfigurationError(err) {
+				return err
+			}
+
+			err = o.deadLetter(entry, err)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = o.commit(next)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// next reads the entry at the cursor, it returns the position after the entry and false if there are no entries
+func (o *outbox) next() (entry outboxEntry, next outboxCursor, ok bool, err error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for o.depth > 0 && len(o.segments) > 0 {
+		if o.reader == nil {
+			o.reader, err = os.Open(o.segmentPath(o.cursor.Segment))
+			if err != nil {
+				return outboxEntry{}, outboxCursor{}, false, errors.Wrap(err, "opening outbox segment")
+			}
+		}
+
+		line, err := readLineAt(o.reader, o.cursor.Offset)
+		if err != nil {
+			return outboxEntry{}, outboxCursor{}, false, errors.Wrap(err, "reading outbox segment")
+		}
+
+		if line == nil {
+			if len(o.segments) == 1 {
+				// The segment being written is fully replayed
+				return outboxEntry{}, outboxCursor{}, false, nil
+			}
+
+			err = o.removeHeadSegment()
+			if err != nil {
+				return outboxEntry{}, outboxCursor{}, false, err
+			}
+			continue
+		}
+
+		next = outboxCursor{Segment: o.cursor.Segment, Offset: o.cursor.Offset + int64(len(line))}
+		err = json.Unmarshal(line, &entry)
+		if err != nil {
+			// A line corrupted by a crash, skip it
+			o.cursor = next
+			o.depth--
+			continue
+		}
+
+		o.oldest = entry.EnqueuedAt
+		return entry, next, true, nil
+	}
+
+	return outboxEntry{}, outboxCursor{}, false, nil
+}
+
+// commit persists next as the cursor after its entry was replayed
+func (o *outbox) commit(next outboxCursor) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.cursor = next
+	o.depth--
+	if o.depth == 0 {
+		o.oldest = time.Time{}
+	}
+	o.updateMetrics()
+
+	cursorBytes, err := json.Marshal(o.cursor)
+	if err != nil {
+		return errors.Wrap(err, "marshaling outbox cursor")
+	}
+
+	return writeFileAtomic(filepath.Join(o.dir, outboxCursorFile), cursorBytes)
+}
+
+// removeHeadSegment deletes the fully replayed oldest segment, it must be called with the mutex locked
+func (o *outbox) removeHeadSegment() error {
+	if o.reader != nil {
+		o.reader.Close()
+		o.reader = nil
+	}
+
+	err := os.Remove(o.segmentPath(o.segments[0]))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "removing outbox segment")
+	}
+	o.segments = o.segments[1:]
+	o.cursor = outboxCursor{Segment: o.segments[0]}
+
+	return nil
+}
+
+func (o *outbox) deadLetter(entry outboxEntry, sendErr error) error {
+	deadLetterBytes, err := json.Marshal(outboxDeadLetter{FailedAt: time.Now(), Error: sendErr.Error(), outboxEntry: entry})
+	if err != nil {
+		return errors.Wrap(err, "marshaling dead letter")
+	}
+
+	file, err := os.OpenFile(filepath.Join(o.dir, outboxDeadLetters), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "opening dead letters file")
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(deadLetterBytes, '\n'))
+	if err != nil {
+		return errors.Wrap(err, "writing dead letter")
+	}
+
+	return file.Sync()
+}
+
+// start replays the outbox in a new goroutine until close is called
+func (o *outbox) start(interval time.Duration, maxBackoff time.Duration, ping func(ctx context.Context) error, send func(ctx context.Context, entry outboxEntry) error) {
+	o.running = true
+	go o.run(interval, maxBackoff, ping, send)
+}
+
+// run replays the outbox every interval while there are pending entries, once ping succeeds. The interval is doubled
+// up to maxBackoff after each failure.
+func (o *outbox) run(interval time.Duration, maxBackoff time.Duration, ping func(ctx context.Context) error, send func(ctx context.Context, entry outboxEntry) error) {
+	defer close(o.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-o.stop
+		cancel()
+	}()
+
+	backoff := interval
+	for {
+		select {
+		case <-o.stop:
+			return
+		case <-time.After(backoff):
+		}
+
+		o.mutex.Lock()
+		o.updateMetrics()
+		o.mutex.Unlock()
+
+		if !o.pending() {
+			backoff = interval
+			continue
+		}
+
+		err := ping(ctx)
+		if err == nil {
+			err = o.replay(func(entry outboxEntry) error {
+				return send(ctx, entry)
+			})
+		}
+
+		if err != nil {
+			backoff = min(backoff*2, maxBackoff)
+			continue
+		}
+		backoff = interval
+	}
+}
+
+// close stops the replay goroutine, if started, and closes the segment files
+func (o *outbox) close() error {
+	select {
+	case <-o.stop:
+		return nil
+	default:
+		close(o.stop)
+	}
+	if o.running {
+		<-o.done
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.reader != nil {
+		o.reader.Close()
+		o.reader = nil
+	}
+	if o.writer != nil {
+		err := o.writer.Close()
+		o.writer = nil
+		return err
+	}
+
+	return nil
+}
+
+// updateMetrics must be called with the mutex locked
+func (o *outbox) updateMetrics() {
+	indexmetrics.MetricOutboxDepth.WithLabelValues(o.dir).Set(float64(o.depth))
+
+	age := 0.0
+	if !o.oldest.IsZero() {
+		age = time.Since(o.oldest).Seconds()
+	}
+	indexmetrics.MetricOutboxOldestAge.WithLabelValues(o.dir).Set(age)
+}
+
+func (o *outbox) segmentPath(segment int64) string {
+	return filepath.Join(o.dir, fmt.Sprintf("%020d%s", segment, outboxSegmentExt))
+}
+
+// readLineAt reads a line ending in '\n' starting at offset, it returns nil if there is no complete line
+func readLineAt(file *os.File, offset int64) ([]byte, error) {
+	_, err := file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return line, nil
+}
+
+// writeFileAtomic replaces path with data so a crash never leaves a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	err := os.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,345 @@
+package indexer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/encypher-studio/newsware-utils/api/response"
+	"github.com/encypher-studio/newsware-utils/indexmetrics"
+	"github.com/encypher-studio/newsware-utils/nwelastic"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOutbox_replay(t *testing.T) {
+	tests := []struct {
+		name            string
+		segmentSize     int64
+		appendIds       []string
+		sendErrs        map[string]error
+		reopen          bool
+		expectedSent    []string
+		expectedPending int
+		expectedDead    int
+	}{
+		{
+			name:         "replays in order",
+			segmentSize:  1e6,
+			appendIds:    []string{"1", "2", "3"},
+			expectedSent: []string{"1", "2", "3"},
+		},
+		{
+			name:         "replays across segments",
+			segmentSize:  100,
+			appendIds:    []string{"1", "2", "3", "4", "5"},
+			expectedSent: []string{"1", "2", "3", "4", "5"},
+		},
+		{
+			name:         "replays after reopening",
+			segmentSize:  100,
+			appendIds:    []string{"1", "2", "3"},
+			reopen:       true,
+			expectedSent: []string{"1", "2", "3"},
+		},
+		{
+			name:            "stops at retryable error",
+			segmentSize:     1e6,
+			appendIds:       []string{"1", "2", "3"},
+			sendErrs:        map[string]error{"2": &Error{StatusCode: 503, Retryable: true}},
+			expectedSent:    []string{"1", "2"},
+			expectedPending: 2,
+		},
+		{
+			name:         "dead letters permanent errors",
+			segmentSize:  1e6,
+			appendIds:    []string{"1", "2", "3"},
+			sendErrs:     map[string]error{"2": &Error{StatusCode: 400}},
+			expectedSent: []string{"1", "2", "3"},
+			expectedDead: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			o, err := openOutbox(dir, tt.segmentSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, id := range tt.appendIds {
+				err = o.append(outboxEntry{News: &nwelastic.News{Id: id}})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.reopen {
+				err = o.close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				o, err = openOutbox(dir, tt.segmentSize)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if o.depth != len(tt.appendIds) {
+					t.Fatalf("depth after reopening = %d, expected %d", o.depth, len(tt.appendIds))
+				}
+			}
+			defer o.close()
+
+			var actualSent []string
+			o.replay(func(entry outboxEntry) error {
+				actualSent = append(actualSent, entry.News.Id)
+				return tt.sendErrs[entry.News.Id]
+			})
+
+			if !reflect.DeepEqual(tt.expectedSent, actualSent) {
+				t.Fatalf("sent = %v, expected %v", actualSent, tt.expectedSent)
+			}
+			if o.depth != tt.expectedPending {
+				t.Fatalf("depth = %d, expected %d", o.depth, tt.expectedPending)
+			}
+			if actual := testutil.ToFloat64(indexmetrics.MetricOutboxDepth.WithLabelValues(dir)); actual != float64(tt.expectedPending) {
+				t.Fatalf("depth metric = %v, expected %v", actual, tt.expectedPending)
+			}
+			if tt.expectedPending == 0 && len(o.segments) != 1 {
+				t.Fatalf("replayed segments were not removed, %d left", len(o.segments))
+			}
+
+			deadLetters, _ := os.ReadFile(filepath.Join(dir, outboxDeadLetters))
+			if actual := countLines(deadLetters); actual != tt.expectedDead {
+				t.Fatalf("dead letters = %d, expected %d", actual, tt.expectedDead)
+			}
+		})
+	}
+}
+
+func TestOutbox_resumesFromCursor(t *testing.T) {
+	dir := t.TempDir()
+	o, err := openOutbox(dir, 1e6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		o.append(outboxEntry{News: &nwelastic.News{Id: strconv.Itoa(i)}})
+	}
+
+	o.replay(func(entry outboxEntry) error {
+		if entry.News.Id == "1" {
+			return &Error{Retryable: true}
+		}
+		return nil
+	})
+	o.close()
+
+	o, err = openOutbox(dir, 1e6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.close()
+
+	var actualSent []string
+	o.replay(func(entry outboxEntry) error {
+		actualSent = append(actualSent, entry.News.Id)
+		return nil
+	})
+
+	if expected := []string{"1", "2"}; !reflect.DeepEqual(expected, actualSent) {
+		t.Fatalf("sent = %v, expected %v", actualSent, expected)
+	}
+}
+
+func TestIndexer_Index_outbox(t *testing.T) {
+	var available atomic.Bool
+	var mutex sync.Mutex
+	var indexed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write(marshalUnsafe(response.ErrorExplicit[*int]("maintenance", "maintenance", nil)))
+			return
+		}
+
+		if r.URL.Path == "/api/v1/index" {
+			var news nwelastic.News
+			json.NewDecoder(r.Body).Decode(&news)
+			mutex.Lock()
+			indexed = append(indexed, news.Id)
+			mutex.Unlock()
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	i := mustNew(t, Config{
+		Host:                 server.URL,
+		OutboxDir:            t.TempDir(),
+		OutboxReplayInterval: 10 * time.Millisecond,
+		OutboxMaxBackoff:     20 * time.Millisecond,
+	})
+	defer i.Close()
+
+	for _, id := range []string{"1", "2", "3"} {
+		err := i.Index(&nwelastic.News{Id: id})
+		if err != nil {
+			t.Fatalf("Index() should succeed while the outbox is enabled: %s", err)
+		}
+	}
+	if !i.outbox.pending() {
+		t.Fatal("news were not appended to the outbox")
+	}
+
+	available.Store(true)
+	deadline := time.After(2 * time.Second)
+	for i.outbox.pending() {
+		select {
+		case <-deadline:
+			t.Fatal("timed out waiting for the outbox to be replayed")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	err := i.Index(&nwelastic.News{Id: "4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if expected := []string{"1", "2", "3", "4"}; !reflect.DeepEqual(expected, indexed) {
+		t.Fatalf("indexed = %v, expected %v", indexed, expected)
+	}
+}
+
+func TestIndexer_Index_outboxConcurrent(t *testing.T) {
+	// Each request waits up to a second for the other one to be in flight
+	var inFlight atomic.Int32
+	concurrent := make(chan struct{})
+	var once sync.Once
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/index" {
+			return
+		}
+		defer inFlight.Add(-1)
+		if inFlight.Add(1) == 2 {
+			once.Do(func() { close(concurrent) })
+		}
+		select {
+		case <-concurrent:
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	i := mustNew(t, Config{Host: server.URL, OutboxDir: t.TempDir()})
+	defer i.Close()
+
+	var wg sync.WaitGroup
+	for _, id := range []string{"1", "2"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := i.Index(&nwelastic.News{Id: id}); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-concurrent:
+	default:
+		t.Fatal("news were sent one at a time while the outbox was empty")
+	}
+}
+
+func TestIndexer_writeOperations_outbox(t *testing.T) {
+	var available atomic.Bool
+	var mutex sync.Mutex
+	var sent []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write(marshalUnsafe(response.ErrorExplicit[*int]("maintenance", "maintenance", nil)))
+			return
+		}
+
+		var body struct {
+			Id string `json:"id"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/api/v1/ping" {
+			mutex.Lock()
+			sent = append(sent, r.URL.Path+" "+body.Id)
+			mutex.Unlock()
+		}
+		w.Write(marshalUnsafe(response.SuccessWithData(map[string]any{"id": body.Id})))
+	}))
+	defer server.Close()
+
+	i := mustNew(t, Config{
+		Host:                 server.URL,
+		OutboxDir:            t.TempDir(),
+		OutboxReplayInterval: 10 * time.Millisecond,
+		OutboxMaxBackoff:     20 * time.Millisecond,
+	})
+	defer i.Close()
+
+	if err := i.Index(&nwelastic.News{Id: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	available.Store(true)
+	// The outbox has pending news, the delete and the update are appended after them even if the indexer is available
+	deleteData, err := i.Delete("1")
+	if err != nil || !deleteData.Queued {
+		t.Fatalf("expected the delete to be queued, got %+v, '%v'", deleteData, err)
+	}
+	updateData, err := i.Update("2", map[string]any{"headline": "corrected"})
+	if err != nil || !updateData.Queued {
+		t.Fatalf("expected the update to be queued, got %+v, '%v'", updateData, err)
+	}
+	upsertData, err := i.Upsert(&nwelastic.News{Id: "4"})
+	if err != nil || !upsertData.Queued {
+		t.Fatalf("expected the upsert to be queued, got %+v, '%v'", upsertData, err)
+	}
+
+	deadline := time.After(2 * time.Second)
+	for i.outbox.pending() {
+		select {
+		case <-deadline:
+			t.Fatal("timed out waiting for the outbox to be replayed")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	deleteData, err = i.Delete("3")
+	if err != nil || deleteData.Queued || deleteData.Id != "3" {
+		t.Fatalf("expected the delete to be sent, got %+v, '%v'", deleteData, err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	expected := []string{"/api/v1/index 1", "/api/v1/delete 1", "/api/v1/update 2", "/api/v1/upsert 4", "/api/v1/delete 3"}
+	if !reflect.DeepEqual(expected, sent) {
+		t.Fatalf("sent = %v, expected %v", sent, expected)
+	}
+}
+
+func countLines(data []byte) int {
+	count := 0
+	for _, b := range data {
+		if b == '\n' {
+			count++
+		}
+	}
+	return count
+}
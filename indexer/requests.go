@@ -0,0 +1,12 @@
+package indexer
+
+// DeleteRequest is the body of the delete endpoint
+type DeleteRequest struct {
+	Id string `json:"id"`
+}
+
+// UpdateRequest is the body of the update endpoint, Fields are the JSON fields of nwelastic.News to set
+type UpdateRequest struct {
+	Id     string         `json:"id"`
+	Fields map[string]any `json:"fields"`
+}
@@ -1,6 +1,43 @@
 package indexer
 
+// IndexBatchData is the data returned by the batch endpoint, both on success and, for partial failures, as error data.
+// LastIndex is the index in the request of the last news processed, the news up to it were indexed except Failures.
 type IndexBatchData struct {
-	TotalIndexed int `json:"totalIndexed"`
-	LastIndex    int `json:"lastIndex"`
+	TotalIndexed int                 `json:"totalIndexed"`
+	LastIndex    int                 `json:"lastIndex"`
+	Failures     []IndexBatchFailure `json:"failures,omitempty"`
+}
+
+// IndexBatchFailure is a news of a batch that the indexer couldn't index, Index is its position in the request, or in
+// the news passed to Indexer.IndexBatch once returned in a BatchError
+type IndexBatchFailure struct {
+	Index  int    `json:"index"`
+	Id     string `json:"id"`
+	Status int    `json:"status"`
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// DeleteData is the data returned by the delete endpoint. Found is false if there was no news with the id. Queued is
+// set by Indexer.Delete when the delete was appended to the outbox, Found is then unknown.
+type DeleteData struct {
+	Id     string `json:"id"`
+	Found  bool   `json:"found"`
+	Queued bool   `json:"-"`
+}
+
+// UpdateData is the data returned by the update endpoint. Updated is false if the fields already had the values sent.
+// Queued is set by Indexer.Update when the update was appended to the outbox, Updated is then unknown.
+type UpdateData struct {
+	Id      string `json:"id"`
+	Updated bool   `json:"updated"`
+	Queued  bool   `json:"-"`
+}
+
+// UpsertData is the data returned by the upsert endpoint. Created is false if an existing news was replaced. Queued is
+// set by Indexer.Upsert when the upsert was appended to the outbox, Created is then unknown.
+type UpsertData struct {
+	Id      string `json:"id"`
+	Created bool   `json:"created"`
+	Queued  bool   `json:"-"`
 }
@@ -0,0 +1,90 @@
+package indexer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// newHttpClient returns config.HttpClient if set, otherwise a client with a dedicated transport built from config
+func newHttpClient(config Config) (*http.Client, error) {
+	if config.HttpClient != nil {
+		return config.HttpClient, nil
+	}
+
+	if config.RequestTimeout <= 0 {
+		config.RequestTimeout = defaultRequestTimeout
+	}
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = defaultDialTimeout
+	}
+	if config.MaxIdleConns <= 0 {
+		config.MaxIdleConns = defaultMaxIdleConns
+	}
+	if config.MaxIdleConnsPerHost <= 0 {
+		config.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+
+	tlsConfig, err := newTlsConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   config.DialTimeout,
+		KeepAlive: defaultDialTimeout,
+	}).DialContext
+	transport.MaxIdleConns = config.MaxIdleConns
+	transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	transport.TLSClientConfig = tlsConfig
+
+	switch {
+	case config.DisableProxy:
+		transport.Proxy = nil
+	case config.ProxyUrl != "":
+		proxyUrl, err := url.Parse(config.ProxyUrl)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing proxy url")
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   config.RequestTimeout,
+	}, nil
+}
+
+func newTlsConfig(config Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CaCertPath != "" {
+		caCert, err := os.ReadFile(config.CaCertPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading ca certificate")
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("no certificates found in ca certificate file")
+		}
+	}
+
+	if config.ClientCertPath != "" || config.ClientKeyPath != "" {
+		clientCert, err := tls.LoadX509KeyPair(config.ClientCertPath, config.ClientKeyPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+
+	return tlsConfig, nil
+}
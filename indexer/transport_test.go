@@ -0,0 +1,130 @@
+package indexer
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/encypher-studio/newsware-utils/api/response"
+	"github.com/encypher-studio/newsware-utils/nwelastic"
+)
+
+func TestIndexer_tls(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write(marshalUnsafe(response.Response[*int, *int]{}))
+	}))
+	defer server.Close()
+
+	caCertPath := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caCertPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		config      Config
+		expectedErr string
+	}{
+		{
+			name:        "unknown authority",
+			config:      Config{Host: server.URL},
+			expectedErr: "certificate signed by unknown authority",
+		},
+		{
+			name:   "custom ca",
+			config: Config{Host: server.URL, CaCertPath: caCertPath},
+		},
+		{
+			name:   "insecure",
+			config: Config{Host: server.URL, InsecureSkipVerify: true},
+		},
+		{
+			name:   "injected client",
+			config: Config{Host: server.URL, HttpClient: server.Client()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := mustNew(t, tt.config)
+			err := i.Ping()
+			if err != nil || tt.expectedErr != "" {
+				if tt.expectedErr == "" || err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Fatalf("error is not as expected, got '%s', expected '%s'", err, tt.expectedErr)
+				}
+			}
+		})
+	}
+}
+
+func TestNewHttpClient_invalidConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      Config
+		expectedErr string
+	}{
+		{
+			name:        "missing ca file",
+			config:      Config{CaCertPath: filepath.Join(t.TempDir(), "missing.pem")},
+			expectedErr: "reading ca certificate",
+		},
+		{
+			name:        "missing client certificate",
+			config:      Config{ClientCertPath: filepath.Join(t.TempDir(), "missing.pem")},
+			expectedErr: "loading client certificate",
+		},
+		{
+			name:        "invalid proxy",
+			config:      Config{ProxyUrl: "://invalid"},
+			expectedErr: "parsing proxy url",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newHttpClient(tt.config)
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Fatalf("error is not as expected, got '%s', expected '%s'", err, tt.expectedErr)
+			}
+		})
+	}
+}
+
+func TestIndexer_timeoutAndCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	t.Run("request timeout", func(t *testing.T) {
+		i := mustNew(t, Config{Host: server.URL, RequestTimeout: 50 * time.Millisecond})
+		start := time.Now()
+		err := i.Index(&nwelastic.News{})
+		if err == nil {
+			t.Fatal("expected timeout error")
+		}
+		if time.Since(start) > 500*time.Millisecond {
+			t.Fatalf("request was not interrupted by the timeout")
+		}
+	})
+
+	t.Run("context canceled", func(t *testing.T) {
+		i := mustNew(t, Config{Host: server.URL})
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := i.PingContext(ctx)
+		if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+			t.Fatalf("error is not as expected, got '%s', expected '%s'", err, context.DeadlineExceeded)
+		}
+	})
+}
@@ -10,8 +10,12 @@ import (
 )
 
 var (
-	MetricServiceRestarts  *prometheus.CounterVec
-	MetricDocumentsIndexed *prometheus.CounterVec
+	MetricServiceRestarts      *prometheus.CounterVec
+	MetricDocumentsIndexed     *prometheus.CounterVec
+	MetricOutboxDepth          *prometheus.GaugeVec
+	MetricOutboxOldestAge      *prometheus.GaugeVec
+	MetricIndexerRequests      *prometheus.CounterVec
+	MetricIndexerHostAvailable *prometheus.GaugeVec
 )
 
 func init() {
@@ -35,6 +39,38 @@ func init() {
 		[]string{},
 	)
 
+	MetricOutboxDepth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "indexer_outbox_depth",
+			Help: "Number of news waiting in the indexer outbox",
+		},
+		[]string{"outbox"},
+	)
+
+	MetricOutboxOldestAge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "indexer_outbox_oldest_age_seconds",
+			Help: "Age of the oldest news waiting in the indexer outbox",
+		},
+		[]string{"outbox"},
+	)
+
+	MetricIndexerRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "indexer_requests",
+			Help: "Number of requests sent to the indexer by host, endpoint and result",
+		},
+		[]string{"host", "endpoint", "result"},
+	)
+
+	MetricIndexerHostAvailable = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "indexer_host_available",
+			Help: "Whether the indexer host is used for requests, it's 0 while its circuit is open or its health check fails",
+		},
+		[]string{"host"},
+	)
+
 	err := prometheus.Register(MetricServiceRestarts)
 	if err != nil {
 		panic(err)
@@ -43,6 +79,22 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	err = prometheus.Register(MetricOutboxDepth)
+	if err != nil {
+		panic(err)
+	}
+	err = prometheus.Register(MetricOutboxOldestAge)
+	if err != nil {
+		panic(err)
+	}
+	err = prometheus.Register(MetricIndexerRequests)
+	if err != nil {
+		panic(err)
+	}
+	err = prometheus.Register(MetricIndexerHostAvailable)
+	if err != nil {
+		panic(err)
+	}
 }
 
 func Handle(log *ecslogger.Logger) http.Handler {
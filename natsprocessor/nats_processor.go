@@ -8,8 +8,11 @@ import (
 
 	"github.com/encypher-studio/newsware-utils/nats_nw"
 	"github.com/encypher-studio/newsware-utils/nwelastic"
+	"github.com/encypher-studio/newsware-utils/nwtrace"
 	"github.com/encypher-studio/newsware-utils/retrier"
 	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type IJetstream interface {
@@ -25,14 +28,16 @@ type NatsProcessor struct {
 	queueName    string
 	retrier      retrier.Retrier
 	subscription *nats.Subscription
-	processFunc  func(*nwelastic.News) error
+	processFunc  func(context.Context, *nwelastic.News) error
 }
 
 type Opts struct {
-	QueueName     string
-	Retrier       retrier.Retrier
-	ProcessFunc   func(*nwelastic.News) error
-	DeliverPolicy *nats.DeliverPolicy // Defaults to DeliverNewPolicy
+	QueueName   string
+	Retrier     retrier.Retrier
+	ProcessFunc func(*nwelastic.News) error
+	// ProcessContextFunc is used instead of ProcessFunc if set, ctx carries the span of the message being processed
+	ProcessContextFunc func(ctx context.Context, news *nwelastic.News) error
+	DeliverPolicy      *nats.DeliverPolicy // Defaults to DeliverNewPolicy
 }
 
 type OptsWithConf struct {
@@ -61,9 +66,14 @@ func NewWithJs(opts OptsWithJs) (NatsProcessor, error) {
 		js:          opts.JetStream,
 		bucket:      opts.Bucket,
 		retrier:     opts.Retrier,
-		processFunc: opts.ProcessFunc,
+		processFunc: opts.ProcessContextFunc,
 		queueName:   opts.QueueName,
 	}
+	if np.processFunc == nil && opts.ProcessFunc != nil {
+		np.processFunc = func(_ context.Context, news *nwelastic.News) error {
+			return opts.ProcessFunc(news)
+		}
+	}
 
 	var deliverPolicy nats.DeliverPolicy
 	if opts.DeliverPolicy == nil {
@@ -141,25 +151,7 @@ func (l *NatsProcessor) Listen(ctx context.Context, onMsgProcessed ...func(*nats
 					}
 				}()
 
-				if len(msg.Data) == 0 {
-					msg.Ack()
-					return
-				}
-
-				news := nwelastic.News{}
-				err := json.Unmarshal(msg.Data, &news)
-				if err != nil {
-					msg.Nak()
-					return
-				}
-
-				err = l.processFunc(&news)
-				if err != nil {
-					msg.Nak()
-					return
-				}
-
-				msg.Ack()
+				l.process(msg)
 			}()
 		case <-ctx.Done():
 			return nil
@@ -167,6 +159,37 @@ func (l *NatsProcessor) Listen(ctx context.Context, onMsgProcessed ...func(*nats
 	}
 }
 
+// process decodes msg and passes it to processFunc within a span that continues the trace found in the message headers
+func (l *NatsProcessor) process(msg *nats.Msg) {
+	ctx := nwtrace.ExtractNats(context.Background(), msg.Header)
+	ctx, span := nwtrace.Start(ctx, "natsprocessor process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("messaging.destination.name", msg.Subject)),
+	)
+	var err error
+	defer func() { nwtrace.EndWithError(span, err) }()
+
+	if len(msg.Data) == 0 {
+		msg.Ack()
+		return
+	}
+
+	news := nwelastic.News{}
+	err = json.Unmarshal(msg.Data, &news)
+	if err != nil {
+		msg.Nak()
+		return
+	}
+
+	err = l.processFunc(ctx, &news)
+	if err != nil {
+		msg.Nak()
+		return
+	}
+
+	msg.Ack()
+}
+
 func (l *NatsProcessor) Unsubscribe() error {
 	if l.subscription == nil {
 		return nil
@@ -16,8 +16,11 @@ import (
 	"time"
 
 	"github.com/encypher-studio/newsware-utils/nwelastic"
+	"github.com/encypher-studio/newsware-utils/nwtrace"
+	"github.com/encypher-studio/newsware-utils/nwtrace/nwtracetest"
 	"github.com/encypher-studio/newsware-utils/retrier"
 	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/yaml.v3"
 )
 
@@ -239,7 +242,7 @@ func TestNatsProcessor_Listen(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Setup
 			var actualProcessNews []nwelastic.News
-			processFunc := func(n *nwelastic.News) error {
+			processFunc := func(_ context.Context, n *nwelastic.News) error {
 				actualProcessNews = append(actualProcessNews, *n)
 				return nil
 			}
@@ -372,6 +375,37 @@ func TestNatsProcessor_Listen(t *testing.T) {
 	}
 }
 
+func TestNatsProcessor_process_tracing(t *testing.T) {
+	exporter := nwtracetest.Install(t)
+
+	publishCtx, publishSpan := nwtrace.Start(context.Background(), "publish")
+	publishSpan.End()
+	msg := nats.NewMsg("$KV.bucket.1")
+	msg.Data = marshalUnsafe(mockNews(1))
+	nwtrace.InjectNats(publishCtx, msg.Header)
+
+	var processSpanContext trace.SpanContext
+	l := NatsProcessor{
+		processFunc: func(ctx context.Context, news *nwelastic.News) error {
+			processSpanContext = trace.SpanContextFromContext(ctx)
+			return nil
+		},
+	}
+	l.process(msg)
+
+	if processSpanContext.TraceID() != publishSpan.SpanContext().TraceID() {
+		t.Fatalf("processFunc context does not continue the publisher trace")
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[1].Name != "natsprocessor process" {
+		t.Fatalf("unexpected spans %v", nwtracetest.SpanNames(exporter))
+	}
+	if spans[1].Parent.SpanID() != publishSpan.SpanContext().SpanID() {
+		t.Fatalf("process span is not a child of the publisher span")
+	}
+}
+
 func marshalUnsafe(v interface{}) []byte {
 	b, _ := json.Marshal(v)
 	return b
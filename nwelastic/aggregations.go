@@ -0,0 +1,231 @@
+package nwelastic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/runtimefieldtype"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultTopSize    = 10
+	defaultSourceSize = 100
+
+	// latencyField is a runtime field of the ingestion latency of news, in milliseconds
+	latencyField = "ingestionLatency"
+)
+
+var (
+	ErrInvalidInterval = errors.New("histogram interval must be positive")
+
+	defaultLatencyPercents = []float64{50, 90, 99}
+)
+
+// TimeBucket is the number of news published from Start during the interval of a histogram
+type TimeBucket struct {
+	Start time.Time
+	Count int64
+}
+
+// TermCount is the number of news having a value of a field
+type TermCount struct {
+	Value string
+	Count int64
+}
+
+// SourceLatency is the ingestion latency of the news of a source, the time between their publication and reception
+type SourceLatency struct {
+	Source string
+	// Count is the number of news of the source with both times set, the other news aren't aggregated
+	Count int64
+	// Percentiles maps each requested percent to its latency
+	Percentiles map[float64]time.Duration
+}
+
+// aggregationsResponse is the part of a search response holding the aggregations, decoded without typed keys
+type aggregationsResponse struct {
+	Aggregations map[string]struct {
+		Buckets []aggregationBucket `json:"buckets"`
+	} `json:"aggregations"`
+}
+
+type aggregationBucket struct {
+	Key      any   `json:"key"`
+	DocCount int64 `json:"doc_count"`
+	Latency  struct {
+		Values []struct {
+			Key   float64  `json:"key"`
+			Value *float64 `json:"value"`
+		} `json:"values"`
+	} `json:"latency"`
+}
+
+// PublicationHistogram counts the news matching filter per interval of publication time, in UTC. Buckets without news
+// between the first and last news are included, news without a publication time are ignored. filter.Sort, Order, Size
+// and Cursor are ignored.
+func (b NewsRepository) PublicationHistogram(ctx context.Context, filter NewsFilter, interval time.Duration) ([]TimeBucket, error) {
+	if interval <= 0 {
+		return nil, errors.Wrap(ErrInvalidInterval, interval.String())
+	}
+
+	fixedInterval := strconv.FormatInt(interval.Milliseconds(), 10) + "ms"
+	// News without a publication time are stored with the zero time, they would make buckets from year 1
+	buckets, err := b.aggregate(ctx, filter, []string{"publicationTime"}, nil, types.Aggregations{
+		DateHistogram: &types.DateHistogramAggregation{Field: ptr("publicationTime"), FixedInterval: fixedInterval},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	histogram := make([]TimeBucket, 0, len(buckets))
+	for _, bucket := range buckets {
+		key, ok := bucket.Key.(float64)
+		if !ok {
+			return nil, errors.Errorf("unexpected histogram key %v", bucket.Key)
+		}
+		histogram = append(histogram, TimeBucket{Start: time.UnixMilli(int64(key)).UTC(), Count: bucket.DocCount})
+	}
+	return histogram, nil
+}
+
+// TopTickers returns the size tickers, 10 if zero, with the most news matching filter
+func (b NewsRepository) TopTickers(ctx context.Context, filter NewsFilter, size int) ([]TermCount, error) {
+	return b.topTerms(ctx, filter, "tickers", size)
+}
+
+// TopCategories returns the size category codes, 10 if zero, with the most news matching filter
+func (b NewsRepository) TopCategories(ctx context.Context, filter NewsFilter, size int) ([]TermCount, error) {
+	return b.topTerms(ctx, filter, "categoryCodes", size)
+}
+
+func (b NewsRepository) topTerms(ctx context.Context, filter NewsFilter, field string, size int) ([]TermCount, error) {
+	if size <= 0 {
+		size = defaultTopSize
+	}
+
+	buckets, err := b.aggregate(ctx, filter, nil, nil, types.Aggregations{
+		Terms: &types.TermsAggregation{Field: &field, Size: &size},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	terms := make([]TermCount, 0, len(buckets))
+	for _, bucket := range buckets {
+		terms = append(terms, TermCount{Value: termKey(bucket.Key), Count: bucket.DocCount})
+	}
+	return terms, nil
+}
+
+// IngestionLatency returns the percentiles of the ingestion latency of each source, receivedTime minus
+// publicationTime, of the news matching filter. percents default to 50, 90 and 99. News without both times are
+// ignored, sources without such news aren't returned. Up to 100 sources are returned, the ones with the most news
+// first.
+func (b NewsRepository) IngestionLatency(ctx context.Context, filter NewsFilter, percents ...float64) ([]SourceLatency, error) {
+	if len(percents) == 0 {
+		percents = defaultLatencyPercents
+	}
+
+	script := `if (doc['receivedTime'].size() > 0 && doc['publicationTime'].size() > 0) {
+		emit(doc['receivedTime'].value.toInstant().toEpochMilli() - doc['publicationTime'].value.toInstant().toEpochMilli());
+	}`
+	runtimeMappings := types.RuntimeFields{
+		latencyField: {Type: runtimefieldtype.Long, Script: &types.Script{Source: &script}},
+	}
+
+	typedPercents := make([]types.Float64, len(percents))
+	for i, percent := range percents {
+		typedPercents[i] = types.Float64(percent)
+	}
+	size := defaultSourceSize
+	keyed := false
+	buckets, err := b.aggregate(ctx, filter, []string{"publicationTime", "receivedTime"}, runtimeMappings, types.Aggregations{
+		Terms: &types.TermsAggregation{Field: ptr("source"), Size: &size},
+		Aggregations: map[string]types.Aggregations{
+			"latency": {Percentiles: &types.PercentilesAggregation{Field: ptr(latencyField), Percents: typedPercents, Keyed: &keyed}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	latencies := make([]SourceLatency, 0, len(buckets))
+	for _, bucket := range buckets {
+		latency := SourceLatency{Source: termKey(bucket.Key), Count: bucket.DocCount, Percentiles: map[float64]time.Duration{}}
+		for _, percentile := range bucket.Latency.Values {
+			// The value is null if no news of the source has both times
+			if percentile.Value != nil {
+				latency.Percentiles[percentile.Key] = time.Duration(*percentile.Value * float64(time.Millisecond))
+			}
+		}
+		latencies = append(latencies, latency)
+	}
+	return latencies, nil
+}
+
+// aggregate runs aggregation over the news matching filter and having timeFields set, and returns its buckets. The
+// response is decoded without the typed client, whose aggregation types require typed keys.
+func (b NewsRepository) aggregate(ctx context.Context, filter NewsFilter, timeFields []string, runtimeMappings types.RuntimeFields, aggregation types.Aggregations) ([]aggregationBucket, error) {
+	filter.Cursor = ""
+	req, err := newsSearchRequest(filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range timeFields {
+		req.Query.Bool.Filter = append(req.Query.Bool.Filter, timeSet(field))
+	}
+	size := 0
+	req = &search.Request{
+		Query:           req.Query,
+		Size:            &size,
+		RuntimeMappings: runtimeMappings,
+		Aggregations:    map[string]types.Aggregations{"aggregation": aggregation},
+	}
+
+	res, err := b.elastic.TypedClient.Search().Index(b.Index).Request(req).FilterPath("aggregations").Perform(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "aggregating news")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		body, _ := io.ReadAll(res.Body)
+		return nil, errors.Errorf("aggregating news: status %d: %s", res.StatusCode, body)
+	}
+
+	var aggregations aggregationsResponse
+	err = json.NewDecoder(res.Body).Decode(&aggregations)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshaling aggregations")
+	}
+
+	return aggregations.Aggregations["aggregation"].Buckets, nil
+}
+
+// timeSet matches the news having a time in field, news without one are stored with the zero time
+func timeSet(field string) types.Query {
+	zero := time.Time{}.Format(time.RFC3339Nano)
+	return types.Query{Range: map[string]types.RangeQuery{field: types.DateRangeQuery{Gt: &zero}}}
+}
+
+// termKey formats the key of a terms bucket, numbers are decoded as float64
+func termKey(key any) string {
+	switch key := key.(type) {
+	case string:
+		return key
+	case float64:
+		return strconv.FormatFloat(key, 'f', -1, 64)
+	}
+	return fmt.Sprint(key)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
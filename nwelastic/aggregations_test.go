@@ -0,0 +1,127 @@
+package nwelastic
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewsRepository_aggregations(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	filter := NewsFilter{Sources: []string{"SEC"}, Cursor: "ignored"}
+
+	tests := []struct {
+		name                string
+		response            string
+		aggregate           func(repository NewsRepository) (any, error)
+		expectedTimeFields  []string
+		expectedAggregation map[string]any
+		expected            any
+	}{
+		{
+			name: "publication histogram",
+			response: `{"aggregations": {"aggregation": {"buckets": [
+				{"key_as_string": "2024-01-01T00:00:00.000Z", "key": 1704067200000, "doc_count": 2},
+				{"key_as_string": "2024-01-01T01:00:00.000Z", "key": 1704070800000, "doc_count": 0}
+			]}}}`,
+			aggregate: func(repository NewsRepository) (any, error) {
+				return repository.PublicationHistogram(context.Background(), filter, time.Hour)
+			},
+			expectedTimeFields:  []string{"publicationTime"},
+			expectedAggregation: map[string]any{"date_histogram": map[string]any{"field": "publicationTime", "fixed_interval": "3600000ms"}},
+			expected:            []TimeBucket{{Start: start, Count: 2}, {Start: start.Add(time.Hour), Count: 0}},
+		},
+		{
+			name:     "top tickers",
+			response: `{"aggregations": {"aggregation": {"buckets": [{"key": "AAPL", "doc_count": 3}, {"key": "MSFT", "doc_count": 1}]}}}`,
+			aggregate: func(repository NewsRepository) (any, error) {
+				return repository.TopTickers(context.Background(), filter, 0)
+			},
+			expectedAggregation: map[string]any{"terms": map[string]any{"field": "tickers", "size": float64(10)}},
+			expected:            []TermCount{{Value: "AAPL", Count: 3}, {Value: "MSFT", Count: 1}},
+		},
+		{
+			name:     "top categories",
+			response: `{"aggregations": {"aggregation": {"buckets": [{"key": "MNA", "doc_count": 2}]}}}`,
+			aggregate: func(repository NewsRepository) (any, error) {
+				return repository.TopCategories(context.Background(), filter, 5)
+			},
+			expectedAggregation: map[string]any{"terms": map[string]any{"field": "categoryCodes", "size": float64(5)}},
+			expected:            []TermCount{{Value: "MNA", Count: 2}},
+		},
+		{
+			name: "ingestion latency",
+			response: `{"aggregations": {"aggregation": {"buckets": [
+				{"key": "SEC", "doc_count": 4, "latency": {"values": [{"key": 50.0, "value": 1500.0}, {"key": 99.0, "value": 60000.0}]}},
+				{"key": "PR", "doc_count": 1, "latency": {"values": [{"key": 50.0, "value": null}, {"key": 99.0, "value": null}]}}
+			]}}}`,
+			aggregate: func(repository NewsRepository) (any, error) {
+				return repository.IngestionLatency(context.Background(), filter, 50, 99)
+			},
+			expectedTimeFields: []string{"publicationTime", "receivedTime"},
+			expectedAggregation: map[string]any{
+				"terms": map[string]any{"field": "source", "size": float64(100)},
+				"aggregations": map[string]any{
+					"latency": map[string]any{"percentiles": map[string]any{"field": latencyField, "percents": []any{float64(50), float64(99)}, "keyed": false}},
+				},
+			},
+			expected: []SourceLatency{
+				{Source: "SEC", Count: 4, Percentiles: map[float64]time.Duration{50: 1500 * time.Millisecond, 99: time.Minute}},
+				{Source: "PR", Count: 1, Percentiles: map[float64]time.Duration{}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var actualRequest map[string]any
+			elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&actualRequest)
+				w.Write([]byte(tt.response))
+			})
+			repository, err := NewNewsRepository(elastic)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			actual, err := tt.aggregate(repository)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			assert.Equal(t, tt.expected, actual)
+			assert.Equal(t, float64(0), actualRequest["size"])
+			assert.NotNil(t, actualRequest["query"])
+			assert.Nil(t, actualRequest["search_after"])
+			assert.Equal(t, tt.expectedAggregation, actualRequest["aggregations"].(map[string]any)["aggregation"])
+
+			// News with a zero time in the aggregated fields are filtered out
+			var actualTimeFields []string
+			for _, query := range actualRequest["query"].(map[string]any)["bool"].(map[string]any)["filter"].([]any) {
+				dateRanges, _ := query.(map[string]any)["range"].(map[string]any)
+				for field, dateRange := range dateRanges {
+					if dateRange.(map[string]any)["gt"] == "0001-01-01T00:00:00Z" {
+						actualTimeFields = append(actualTimeFields, field)
+					}
+				}
+			}
+			assert.Equal(t, tt.expectedTimeFields, actualTimeFields)
+		})
+	}
+}
+
+func TestNewsRepository_PublicationHistogram_invalidInterval(t *testing.T) {
+	elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s", r.URL.Path)
+	})
+	repository, err := NewNewsRepository(elastic)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = repository.PublicationHistogram(context.Background(), NewsFilter{}, 0)
+	assert.ErrorIs(t, err, ErrInvalidInterval)
+}
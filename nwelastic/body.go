@@ -0,0 +1,257 @@
+package nwelastic
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	// BodyReject rejects news bigger than MaxBulkBytes with ErrNewsTooLarge
+	BodyReject = ""
+	// BodyTruncate truncates bodies longer than MaxBodyBytes, the news records it with BodyStorageTruncated
+	BodyTruncate = "truncate"
+	// BodySplit stores bodies longer than MaxBodyBytes in chunks in the body chunks index, "<index>_body_chunks". The
+	// news keeps the first chunk as its body and records it with BodyStorageChunks, ReassembleBody restores the body.
+	// Bodies of news without id can't be linked to chunks, they are truncated like with BodyTruncate.
+	BodySplit = "split"
+
+	// BodyStorageTruncated is the News.BodyStorage of a news whose body was truncated, the rest is lost
+	BodyStorageTruncated = "truncated"
+	// BodyStorageChunks is the News.BodyStorage of a news whose full body is in the body chunks index
+	BodyStorageChunks = "chunks"
+
+	defaultMaxBodyBytes = 1e6
+)
+
+var (
+	ErrInvalidBodyStrategy = errors.New("invalid body strategy")
+	ErrBodyChunksMissing   = errors.New("body chunks are missing")
+)
+
+// bodyChunk is a document of the body chunks index
+type bodyChunk struct {
+	NewsId string `json:"newsId"`
+	Seq    int    `json:"seq"`
+	Text   string `json:"text"`
+	// Source, Tickers and PublicationTime are copied from the news so DeleteByQuery deletes the chunks with it
+	Source          string    `json:"source"`
+	Tickers         []string  `json:"tickers,omitempty"`
+	PublicationTime time.Time `json:"publicationTime"`
+}
+
+func validateBodyStrategy(strategy string) error {
+	switch strategy {
+	case BodyReject, BodyTruncate, BodySplit:
+		return nil
+	}
+	return errors.Wrap(ErrInvalidBodyStrategy, strategy)
+}
+
+func bodyChunksIndex(index string) string {
+	return index + "_body_chunks"
+}
+
+func bodyChunkId(newsId string, seq int) string {
+	return newsId + "_" + strconv.Itoa(seq)
+}
+
+// maxBodyBytes defaults to half of MaxBulkBytes, up to 1MB
+func (b NewsRepository) maxBodyBytes() int {
+	if b.opts.MaxBodyBytes > 0 {
+		return b.opts.MaxBodyBytes
+	}
+	return min(defaultMaxBodyBytes, b.maxBulkBytes()/2)
+}
+
+// applyBodyStrategy shortens the body of news if it's longer than MaxBodyBytes and returns the chunks to store with
+// BodySplit. A news without id can't be linked to its chunks, its body is truncated.
+func (b NewsRepository) applyBodyStrategy(news *News) []bodyChunk {
+	maxBytes := b.maxBodyBytes()
+	if b.opts.BodyStrategy == BodyReject || len(news.Body) <= maxBytes {
+		return nil
+	}
+
+	var chunks []bodyChunk
+	if b.opts.BodyStrategy == BodySplit && news.Id != "" {
+		for seq, text := range splitText(news.Body, maxBytes) {
+			chunks = append(chunks, bodyChunk{
+				NewsId:          news.Id,
+				Seq:             seq,
+				Text:            text,
+				Source:          news.Source,
+				Tickers:         news.Tickers,
+				PublicationTime: news.PublicationTime,
+			})
+		}
+		news.BodyStorage = BodyStorageChunks
+		news.BodyChunks = len(chunks)
+	} else {
+		news.BodyStorage = BodyStorageTruncated
+	}
+
+	news.BodyTruncated = true
+	news.BodyLength = len(news.Body)
+	news.Body = truncateText(news.Body, maxBytes)
+	return chunks
+}
+
+// truncateText returns the longest prefix of text of at most maxBytes that doesn't cut a character
+func truncateText(text string, maxBytes int) string {
+	if len(text) <= maxBytes {
+		return text
+	}
+
+	end := maxBytes
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return text[:end]
+}
+
+// splitText splits text in parts of at most maxBytes without cutting characters
+func splitText(text string, maxBytes int) []string {
+	var parts []string
+	for len(text) > 0 {
+		part := truncateText(text, maxBytes)
+		if part == "" {
+			// maxBytes is smaller than the character, it is kept whole
+			_, size := utf8.DecodeRuneInString(text)
+			part = text[:size]
+		}
+		parts = append(parts, part)
+		text = text[len(part):]
+	}
+	return parts
+}
+
+// newBodyChunkItem serializes a chunk of the body of the news at index of a batch
+func (b NewsRepository) newBodyChunkItem(index int, chunk bodyChunk) (bulkItem, error) {
+	action, err := json.Marshal(map[string]bulkActionMeta{"index": {Index: bodyChunksIndex(b.Index), Id: bodyChunkId(chunk.NewsId, chunk.Seq)}})
+	if err != nil {
+		return bulkItem{}, errors.Wrap(err, "marshaling body chunk bulk action")
+	}
+
+	body, err := json.Marshal(chunk)
+	if err != nil {
+		return bulkItem{}, errors.Wrapf(err, "marshaling body chunk of news item %d", index)
+	}
+
+	return bulkItem{index: index, id: chunk.NewsId, action: action, body: body}, nil
+}
+
+// insertBulkChunks stores the body chunks of the news indexed by a bulk request, in requests of at most MaxBulkBytes.
+// Chunks that fail are reported as failures of their news.
+func (b NewsRepository) insertBulkChunks(indexed []bulkItem, stats *BulkStats) ([]BulkFailure, error) {
+	var (
+		failures []BulkFailure
+		request  []bulkItem
+		size     int
+	)
+	send := func() error {
+		if len(request) == 0 {
+			return nil
+		}
+		_, requestFailures, err := b.bulkWithRetries(request, stats)
+		if err != nil {
+			return errors.Wrap(err, "inserting body chunks")
+		}
+		failures = append(failures, requestFailures...)
+		request, size = nil, 0
+		return nil
+	}
+
+	for _, item := range indexed {
+		for _, chunk := range item.chunks {
+			if size+chunk.size() > b.maxBulkBytes() || len(request) >= b.opts.MaxBulkDocs {
+				if err := send(); err != nil {
+					return nil, err
+				}
+			}
+			request = append(request, chunk)
+			size += chunk.size()
+		}
+	}
+	if err := send(); err != nil {
+		return nil, err
+	}
+
+	for i := range failures {
+		failures[i].Reason = "body chunk: " + failures[i].Reason
+	}
+	return failures, nil
+}
+
+// insertBodyChunks stores the chunks of a news inserted by Insert
+func (b NewsRepository) insertBodyChunks(ctx context.Context, chunks []bodyChunk) error {
+	for _, chunk := range chunks {
+		_, err := b.elastic.TypedClient.Index(bodyChunksIndex(b.Index)).Id(bodyChunkId(chunk.NewsId, chunk.Seq)).Request(chunk).Do(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "inserting body chunk %d of news %s", chunk.Seq, chunk.NewsId)
+		}
+	}
+	return nil
+}
+
+// ReassembleBody restores the full body of news stored with BodyStorageChunks from the body chunks index and unsets
+// BodyTruncated, other news are left as is. ErrBodyChunksMissing is returned if a chunk isn't found.
+func (b NewsRepository) ReassembleBody(ctx context.Context, news *News) error {
+	if news.BodyStorage != BodyStorageChunks || !news.BodyTruncated {
+		return nil
+	}
+
+	ids := make([]string, news.BodyChunks)
+	for seq := range ids {
+		ids[seq] = bodyChunkId(news.Id, seq)
+	}
+
+	res, err := b.elastic.TypedClient.Mget().Index(bodyChunksIndex(b.Index)).Ids(ids...).Do(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "getting body chunks of news %s", news.Id)
+	}
+
+	var body strings.Builder
+	body.Grow(news.BodyLength)
+	for seq, doc := range res.Docs {
+		var chunk bodyChunk
+		found, err := mgetSource(doc, &chunk)
+		if err != nil {
+			return errors.Wrapf(err, "body chunk %d of news %s", seq, news.Id)
+		}
+		if !found {
+			return errors.Wrapf(ErrBodyChunksMissing, "body chunk %d of news %s", seq, news.Id)
+		}
+		body.WriteString(chunk.Text)
+	}
+
+	news.Body = body.String()
+	news.BodyTruncated = false
+	return nil
+}
+
+// mgetSource unmarshals the source of a document returned by a multi get into v, found is false if it doesn't exist
+func mgetSource(doc types.MgetResponseItem, v any) (found bool, err error) {
+	switch doc := doc.(type) {
+	case *types.GetResult:
+		if !doc.Found {
+			return false, nil
+		}
+		return true, errors.Wrap(json.Unmarshal(doc.Source_, v), "unmarshaling document")
+	case *types.MultiGetError:
+		return false, errors.Errorf("getting document %s: %s", doc.Id_, stringValue(doc.Error.Reason))
+	}
+	return false, errors.Errorf("unexpected multi get item %T", doc)
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
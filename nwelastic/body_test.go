@@ -0,0 +1,201 @@
+package nwelastic
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		name          string
+		text          string
+		maxBytes      int
+		expectedParts []string
+	}{
+		{"short", "abc", 5, []string{"abc"}},
+		{"exact parts", "abcdef", 3, []string{"abc", "def"}},
+		{"characters aren't cut", "aéb€", 3, []string{"aé", "b", "€"}},
+		{"character longer than max", "€", 2, []string{"€"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedParts, splitText(tt.text, tt.maxBytes))
+		})
+	}
+}
+
+func TestNewsRepository_applyBodyStrategy(t *testing.T) {
+	tests := []struct {
+		name           string
+		strategy       string
+		news           News
+		expectedNews   News
+		expectedChunks []bodyChunk
+	}{
+		{
+			name:         "reject keeps the body",
+			strategy:     BodyReject,
+			news:         News{Id: "1", Body: "0123456789"},
+			expectedNews: News{Id: "1", Body: "0123456789"},
+		},
+		{
+			name:         "short body",
+			strategy:     BodySplit,
+			news:         News{Id: "1", Body: "0123"},
+			expectedNews: News{Id: "1", Body: "0123"},
+		},
+		{
+			name:         "truncate",
+			strategy:     BodyTruncate,
+			news:         News{Id: "1", Body: "0123456789"},
+			expectedNews: News{Id: "1", Body: "0123", BodyTruncated: true, BodyLength: 10, BodyStorage: BodyStorageTruncated},
+		},
+		{
+			name:         "split",
+			strategy:     BodySplit,
+			news:         News{Id: "1", Body: "0123456789", Source: "SOURCE"},
+			expectedNews: News{Id: "1", Body: "0123", Source: "SOURCE", BodyTruncated: true, BodyLength: 10, BodyStorage: BodyStorageChunks, BodyChunks: 3},
+			expectedChunks: []bodyChunk{
+				{NewsId: "1", Seq: 0, Text: "0123", Source: "SOURCE"},
+				{NewsId: "1", Seq: 1, Text: "4567", Source: "SOURCE"},
+				{NewsId: "1", Seq: 2, Text: "89", Source: "SOURCE"},
+			},
+		},
+		{
+			name:         "split without id truncates",
+			strategy:     BodySplit,
+			news:         News{Body: "0123456789"},
+			expectedNews: News{Body: "0123", BodyTruncated: true, BodyLength: 10, BodyStorage: BodyStorageTruncated},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := NewsRepository{opts: NewsRepositoryOpts{BodyStrategy: tt.strategy, MaxBodyBytes: 4}}
+			chunks := repository.applyBodyStrategy(&tt.news)
+			assert.Equal(t, tt.expectedNews, tt.news)
+			assert.Equal(t, tt.expectedChunks, chunks)
+		})
+	}
+}
+
+func TestNewsRepository_InsertBatch_split(t *testing.T) {
+	index := TestElasticConfig.NewsIndex
+	chunksIndex := bodyChunksIndex(index)
+	tests := []struct {
+		name string
+		// conflictId is rejected with a 409 status
+		conflictId      string
+		expectedIds     []string
+		expectedIndexed int
+		expectedErr     bool
+	}{
+		{
+			name:            "chunks after the news",
+			expectedIds:     []string{index + "/1", index + "/2", chunksIndex + "/1_0", chunksIndex + "/1_1"},
+			expectedIndexed: 2,
+		},
+		{
+			name:            "rejected news",
+			conflictId:      "1",
+			expectedIds:     []string{index + "/1", index + "/2"},
+			expectedIndexed: 1,
+			expectedErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var actualIds []string
+			elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+				var items []map[string]any
+				scanner := bufio.NewScanner(r.Body)
+				for scanner.Scan() {
+					var action map[string]struct {
+						Index string `json:"_index"`
+						Id    string `json:"_id"`
+					}
+					json.Unmarshal(scanner.Bytes(), &action)
+					scanner.Scan()
+
+					actualIds = append(actualIds, action["index"].Index+"/"+action["index"].Id)
+					if action["index"].Index == index && action["index"].Id == tt.conflictId {
+						items = append(items, map[string]any{"index": map[string]any{
+							"_id":    action["index"].Id,
+							"status": http.StatusConflict,
+							"error":  map[string]any{"type": "version_conflict_engine_exception", "reason": "newer version"},
+						}})
+						continue
+					}
+					items = append(items, map[string]any{"index": map[string]any{"_id": action["index"].Id, "status": http.StatusCreated}})
+				}
+
+				json.NewEncoder(w).Encode(map[string]any{"took": 1, "errors": tt.conflictId != "", "items": items})
+			})
+
+			repository, err := NewNewsRepository(elastic, NewsRepositoryOpts{BodyStrategy: BodySplit, MaxBodyBytes: 4})
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			var actualIndexed int
+			err = repository.InsertBatch([]*News{{Id: "1", Body: "012345"}, {Id: "2", Body: "0"}}, func(totalIndexed int, lastIndex int) {
+				actualIndexed += totalIndexed
+			})
+			assert.Equal(t, tt.expectedErr, err != nil, "error: %v", err)
+
+			assert.Equal(t, tt.expectedIds, actualIds)
+			// Body chunks aren't counted as news
+			assert.Equal(t, tt.expectedIndexed, actualIndexed)
+		})
+	}
+}
+
+func TestNewsRepository_ReassembleBody(t *testing.T) {
+	tests := []struct {
+		name         string
+		docs         string
+		expectedBody string
+		expectedErr  error
+	}{
+		{
+			name:         "chunks found",
+			docs:         `[{"_id": "1_0", "found": true, "_source": {"text": "0123"}}, {"_id": "1_1", "found": true, "_source": {"text": "45"}}]`,
+			expectedBody: "012345",
+		},
+		{
+			name:        "chunk missing",
+			docs:        `[{"_id": "1_0", "found": true, "_source": {"text": "0123"}}, {"_id": "1_1", "found": false}]`,
+			expectedErr: ErrBodyChunksMissing,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var actualPath string
+			elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+				actualPath = r.URL.Path
+				w.Write([]byte(`{"docs": ` + tt.docs + `}`))
+			})
+			repository, err := NewNewsRepository(elastic)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			news := News{Id: "1", Body: "0123", BodyTruncated: true, BodyLength: 6, BodyStorage: BodyStorageChunks, BodyChunks: 2}
+			err = repository.ReassembleBody(context.Background(), &news)
+			assert.True(t, strings.HasPrefix(actualPath, "/"+bodyChunksIndex(repository.Index)+"/_mget"), actualPath)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expectedBody, news.Body)
+				assert.False(t, news.BodyTruncated)
+			}
+		})
+	}
+}
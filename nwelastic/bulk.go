@@ -0,0 +1,257 @@
+package nwelastic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/versiontype"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNewsTooLarge = errors.New("news exceeds the maximum bulk size")
+)
+
+// BulkFailure is a news that Elasticsearch rejected in a bulk request
+type BulkFailure struct {
+	Id string
+	// Index is the position of the news in the batch passed to InsertBatch
+	Index int
+	// Status is the HTTP status of the item, zero if the whole request failed
+	Status int
+	Type   string
+	Reason string
+	// Err is ErrAlreadyExists or ErrVersionConflict if the news was rejected with a 409 status, nil otherwise
+	Err error
+}
+
+// AlreadyExists returns true if the news wasn't inserted because CreateOnly is set and a news with its id exists
+func (f BulkFailure) AlreadyExists() bool {
+	return errors.Is(f.Err, ErrAlreadyExists)
+}
+
+// VersionConflict returns true if the news wasn't inserted because a newer version of it exists
+func (f BulkFailure) VersionConflict() bool {
+	return errors.Is(f.Err, ErrVersionConflict)
+}
+
+// BulkError is returned by InsertBatch when some news couldn't be indexed, the rest of the batch was indexed
+type BulkError struct {
+	Failures []BulkFailure
+}
+
+func (e *BulkError) Error() string {
+	reasons := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", failure.Id, failure.Reason))
+	}
+	return fmt.Sprintf("%d news failed to index: %s", len(e.Failures), strings.Join(reasons, "; "))
+}
+
+// BulkStats summarizes an InsertBatch call
+type BulkStats struct {
+	// Added is the number of news in the batch
+	Added uint64
+	// Indexed is the number of news indexed, including after retries
+	Indexed uint64
+	// Failed is the number of news that couldn't be indexed
+	Failed uint64
+	// Retried is the number of times a news was sent again after a transient failure
+	Retried uint64
+	// Requests is the number of bulk requests sent
+	Requests     uint64
+	FlushedBytes uint64
+	Duration     time.Duration
+}
+
+func (s *BulkStats) add(other BulkStats) {
+	s.Retried += other.Retried
+	s.Requests += other.Requests
+	s.FlushedBytes += other.FlushedBytes
+}
+
+// bulkItem is a news serialized as a bulk index action
+type bulkItem struct {
+	// index is the position of the news in the batch
+	index int
+	id    string
+	// version is the external version of the news, zero without a version policy
+	version int64
+	// chunks are the body chunks of the news, they are written once the news is indexed
+	chunks []bulkItem
+	action []byte
+	body   []byte
+}
+
+// newBulkItem serializes the news at index of a batch, partitions are the partitions of the news that already exist
+func (b NewsRepository) newBulkItem(index int, news *News, partitions map[string]string) (bulkItem, error) {
+	meta := bulkActionMeta{Index: b.writeIndex(news, partitions), Id: news.Id}
+	version := b.newsVersion(news)
+	if b.opts.VersionPolicy != VersionPolicyNone {
+		meta.Version = &version
+		meta.VersionType = versiontype.Externalgte.String()
+	}
+	action, err := json.Marshal(map[string]bulkActionMeta{b.bulkOperation(): meta})
+	if err != nil {
+		return bulkItem{}, errors.Wrap(err, "marshaling bulk action")
+	}
+
+	body, err := json.Marshal(news)
+	if err != nil {
+		return bulkItem{}, errors.Wrapf(err, "marshaling news item %d", index)
+	}
+
+	return bulkItem{index: index, id: news.Id, version: version, action: action, body: body}, nil
+}
+
+type bulkActionMeta struct {
+	Index       string `json:"_index"`
+	Id          string `json:"_id,omitempty"`
+	Version     *int64 `json:"version,omitempty"`
+	VersionType string `json:"version_type,omitempty"`
+}
+
+// bulkOperation is the bulk action used to insert news, "create" doesn't overwrite existing news
+func (b NewsRepository) bulkOperation() string {
+	if b.opts.CreateOnly {
+		return "create"
+	}
+	return "index"
+}
+
+// size is the number of bytes of the item in a bulk request body, including the newlines
+func (i bulkItem) size() int {
+	return len(i.action) + len(i.body) + 2
+}
+
+func newsTooLargeFailure(item bulkItem, maxBytes int) BulkFailure {
+	return BulkFailure{
+		Id:     item.id,
+		Index:  item.index,
+		Type:   "news_too_large",
+		Reason: fmt.Sprintf("%s: %d bytes, the maximum is %d", ErrNewsTooLarge, item.size(), maxBytes),
+	}
+}
+
+// bulkResult is the result of sending bulk items once
+type bulkResult struct {
+	indexed   []bulkItem
+	failures  []BulkFailure
+	transient []bulkItem
+	// transientFailures are the failures of the transient items, reported if they are not retried
+	transientFailures []BulkFailure
+}
+
+// bulkWithRetries indexes items, retrying the items rejected for transient reasons up to opts.BulkRetries times
+func (b NewsRepository) bulkWithRetries(items []bulkItem, stats *BulkStats) (indexed []bulkItem, failures []BulkFailure, err error) {
+	backoff := b.opts.BulkRetryBackoff
+	for attempt := 0; len(items) > 0; attempt++ {
+		result, err := b.bulk(items, stats)
+		if err != nil {
+			return indexed, failures, err
+		}
+
+		indexed = append(indexed, result.indexed...)
+		failures = append(failures, result.failures...)
+		if len(result.transient) == 0 {
+			break
+		}
+		if attempt >= b.opts.BulkRetries {
+			return indexed, append(failures, result.transientFailures...), nil
+		}
+
+		stats.Retried += uint64(len(result.transient))
+		time.Sleep(backoff)
+		backoff *= 2
+		items = result.transient
+	}
+
+	return indexed, failures, nil
+}
+
+// bulk sends items in a single bulk request. Items rejected with a transient status, or all of them if the request
+// failed for a transient reason, are returned to be retried.
+func (b NewsRepository) bulk(items []bulkItem, stats *BulkStats) (bulkResult, error) {
+	var body bytes.Buffer
+	for _, item := range items {
+		body.Write(item.action)
+		body.WriteByte('\n')
+		body.Write(item.body)
+		body.WriteByte('\n')
+	}
+	stats.Requests++
+	stats.FlushedBytes += uint64(body.Len())
+
+	var result bulkResult
+	failAll := func(status int, reason string) {
+		for _, item := range items {
+			failure := BulkFailure{Id: item.id, Index: item.index, Status: status, Reason: reason}
+			if status == 0 || isTransientBulkStatus(status) {
+				result.transient = append(result.transient, item)
+				result.transientFailures = append(result.transientFailures, failure)
+			} else {
+				result.failures = append(result.failures, failure)
+			}
+		}
+	}
+
+	res, err := b.elastic.bulk(&body)
+	if err != nil {
+		failAll(0, err.Error())
+		return result, nil
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		failAll(res.StatusCode, res.String())
+		return result, nil
+	}
+
+	var bulkRes esutil.BulkIndexerResponse
+	if err := json.NewDecoder(res.Body).Decode(&bulkRes); err != nil {
+		return bulkResult{}, errors.Wrap(err, "decoding bulk response")
+	}
+	if len(bulkRes.Items) != len(items) {
+		return bulkResult{}, errors.Errorf("bulk response has %d items, %d were sent", len(bulkRes.Items), len(items))
+	}
+
+	// Items are returned in the order they were sent, keyed by their operation
+	for i, item := range items {
+		var info esutil.BulkIndexerResponseItem
+		for _, info = range bulkRes.Items[i] {
+			// Each item has a single operation
+			break
+		}
+		if info.Error.Type == "" && info.Status <= 201 {
+			result.indexed = append(result.indexed, item)
+			continue
+		}
+
+		failure := BulkFailure{Id: item.id, Index: item.index, Status: info.Status, Type: info.Error.Type, Reason: info.Error.Reason}
+		if info.Status == http.StatusConflict {
+			failure.Err = b.conflictErr()
+		}
+		if isTransientBulkStatus(info.Status) {
+			result.transient = append(result.transient, item)
+			result.transientFailures = append(result.transientFailures, failure)
+			continue
+		}
+		result.failures = append(result.failures, failure)
+	}
+
+	return result, nil
+}
+
+// isTransientBulkStatus returns true if an item rejected with status might be indexed if sent again
+func isTransientBulkStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
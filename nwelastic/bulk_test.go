@@ -0,0 +1,360 @@
+package nwelastic
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewsRepository_InsertBatch_failures(t *testing.T) {
+	type callbackArgs struct {
+		totalIndexed int
+		lastIndex    int
+	}
+	tests := []struct {
+		name        string
+		bulkRetries int
+		// statuses are the item statuses returned on each attempt to index a news by id, 201 once exhausted
+		statuses          map[string][]int
+		expectedCallbacks []callbackArgs
+		expectedFailures  []BulkFailure
+		expectedStats     BulkStats
+	}{
+		{
+			name:              "all indexed",
+			expectedCallbacks: []callbackArgs{{3, 2}},
+			expectedStats:     BulkStats{Added: 3, Indexed: 3, Requests: 1},
+		},
+		{
+			name:              "transient failure is retried",
+			statuses:          map[string][]int{"2": {http.StatusTooManyRequests, http.StatusServiceUnavailable}},
+			expectedCallbacks: []callbackArgs{{3, 2}},
+			expectedStats:     BulkStats{Added: 3, Indexed: 3, Retried: 2, Requests: 3},
+		},
+		{
+			name:              "permanent failure",
+			statuses:          map[string][]int{"1": {http.StatusBadRequest}, "3": {http.StatusBadRequest}},
+			expectedCallbacks: []callbackArgs{{1, 2}},
+			expectedFailures: []BulkFailure{
+				{Id: "1", Index: 0, Status: http.StatusBadRequest, Type: "mapper_parsing_exception", Reason: "failed to parse"},
+				{Id: "3", Index: 2, Status: http.StatusBadRequest, Type: "mapper_parsing_exception", Reason: "failed to parse"},
+			},
+			expectedStats: BulkStats{Added: 3, Indexed: 1, Failed: 2, Requests: 1},
+		},
+		{
+			name:              "retries exhausted",
+			bulkRetries:       1,
+			statuses:          map[string][]int{"2": {http.StatusTooManyRequests, http.StatusTooManyRequests}},
+			expectedCallbacks: []callbackArgs{{2, 2}},
+			expectedFailures: []BulkFailure{
+				{Id: "2", Index: 1, Status: http.StatusTooManyRequests, Type: "es_rejected_execution_exception", Reason: "rejected"},
+			},
+			expectedStats: BulkStats{Added: 3, Indexed: 2, Failed: 1, Retried: 1, Requests: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			attempts := map[string]int{}
+			elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+				mutex.Lock()
+				defer mutex.Unlock()
+
+				var items []map[string]any
+				scanner := bufio.NewScanner(r.Body)
+				for scanner.Scan() {
+					var action map[string]struct {
+						Id string `json:"_id"`
+					}
+					json.Unmarshal(scanner.Bytes(), &action)
+					scanner.Scan()
+
+					id := action["index"].Id
+					status := http.StatusCreated
+					if attempt := attempts[id]; attempt < len(tt.statuses[id]) {
+						status = tt.statuses[id][attempt]
+					}
+					attempts[id]++
+
+					item := map[string]any{"_id": id, "status": status}
+					switch status {
+					case http.StatusBadRequest:
+						item["error"] = map[string]any{"type": "mapper_parsing_exception", "reason": "failed to parse"}
+					case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+						item["error"] = map[string]any{"type": "es_rejected_execution_exception", "reason": "rejected"}
+					}
+					items = append(items, map[string]any{"index": item})
+				}
+
+				json.NewEncoder(w).Encode(map[string]any{"took": 1, "errors": true, "items": items})
+			})
+
+			var actualStats BulkStats
+			repository, err := NewNewsRepository(elastic, NewsRepositoryOpts{
+				BulkRetries:      tt.bulkRetries,
+				BulkRetryBackoff: time.Millisecond,
+				OnBulkStats:      func(stats BulkStats) { actualStats = stats },
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			var actualCallbacks []callbackArgs
+			err = repository.InsertBatch([]*News{{Id: "1"}, {Id: "2"}, {Id: "3"}}, func(totalIndexed int, lastIndex int) {
+				actualCallbacks = append(actualCallbacks, callbackArgs{totalIndexed, lastIndex})
+			})
+
+			if tt.expectedFailures == nil {
+				assert.NoError(t, err)
+			} else {
+				var bulkErr *BulkError
+				if assert.ErrorAs(t, err, &bulkErr) {
+					assert.Equal(t, tt.expectedFailures, bulkErr.Failures)
+				}
+			}
+			assert.Equal(t, tt.expectedCallbacks, actualCallbacks)
+
+			actualStats.FlushedBytes = 0
+			actualStats.Duration = 0
+			assert.Equal(t, tt.expectedStats, actualStats)
+		})
+	}
+}
+
+func TestNewsRepository_InsertBatch_subBatches(t *testing.T) {
+	type callbackArgs struct {
+		totalIndexed int
+		lastIndex    int
+	}
+	// newsSize is roughly the serialized size of a news with only an id, the creation time length varies
+	newsSize := func() int {
+		item, _ := NewsRepository{Index: TestElasticConfig.NewsIndex}.newBulkItem(0, &News{Id: "1", CreationTime: time.Now()}, nil)
+		return item.size()
+	}()
+	maxTwoNews := newsSize*2 + newsSize/4
+
+	tests := []struct {
+		name              string
+		opts              NewsRepositoryOpts
+		news              []*News
+		expectedRequests  [][]string
+		expectedCallbacks []callbackArgs
+		expectedFailures  []string
+	}{
+		{
+			name:              "split by bytes",
+			opts:              NewsRepositoryOpts{MaxBulkBytes: maxTwoNews},
+			news:              []*News{{Id: "1"}, {Id: "2"}, {Id: "3"}},
+			expectedRequests:  [][]string{{"1", "2"}, {"3"}},
+			expectedCallbacks: []callbackArgs{{2, 1}, {1, 2}},
+		},
+		{
+			name:              "split by docs",
+			opts:              NewsRepositoryOpts{MaxBulkDocs: 1},
+			news:              []*News{{Id: "1"}, {Id: "2"}, {Id: "3"}},
+			expectedRequests:  [][]string{{"1"}, {"2"}, {"3"}},
+			expectedCallbacks: []callbackArgs{{1, 0}, {1, 1}, {1, 2}},
+		},
+		{
+			name:              "concurrent flushes call back in order",
+			opts:              NewsRepositoryOpts{MaxBulkDocs: 1, FlushConcurrency: 3},
+			news:              []*News{{Id: "1"}, {Id: "2"}, {Id: "3"}, {Id: "4"}},
+			expectedRequests:  [][]string{{"1"}, {"2"}, {"3"}, {"4"}},
+			expectedCallbacks: []callbackArgs{{1, 0}, {1, 1}, {1, 2}, {1, 3}},
+		},
+		{
+			name:              "news too large",
+			opts:              NewsRepositoryOpts{MaxBulkBytes: maxTwoNews},
+			news:              []*News{{Id: "1"}, {Id: "2", Body: generateBody(maxTwoNews)}, {Id: "3"}},
+			expectedRequests:  [][]string{{"1", "3"}},
+			expectedCallbacks: []callbackArgs{{2, 2}},
+			expectedFailures:  []string{"2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			var actualRequests [][]string
+			elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+				var ids []string
+				var items []map[string]any
+				scanner := bufio.NewScanner(r.Body)
+				scanner.Buffer(nil, 1e6)
+				for scanner.Scan() {
+					var action map[string]struct {
+						Id string `json:"_id"`
+					}
+					json.Unmarshal(scanner.Bytes(), &action)
+					scanner.Scan()
+
+					ids = append(ids, action["index"].Id)
+					items = append(items, map[string]any{"index": map[string]any{"_id": action["index"].Id, "status": http.StatusCreated}})
+				}
+
+				// Later sub-batches respond first when sent concurrently
+				if tt.opts.FlushConcurrency > 1 {
+					id, _ := strconv.Atoi(ids[0])
+					time.Sleep(time.Duration(10-id) * time.Millisecond)
+				}
+
+				mutex.Lock()
+				actualRequests = append(actualRequests, ids)
+				mutex.Unlock()
+				json.NewEncoder(w).Encode(map[string]any{"took": 1, "errors": false, "items": items})
+			})
+
+			repository, err := NewNewsRepository(elastic, tt.opts)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			var actualCallbacks []callbackArgs
+			err = repository.InsertBatch(tt.news, func(totalIndexed int, lastIndex int) {
+				actualCallbacks = append(actualCallbacks, callbackArgs{totalIndexed, lastIndex})
+			})
+
+			if tt.expectedFailures == nil {
+				assert.NoError(t, err)
+			} else {
+				var bulkErr *BulkError
+				if assert.ErrorAs(t, err, &bulkErr) {
+					actualFailures := make([]string, len(bulkErr.Failures))
+					for i, failure := range bulkErr.Failures {
+						assert.Equal(t, "news_too_large", failure.Type)
+						actualFailures[i] = failure.Id
+					}
+					assert.Equal(t, tt.expectedFailures, actualFailures)
+				}
+			}
+			assert.ElementsMatch(t, tt.expectedRequests, actualRequests)
+			assert.Equal(t, tt.expectedCallbacks, actualCallbacks)
+		})
+	}
+}
+
+func TestNewsRepository_InsertBatch_createOnly(t *testing.T) {
+	existingId := hashId("SOURCE", "provider-1")
+	var actualOperations []string
+	elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+		var items []map[string]any
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]struct {
+				Id string `json:"_id"`
+			}
+			json.Unmarshal(scanner.Bytes(), &action)
+			scanner.Scan()
+
+			for operation, meta := range action {
+				actualOperations = append(actualOperations, operation)
+				item := map[string]any{"_id": meta.Id, "status": http.StatusCreated}
+				if meta.Id == existingId {
+					item["status"] = http.StatusConflict
+					item["error"] = map[string]any{"type": "version_conflict_engine_exception", "reason": "document already exists"}
+				}
+				items = append(items, map[string]any{operation: item})
+			}
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{"took": 1, "errors": true, "items": items})
+	})
+
+	repository, err := NewNewsRepository(elastic, NewsRepositoryOpts{IdPolicy: IdPolicyProvider, CreateOnly: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = repository.InsertBatch([]*News{
+		{ProviderId: "provider-1", Source: "SOURCE"},
+		{ProviderId: "provider-2", Source: "SOURCE"},
+	}, func(int, int) {})
+
+	var bulkErr *BulkError
+	if assert.ErrorAs(t, err, &bulkErr) && assert.Len(t, bulkErr.Failures, 1) {
+		assert.Equal(t, existingId, bulkErr.Failures[0].Id)
+		assert.True(t, bulkErr.Failures[0].AlreadyExists())
+	}
+	assert.Equal(t, []string{"create", "create"}, actualOperations)
+}
+
+func TestNewsRepository_InsertBatch_versioned(t *testing.T) {
+	type request struct {
+		Index       string `json:"_index"`
+		Id          string `json:"_id"`
+		Version     int64  `json:"version"`
+		VersionType string `json:"version_type"`
+	}
+	var actualRequests [][]request
+	elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+		var requests []request
+		var items []map[string]any
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]request
+			json.Unmarshal(scanner.Bytes(), &action)
+			scanner.Scan()
+
+			meta := action["index"]
+			requests = append(requests, meta)
+			item := map[string]any{"_id": meta.Id, "status": http.StatusCreated}
+			// The stored version of news 2 is newer
+			if meta.Id == "2" {
+				item["status"] = http.StatusConflict
+				item["error"] = map[string]any{"type": "version_conflict_engine_exception", "reason": "current version [5] is higher"}
+			}
+			items = append(items, map[string]any{"index": item})
+		}
+		actualRequests = append(actualRequests, requests)
+
+		json.NewEncoder(w).Encode(map[string]any{"took": 1, "errors": true, "items": items})
+	})
+
+	repository, err := NewNewsRepository(elastic, NewsRepositoryOpts{IdPolicy: IdPolicyProvider, VersionPolicy: VersionPolicyRevision, KeepHistory: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = repository.InsertBatch([]*News{{Id: "1", Revision: 2}, {Id: "2", Revision: 4}}, func(int, int) {})
+
+	var bulkErr *BulkError
+	if assert.ErrorAs(t, err, &bulkErr) && assert.Len(t, bulkErr.Failures, 1) {
+		assert.Equal(t, "2", bulkErr.Failures[0].Id)
+		assert.True(t, bulkErr.Failures[0].VersionConflict())
+		assert.ErrorIs(t, bulkErr.Failures[0].Err, ErrVersionConflict)
+	}
+
+	index := repository.Index
+	assert.Equal(t, [][]request{
+		{
+			{Index: index, Id: "1", Version: 2, VersionType: "external_gte"},
+			{Index: index, Id: "2", Version: 4, VersionType: "external_gte"},
+		},
+		// Only the written version is kept in the history
+		{{Index: historyIndex(index), Id: "1_2"}},
+	}, actualRequests)
+}
+
+// newFakeElastic returns an Elastic connected to a server that answers pings and passes other requests to handler
+func newFakeElastic(t *testing.T, handler http.HandlerFunc) Elastic {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	config := TestElasticConfig
+	config.Addresses = []string{server.URL}
+	return NewElastic(config)
+}
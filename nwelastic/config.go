@@ -1,9 +1,41 @@
 package nwelastic
 
+import "time"
+
 type ElasticConfig struct {
-	Addresses   []string
-	Username    string
-	Password    string
+	Addresses []string
+	// CloudId connects to an Elastic Cloud deployment instead of Addresses
+	CloudId  string `yaml:"cloudId"`
+	Username string
+	Password string
+	// ApiKey is the base64 encoded API key, it is used instead of Username and Password
+	ApiKey string `yaml:"apiKey"`
+	// ServiceToken is sent as a bearer token, it is used instead of Username and Password
+	ServiceToken string `yaml:"serviceToken"`
+
+	// CACertPath is the path of the PEM encoded CA certificate verifying the cluster, the system roots are used if empty
+	CACertPath string `yaml:"caCertPath"`
+	// CertificateFingerprint is the hex encoded SHA256 fingerprint of a certificate of the cluster, it is trusted even
+	// if it isn't signed by a trusted CA
+	CertificateFingerprint string `yaml:"certificateFingerprint"`
+	// ClientCertPath and ClientKeyPath are the paths of the PEM encoded certificate and key used to authenticate with
+	// TLS
+	ClientCertPath string `yaml:"clientCertPath"`
+	ClientKeyPath  string `yaml:"clientKeyPath"`
+	// InsecureSkipVerify disables the verification of the certificate of the cluster, it must only be used in
+	// development
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
+
+	// RequestTimeout is the maximum duration of a request, including reading the response, each retry gets its own
+	// timeout. Zero means no timeout.
+	RequestTimeout time.Duration `yaml:"requestTimeout"`
+	// MaxRetries is the number of times a request is retried on a network error or a 429, 502, 503 or 504 status.
+	// Defaults to 3, a negative value disables retries. Bulk requests aren't retried by the client, see
+	// NewsRepositoryOpts.BulkRetries.
+	MaxRetries int `yaml:"maxRetries"`
+	// RetryBackoff is the wait before the first retry, it doubles on each retry. Defaults to 100ms.
+	RetryBackoff time.Duration `yaml:"retryBackoff"`
+
 	NewsIndex   string `yaml:"newsIndex"`
 	LogRequests bool   `yaml:"logRequests"`
 }
@@ -0,0 +1,220 @@
+package nwelastic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/conflicts"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/result"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultDeletePollInterval = 5 * time.Second
+)
+
+var (
+	ErrEmptyDeleteFilter = errors.New("delete filter must have at least one field set")
+	ErrDeleteTaskFailed  = errors.New("delete by query task failed")
+)
+
+// DeleteFilter selects the news deleted by DeleteByQuery, empty fields don't filter but at least one must be set. A news
+// matches if it has any of the values of each non-empty field.
+type DeleteFilter struct {
+	Sources []string `json:"sources,omitempty"`
+	Tickers []string `json:"tickers,omitempty"`
+	// From and To limit the publication time, From is inclusive and To exclusive
+	From time.Time `json:"from,omitzero"`
+	To   time.Time `json:"to,omitzero"`
+	// IncludeHistory also deletes the matching versions kept by NewsRepositoryOpts.KeepHistory
+	IncludeHistory bool `json:"includeHistory,omitempty"`
+}
+
+// DeleteProgress is the progress of a DeleteByQuery task
+type DeleteProgress struct {
+	TaskId string
+	// Total is the number of documents matching the filter, including body chunks
+	Total            int64
+	Deleted          int64
+	VersionConflicts int64
+	Completed        bool
+}
+
+// deleteTaskStatus is the status of a delete by query task, and its response once completed
+type deleteTaskStatus struct {
+	Total            int64             `json:"total"`
+	Deleted          int64             `json:"deleted"`
+	VersionConflicts int64             `json:"version_conflicts"`
+	Failures         []json.RawMessage `json:"failures"`
+}
+
+// Delete deletes the news with id and its body chunks, ErrNewsNotFound is returned if it doesn't exist. With
+// partitioning, the news is deleted from every partition.
+func (b NewsRepository) Delete(ctx context.Context, id string) error {
+	if b.opts.Partitioning == PartitionNone {
+		res, err := b.elastic.TypedClient.Delete(b.Index, id).Do(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "deleting news %s", id)
+		}
+		if res.Result != result.Deleted {
+			return errors.Wrap(ErrNewsNotFound, id)
+		}
+	} else {
+		deleted, err := b.deleteByQueryNow(ctx, b.Index, idsQuery([]string{id}))
+		if err != nil {
+			return errors.Wrapf(err, "deleting news %s", id)
+		}
+		if deleted == 0 {
+			return errors.Wrap(ErrNewsNotFound, id)
+		}
+	}
+
+	_, err := b.deleteByQueryNow(ctx, bodyChunksIndex(b.Index), &types.Query{Term: map[string]types.TermQuery{"newsId": {Value: id}}})
+	if err != nil {
+		return errors.Wrapf(err, "deleting body chunks of news %s", id)
+	}
+
+	return nil
+}
+
+// deleteByQueryNow deletes the documents of indices matching query and waits for completion. The indices are refreshed
+// first, so recently written documents are deleted too.
+func (b NewsRepository) deleteByQueryNow(ctx context.Context, indices string, query *types.Query) (int64, error) {
+	_, err := b.elastic.TypedClient.Indices.Refresh().Index(indices).IgnoreUnavailable(true).Do(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "refreshing")
+	}
+
+	res, err := b.elastic.TypedClient.DeleteByQuery(indices).
+		Query(query).
+		Conflicts(conflicts.Proceed).
+		IgnoreUnavailable(true).
+		Do(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if res.Deleted == nil {
+		return 0, nil
+	}
+
+	return *res.Deleted, nil
+}
+
+// DeleteByQuery starts deleting the news matching filter, and their body chunks, in a background task of Elasticsearch
+// and returns its id. WaitDelete polls its progress.
+func (b NewsRepository) DeleteByQuery(ctx context.Context, filter DeleteFilter) (string, error) {
+	query, err := deleteQuery(filter)
+	if err != nil {
+		return "", err
+	}
+
+	indices := []string{b.Index, bodyChunksIndex(b.Index)}
+	if filter.IncludeHistory {
+		indices = append(indices, historyIndex(b.Index))
+	}
+
+	res, err := b.elastic.TypedClient.DeleteByQuery(strings.Join(indices, ",")).
+		Query(query).
+		Conflicts(conflicts.Proceed).
+		IgnoreUnavailable(true).
+		WaitForCompletion(false).
+		Do(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "starting delete by query")
+	}
+
+	return fmt.Sprintf("%v", res.Task), nil
+}
+
+// DeleteStatus returns the progress of the DeleteByQuery task taskId. ErrDeleteTaskFailed is returned if the task
+// completed with failures.
+func (b NewsRepository) DeleteStatus(ctx context.Context, taskId string) (DeleteProgress, error) {
+	res, err := b.elastic.TypedClient.Tasks.Get(taskId).Do(ctx)
+	if err != nil {
+		return DeleteProgress{}, errors.Wrapf(err, "getting delete task %s", taskId)
+	}
+
+	// The status is updated while the task runs, the response is set once it completed
+	statusJson := res.Task.Status
+	if res.Completed && res.Response != nil {
+		statusJson = res.Response
+	}
+	var status deleteTaskStatus
+	if len(statusJson) > 0 {
+		err = json.Unmarshal(statusJson, &status)
+		if err != nil {
+			return DeleteProgress{}, errors.Wrapf(err, "unmarshaling status of delete task %s", taskId)
+		}
+	}
+
+	progress := DeleteProgress{
+		TaskId:           taskId,
+		Total:            status.Total,
+		Deleted:          status.Deleted,
+		VersionConflicts: status.VersionConflicts,
+		Completed:        res.Completed,
+	}
+	if res.Error != nil {
+		return progress, errors.Wrapf(ErrDeleteTaskFailed, "task %s: %s", taskId, stringValue(res.Error.Reason))
+	}
+	if len(status.Failures) > 0 {
+		return progress, errors.Wrapf(ErrDeleteTaskFailed, "task %s: %s", taskId, status.Failures[0])
+	}
+
+	return progress, nil
+}
+
+// WaitDelete polls the progress of the DeleteByQuery task taskId each interval, 5s if zero, until it completes.
+// onProgress, if set, is called with each progress.
+func (b NewsRepository) WaitDelete(ctx context.Context, taskId string, interval time.Duration, onProgress func(DeleteProgress)) (DeleteProgress, error) {
+	if interval <= 0 {
+		interval = defaultDeletePollInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		progress, err := b.DeleteStatus(ctx, taskId)
+		if err != nil {
+			return progress, err
+		}
+		if onProgress != nil {
+			onProgress(progress)
+		}
+		if progress.Completed {
+			return progress, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return progress, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func deleteQuery(filter DeleteFilter) (*types.Query, error) {
+	boolQuery := &types.BoolQuery{}
+	addTerms := func(field string, values []types.FieldValue) {
+		if len(values) > 0 {
+			boolQuery.Filter = append(boolQuery.Filter, types.Query{
+				Terms: &types.TermsQuery{TermsQuery: map[string]types.TermsQueryField{field: values}},
+			})
+		}
+	}
+	addTerms("source", fieldValues(filter.Sources))
+	addTerms("tickers", fieldValues(filter.Tickers))
+	if !filter.From.IsZero() || !filter.To.IsZero() {
+		boolQuery.Filter = append(boolQuery.Filter, publicationTimeRange(filter.From, filter.To))
+	}
+
+	if len(boolQuery.Filter) == 0 {
+		return nil, ErrEmptyDeleteFilter
+	}
+
+	return &types.Query{Bool: boolQuery}, nil
+}
@@ -0,0 +1,185 @@
+package nwelastic
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteQuery(t *testing.T) {
+	to := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		filter       DeleteFilter
+		expectedJson string
+		expectedErr  error
+	}{
+		{
+			name:   "every filter",
+			filter: DeleteFilter{Sources: []string{"SEC"}, Tickers: []string{"AAPL"}, To: to},
+			expectedJson: `{"bool": {"filter": [
+				{"terms": {"source": ["SEC"]}},
+				{"terms": {"tickers": ["AAPL"]}},
+				{"range": {"publicationTime": {"lt": "2024-01-01T00:00:00Z"}}}
+			]}}`,
+		},
+		{
+			name:        "empty filter",
+			filter:      DeleteFilter{IncludeHistory: true},
+			expectedErr: ErrEmptyDeleteFilter,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := deleteQuery(tt.filter)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			actualJson, err := json.Marshal(query)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.JSONEq(t, tt.expectedJson, string(actualJson))
+		})
+	}
+}
+
+func TestNewsRepository_WaitDelete(t *testing.T) {
+	tests := []struct {
+		name             string
+		taskResponses    []string
+		expectedProgress []DeleteProgress
+		expectedErr      error
+	}{
+		{
+			name: "completed",
+			taskResponses: []string{
+				`{"completed": false, "task": {"status": {"total": 10, "deleted": 4}}}`,
+				`{"completed": true, "task": {"status": {"total": 10, "deleted": 9}}, "response": {"total": 10, "deleted": 10, "failures": []}}`,
+			},
+			expectedProgress: []DeleteProgress{
+				{TaskId: "node:1", Total: 10, Deleted: 4},
+				{TaskId: "node:1", Total: 10, Deleted: 10, Completed: true},
+			},
+		},
+		{
+			name: "failures",
+			taskResponses: []string{
+				`{"completed": true, "task": {}, "response": {"total": 10, "deleted": 3, "failures": [{"cause": "shard failed"}]}}`,
+			},
+			expectedErr: ErrDeleteTaskFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			polls := 0
+			elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/_tasks/node:1", r.URL.Path)
+				w.Write([]byte(tt.taskResponses[polls]))
+				polls++
+			})
+			repository, err := NewNewsRepository(elastic)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			var actualProgress []DeleteProgress
+			progress, err := repository.WaitDelete(context.Background(), "node:1", time.Millisecond, func(progress DeleteProgress) {
+				actualProgress = append(actualProgress, progress)
+			})
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expectedProgress, actualProgress)
+				assert.Equal(t, tt.expectedProgress[len(tt.expectedProgress)-1], progress)
+			}
+		})
+	}
+}
+
+func TestNewsRepository_Delete_notFound(t *testing.T) {
+	elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"_index": "nwelastic_tests", "_id": "1", "result": "not_found"}`))
+	})
+	repository, err := NewNewsRepository(elastic)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.ErrorIs(t, repository.Delete(context.Background(), "1"), ErrNewsNotFound)
+}
+
+func TestRetentionRunner(t *testing.T) {
+	var mutex sync.Mutex
+	var actualQueries []string
+	elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if strings.HasSuffix(r.URL.Path, "/_delete_by_query") {
+			assert.Equal(t, "false", r.URL.Query().Get("wait_for_completion"))
+			body, _ := io.ReadAll(r.Body)
+			actualQueries = append(actualQueries, string(body))
+			w.Write([]byte(`{"task": "node:1"}`))
+			return
+		}
+		w.Write([]byte(`{"completed": true, "task": {}, "response": {"total": 2, "deleted": 2}}`))
+	})
+	repository, err := NewNewsRepository(elastic)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = NewRetentionRunner(repository, RetentionOpts{Rules: []RetentionRule{{Source: "SEC"}}})
+	assert.ErrorIs(t, err, ErrInvalidRetentionRule)
+
+	var actualResults []RetentionResult
+	runner, err := NewRetentionRunner(repository, RetentionOpts{
+		Rules:        []RetentionRule{{Source: "SEC", MaxAge: 24 * time.Hour}},
+		PollInterval: time.Millisecond,
+		OnResult: func(result RetentionResult) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			actualResults = append(actualResults, result)
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	results := runner.Run(context.Background(), now)
+	assert.Equal(t, []RetentionResult{{Source: "SEC", Before: now.Add(-24 * time.Hour), Deleted: 2}}, results)
+	assert.Equal(t, results, actualResults)
+	if assert.Len(t, actualQueries, 1) {
+		assert.JSONEq(t, `{"query": {"bool": {"filter": [
+			{"terms": {"source": ["SEC"]}},
+			{"range": {"publicationTime": {"lt": "2024-01-01T00:00:00Z"}}}
+		]}}}`, actualQueries[0])
+	}
+
+	// Start runs the rules immediately
+	runner.Start()
+	assert.Eventually(t, func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(actualResults) == 2
+	}, time.Second, time.Millisecond)
+	runner.Close()
+}
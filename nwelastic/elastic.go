@@ -1,19 +1,30 @@
 package nwelastic
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"io"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/elastic/elastic-transport-go/v8/elastictransport"
 	"github.com/elastic/go-elasticsearch/v8"
-	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/core/get"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
 	"github.com/pkg/errors"
 )
 
+const (
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 100 * time.Millisecond
+)
+
 var (
 	ErrFailedToPingElastic = errors.New("failed to ping elastic cluster")
 )
@@ -32,12 +43,20 @@ func NewElastic(config ElasticConfig) Elastic {
 	return elastic
 }
 
+// StartClient starts the client sending bulk requests. Its transport doesn't retry, NewsRepository retries the news
+// of failed bulk requests itself, NewsRepositoryOpts.BulkRetries times.
 func (e *Elastic) StartClient() (err error) {
 	if e.client != nil {
 		return nil
 	}
 
-	e.client, err = elasticsearch.NewClient(e.elasticClientConfig())
+	config, err := e.elasticClientConfig()
+	if err != nil {
+		return err
+	}
+	config.DisableRetry = true
+
+	e.client, err = elasticsearch.NewClient(config)
 	if err != nil {
 		return errors.Wrap(err, "creating elastic client")
 	}
@@ -59,7 +78,12 @@ func (e *Elastic) StartTypedClient() (err error) {
 		return nil
 	}
 
-	e.TypedClient, err = elasticsearch.NewTypedClient(e.elasticClientConfig())
+	config, err := e.elasticClientConfig()
+	if err != nil {
+		return err
+	}
+
+	e.TypedClient, err = elasticsearch.NewTypedClient(config)
 	if err != nil {
 		return errors.Wrap(err, "creating elastic client")
 	}
@@ -76,19 +100,18 @@ func (e *Elastic) StartTypedClient() (err error) {
 	return nil
 }
 
-func (e *Elastic) bulkIndexer(index string) (esutil.BulkIndexer, error) {
+// bulk sends a bulk request with body, each action must name its index
+func (e *Elastic) bulk(body io.Reader) (*esapi.Response, error) {
 	if e.client == nil {
-		return nil, errors.New("call StartClient() before calling bulkIndexer()")
+		return nil, errors.New("call StartClient() before calling bulk()")
 	}
-	bulkIndexer, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
-		Client: e.client,
-		Index:  index,
-	})
+
+	res, err := e.client.Bulk(body)
 	if err != nil {
-		return nil, errors.Wrap(err, "creating elastic bulk indexer")
+		return nil, errors.Wrap(err, "sending bulk request")
 	}
 
-	return bulkIndexer, nil
+	return res, nil
 }
 
 func (e *Elastic) Search() *search.Search {
@@ -99,17 +122,66 @@ func (e *Elastic) Get(index string, documentId string) *get.Get {
 	return e.TypedClient.Get(index, documentId)
 }
 
-func (e *Elastic) elasticClientConfig() elasticsearch.Config {
-	elasticTransport := http.DefaultTransport.(*http.Transport)
-	elasticTransport.TLSClientConfig = &tls.Config{
-		InsecureSkipVerify: true,
+// elasticClientConfig returns the configuration of a client with its own transport, so the TLS settings don't leak
+// to other HTTP clients of the process
+func (e *Elastic) elasticClientConfig() (elasticsearch.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: e.Config.InsecureSkipVerify,
+	}
+	if e.Config.ClientCertPath != "" || e.Config.ClientKeyPath != "" {
+		cert, err := tls.LoadX509KeyPair(e.Config.ClientCertPath, e.Config.ClientKeyPath)
+		if err != nil {
+			return elasticsearch.Config{}, errors.Wrap(err, "loading client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	// The CA certificate and the fingerprint are set here rather than by the elasticsearch client, which requires an
+	// *http.Transport and can't be given timeoutTransport
+	if e.Config.CACertPath != "" {
+		caCert, err := os.ReadFile(e.Config.CACertPath)
+		if err != nil {
+			return elasticsearch.Config{}, errors.Wrap(err, "reading CA certificate")
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caCert) {
+			return elasticsearch.Config{}, errors.Errorf("no certificate found in CA certificate %s", e.Config.CACertPath)
+		}
+	}
+	if e.Config.CertificateFingerprint != "" {
+		fingerprint, err := hex.DecodeString(e.Config.CertificateFingerprint)
+		if err != nil {
+			return elasticsearch.Config{}, errors.Wrap(err, "decoding certificate fingerprint")
+		}
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = verifyFingerprint(fingerprint)
+	}
+
+	elasticTransport := http.DefaultTransport.(*http.Transport).Clone()
+	elasticTransport.TLSClientConfig = tlsConfig
+	var transport http.RoundTripper = elasticTransport
+	if e.Config.RequestTimeout > 0 {
+		transport = timeoutTransport{next: elasticTransport, timeout: e.Config.RequestTimeout}
 	}
 
 	elasticConfig := elasticsearch.Config{
-		Addresses: e.Config.Addresses,
-		Username:  e.Config.Username,
-		Password:  e.Config.Password,
-		Transport: elasticTransport,
+		Addresses:     e.Config.Addresses,
+		CloudID:       e.Config.CloudId,
+		Username:      e.Config.Username,
+		Password:      e.Config.Password,
+		APIKey:        e.Config.ApiKey,
+		ServiceToken:  e.Config.ServiceToken,
+		Transport:     transport,
+		RetryOnStatus: []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		MaxRetries:    defaultMaxRetries,
+		RetryBackoff:  e.retryBackoff,
+	}
+
+	if e.Config.MaxRetries < 0 {
+		elasticConfig.DisableRetry = true
+	} else if e.Config.MaxRetries > 0 {
+		elasticConfig.MaxRetries = e.Config.MaxRetries
 	}
 
 	if e.Config.LogRequests {
@@ -120,5 +192,59 @@ func (e *Elastic) elasticClientConfig() elasticsearch.Config {
 		}
 	}
 
-	return elasticConfig
+	return elasticConfig, nil
+}
+
+// retryBackoff returns the wait before the retry attempt, starting at 1
+func (e *Elastic) retryBackoff(attempt int) time.Duration {
+	backoff := e.Config.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	return backoff << (attempt - 1)
+}
+
+// verifyFingerprint accepts the certificates of a connection if one of them has the SHA256 fingerprint, whether or not
+// it is signed by a trusted CA
+func verifyFingerprint(fingerprint []byte) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		for _, rawCert := range rawCerts {
+			digest := sha256.Sum256(rawCert)
+			if bytes.Equal(digest[:], fingerprint) {
+				return nil
+			}
+		}
+		return errors.Errorf("no certificate of the cluster matches the fingerprint %x", fingerprint)
+	}
+}
+
+// timeoutTransport bounds each request with a timeout set on its context, it applies to reading the response body
+// too. Each retry of the elasticsearch client gets its own timeout.
+type timeoutTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	res, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	res.Body = cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// cancelOnClose cancels the context of a request once its response body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
 }
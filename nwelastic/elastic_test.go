@@ -1,9 +1,16 @@
 package nwelastic
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
 	"time"
@@ -38,6 +45,114 @@ func TestElastic_StartTypedClient_failPing(t *testing.T) {
 	assert.Contains(t, err.Error(), ErrFailedToPingElastic.Error())
 }
 
+func TestElastic_StartClient_tls(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	caCertPath := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caCertPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	if !assert.NoError(t, err) {
+		return
+	}
+	fingerprint := sha256.Sum256(server.Certificate().Raw)
+
+	tests := []struct {
+		name        string
+		config      ElasticConfig
+		expectedErr string
+	}{
+		{"untrusted certificate", ElasticConfig{}, ErrFailedToPingElastic.Error()},
+		{"CA certificate", ElasticConfig{CACertPath: caCertPath}, ""},
+		{"missing CA certificate", ElasticConfig{CACertPath: caCertPath + ".missing"}, "reading CA certificate"},
+		{"certificate fingerprint", ElasticConfig{CertificateFingerprint: hex.EncodeToString(fingerprint[:])}, ""},
+		{"insecure", ElasticConfig{InsecureSkipVerify: true}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Addresses = []string{server.URL}
+			tt.config.MaxRetries = -1
+			e := NewElastic(tt.config)
+
+			err := e.StartClient()
+			if tt.expectedErr != "" {
+				assert.ErrorContains(t, err, tt.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// The default transport of the process is left untouched
+	if defaultTLS := http.DefaultTransport.(*http.Transport).TLSClientConfig; defaultTLS != nil {
+		assert.False(t, defaultTLS.InsecureSkipVerify)
+		assert.Nil(t, defaultTLS.RootCAs)
+	}
+}
+
+func TestElastic_retries(t *testing.T) {
+	tests := []struct {
+		name             string
+		start            func(e *Elastic) error
+		expectedRequests int
+		expectedErr      bool
+	}{
+		{"typed client", (*Elastic).StartTypedClient, 3, false},
+		// Bulk requests are retried by NewsRepository
+		{"bulk client", (*Elastic).StartClient, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Elastic-Product", "Elasticsearch")
+				requests++
+				if requests < 3 {
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				w.Write([]byte(`{}`))
+			}))
+			defer server.Close()
+
+			e := NewElastic(ElasticConfig{Addresses: []string{server.URL}, RetryBackoff: time.Millisecond})
+			err := tt.start(&e)
+			assert.Equal(t, tt.expectedErr, err != nil, "error: %v", err)
+			assert.Equal(t, tt.expectedRequests, requests)
+		})
+	}
+}
+
+func TestElastic_requestTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		if r.URL.Path == "/" && r.Method == http.MethodHead {
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		// The headers are sent but the body is late
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	defer close(done)
+
+	e := NewElastic(ElasticConfig{Addresses: []string{server.URL}, RequestTimeout: 50 * time.Millisecond, MaxRetries: -1})
+	start := time.Now()
+	assert.NoError(t, e.StartTypedClient())
+
+	_, err := e.TypedClient.Info().Do(context.Background())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
 // nwElasticSuite performs integration tests, they are run unless the test -short flag is set
 type nwElasticSuite struct {
 	suite.Suite
@@ -0,0 +1,253 @@
+package nwelastic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/sortorder"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultExportBatchSize = 1000
+	defaultExportKeepAlive = time.Minute
+	defaultImportBatchSize = 1000
+)
+
+// ExportOpts configures NewsRepository.Export
+type ExportOpts struct {
+	// BatchSize is the number of news read per search request, defaults to 1000
+	BatchSize int `yaml:"batchSize"`
+	// KeepAlive is how long the point in time is kept between two search requests, defaults to 1m
+	KeepAlive time.Duration `yaml:"keepAlive"`
+	// OnProgress, if set, is called after each batch is written
+	OnProgress func(ExportProgress) `yaml:"-"`
+}
+
+// ExportProgress is the progress of an Export
+type ExportProgress struct {
+	Exported int64
+	// Total is the number of news matching the filter when the export started
+	Total int64
+}
+
+// ImportOpts configures NewsRepository.Import
+type ImportOpts struct {
+	// BatchSize is the number of news passed to each InsertBatch call, defaults to 1000
+	BatchSize int `yaml:"batchSize"`
+	// StartLine is the number of lines skipped before importing, pass ImportProgress.Line of an interrupted import to
+	// resume it
+	StartLine int64 `yaml:"startLine"`
+	// OnProgress, if set, is called after each batch is inserted
+	OnProgress func(ImportProgress) `yaml:"-"`
+}
+
+// ImportProgress is the progress of an Import
+type ImportProgress struct {
+	// Line is the number of lines read and inserted, including the skipped ones
+	Line     int64
+	Imported int64
+	Failed   int64
+}
+
+// Export writes the news matching filter to w as NDJSON, one news per line, ordered by publication time. The news are
+// read from a point in time, so news inserted during the export aren't included, and filter.Sort, Order, Size and
+// Cursor are ignored. The bodies of news stored with BodyStorageChunks are reassembled, so they are exported whole.
+func (b NewsRepository) Export(ctx context.Context, filter NewsFilter, w io.Writer, opts ...ExportOpts) (ExportProgress, error) {
+	var o ExportOpts
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = defaultExportBatchSize
+	}
+	if o.KeepAlive <= 0 {
+		o.KeepAlive = defaultExportKeepAlive
+	}
+	keepAlive := strconv.FormatInt(o.KeepAlive.Milliseconds(), 10) + "ms"
+
+	filter.Cursor = ""
+	req, err := newsSearchRequest(filter)
+	if err != nil {
+		return ExportProgress{}, err
+	}
+
+	pit, err := b.elastic.TypedClient.OpenPointInTime(b.Index).KeepAlive(keepAlive).Do(ctx)
+	if err != nil {
+		return ExportProgress{}, errors.Wrap(err, "opening point in time")
+	}
+	pitId := pit.Id
+	defer func() {
+		// The point in time expires after keepAlive if it can't be closed
+		b.elastic.TypedClient.ClosePointInTime().Id(pitId).Do(context.Background())
+	}()
+
+	req.Pit = &types.PointInTimeReference{Id: pitId, KeepAlive: keepAlive}
+	req.Size = &o.BatchSize
+	req.Sort = []types.SortCombinations{
+		map[string]types.FieldSort{"publicationTime": {Order: &sortorder.Asc}},
+		map[string]types.FieldSort{"_shard_doc": {Order: &sortorder.Asc}},
+	}
+	req.TrackTotalHits = true
+
+	var progress ExportProgress
+	encoder := json.NewEncoder(w)
+	for {
+		res, err := b.elastic.TypedClient.Search().Request(req).Do(ctx)
+		if err != nil {
+			return progress, errors.Wrap(err, "searching news to export")
+		}
+		if res.PitId != nil {
+			pitId = *res.PitId
+			req.Pit.Id = pitId
+		}
+		if res.Hits.Total != nil && req.SearchAfter == nil {
+			progress.Total = res.Hits.Total.Value
+		}
+		req.TrackTotalHits = nil
+
+		for _, hit := range res.Hits.Hits {
+			var news News
+			err = json.Unmarshal(hit.Source_, &news)
+			if err != nil {
+				return progress, errors.Wrap(err, "unmarshaling news")
+			}
+			// News inserted without id only have the id generated by Elasticsearch
+			if news.Id == "" && hit.Id_ != nil {
+				news.Id = *hit.Id_
+			}
+			err = b.exportBody(ctx, &news)
+			if err != nil {
+				return progress, err
+			}
+
+			err = encoder.Encode(news)
+			if err != nil {
+				return progress, errors.Wrapf(err, "writing news %s", news.Id)
+			}
+			progress.Exported++
+		}
+		if o.OnProgress != nil && len(res.Hits.Hits) > 0 {
+			o.OnProgress(progress)
+		}
+
+		if len(res.Hits.Hits) < o.BatchSize {
+			return progress, nil
+		}
+		req.SearchAfter = res.Hits.Hits[len(res.Hits.Hits)-1].Sort
+	}
+}
+
+// exportBody reassembles the body of a news stored with BodyStorageChunks and unsets its storage fields, it is then
+// stored again following the body strategy of the importing repository
+func (b NewsRepository) exportBody(ctx context.Context, news *News) error {
+	if news.BodyStorage != BodyStorageChunks {
+		return nil
+	}
+
+	err := b.ReassembleBody(ctx, news)
+	if err != nil {
+		return err
+	}
+	news.BodyStorage = ""
+	news.BodyLength = 0
+	news.BodyChunks = 0
+	return nil
+}
+
+// Import inserts the news of r, written by Export, through InsertBatch, news keep their creation time unless it's
+// missing. Empty lines are skipped. If a news can't be inserted, the import continues and a *BulkError is returned at
+// the end, its failures have the index of their line. Other errors stop the import, ImportProgress.Line can then be
+// passed as ImportOpts.StartLine to resume it.
+func (b NewsRepository) Import(ctx context.Context, r io.Reader, opts ...ImportOpts) (ImportProgress, error) {
+	var o ImportOpts
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = defaultImportBatchSize
+	}
+
+	progress := ImportProgress{Line: o.StartLine}
+	var failures []BulkFailure
+	batch := make([]*News, 0, o.BatchSize)
+	// batchLines holds the line index of each news of the batch
+	batchLines := make([]int64, 0, o.BatchSize)
+	line := int64(0)
+
+	flush := func(lastLine int64) error {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+
+		if len(batch) > 0 {
+			failed := 0
+			err = b.insertBatch(batch, true, func(int, int) {})
+			var bulkErr *BulkError
+			if errors.As(err, &bulkErr) {
+				for _, failure := range bulkErr.Failures {
+					failure.Index = int(batchLines[failure.Index])
+					failures = append(failures, failure)
+				}
+				failed = len(bulkErr.Failures)
+			} else if err != nil {
+				return errors.Wrapf(err, "importing lines %d to %d", batchLines[0], lastLine-1)
+			}
+			progress.Imported += int64(len(batch) - failed)
+			progress.Failed += int64(failed)
+		}
+
+		progress.Line = lastLine
+		batch = batch[:0]
+		batchLines = batchLines[:0]
+		if o.OnProgress != nil {
+			o.OnProgress(progress)
+		}
+		return nil
+	}
+
+	reader := bufio.NewReader(r)
+	for {
+		data, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return progress, errors.Wrapf(readErr, "reading line %d", line)
+		}
+		if len(data) > 0 {
+			if line >= o.StartLine {
+				data = bytes.TrimSpace(data)
+				if len(data) > 0 {
+					news := &News{}
+					err := json.Unmarshal(data, news)
+					if err != nil {
+						return progress, errors.Wrapf(err, "unmarshaling news of line %d", line)
+					}
+					batch = append(batch, news)
+					batchLines = append(batchLines, line)
+				}
+			}
+			line++
+		}
+
+		if len(batch) == o.BatchSize || (readErr == io.EOF && line > progress.Line) {
+			err := flush(line)
+			if err != nil {
+				return progress, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	if len(failures) > 0 {
+		return progress, &BulkError{Failures: failures}
+	}
+	return progress, nil
+}
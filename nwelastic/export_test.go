@@ -0,0 +1,183 @@
+package nwelastic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewsRepository_Export(t *testing.T) {
+	var actualSearches []map[string]any
+	var closedPit string
+	elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/nwelastic_tests/_pit":
+			w.Write([]byte(`{"id": "pit-1"}`))
+		case r.URL.Path == "/_pit" && r.Method == http.MethodDelete:
+			var body struct {
+				Id string `json:"id"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			closedPit = body.Id
+			w.Write([]byte(`{"succeeded": true, "num_freed": 1}`))
+		case r.URL.Path == "/_search":
+			var search map[string]any
+			json.NewDecoder(r.Body).Decode(&search)
+			actualSearches = append(actualSearches, search)
+			if search["search_after"] == nil {
+				w.Write([]byte(`{"pit_id": "pit-2", "hits": {"total": {"value": 3, "relation": "eq"}, "hits": [
+					{"_id": "1", "_source": {"id": "1", "headline": "first"}, "sort": [1, 0]},
+					{"_id": "generated", "_source": {"headline": "second"}, "sort": [2, 1]}
+				]}}`))
+				return
+			}
+			w.Write([]byte(`{"pit_id": "pit-2", "hits": {"hits": [
+				{"_id": "3", "_source": {"id": 3, "headline": "third"}, "sort": [3, 2]}
+			]}}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	repository, err := NewNewsRepository(elastic)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var output bytes.Buffer
+	var actualProgress []ExportProgress
+	progress, err := repository.Export(context.Background(), NewsFilter{Sources: []string{"SEC"}}, &output, ExportOpts{
+		BatchSize:  2,
+		OnProgress: func(progress ExportProgress) { actualProgress = append(actualProgress, progress) },
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var actualIds, actualHeadlines []string
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		var news News
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &news))
+		actualIds = append(actualIds, news.Id)
+		actualHeadlines = append(actualHeadlines, news.Headline)
+	}
+	assert.Equal(t, []string{"1", "generated", "3"}, actualIds)
+	assert.Equal(t, []string{"first", "second", "third"}, actualHeadlines)
+	assert.Equal(t, ExportProgress{Exported: 3, Total: 3}, progress)
+	assert.Equal(t, []ExportProgress{{Exported: 2, Total: 3}, {Exported: 3, Total: 3}}, actualProgress)
+	assert.Equal(t, "pit-2", closedPit)
+
+	if assert.Len(t, actualSearches, 2) {
+		assert.Equal(t, map[string]any{"id": "pit-1", "keep_alive": "60000ms"}, actualSearches[0]["pit"])
+		assert.NotNil(t, actualSearches[0]["query"])
+		assert.Equal(t, "pit-2", actualSearches[1]["pit"].(map[string]any)["id"])
+		assert.Equal(t, []any{float64(2), float64(1)}, actualSearches[1]["search_after"])
+	}
+}
+
+func TestNewsRepository_Import(t *testing.T) {
+	input := strings.Join([]string{
+		`{"id": "1", "headline": "first", "creationTime": "2024-01-02T03:04:05Z"}`,
+		`{"id": "2", "headline": "second"}`,
+		``,
+		`{"id": "failing", "headline": "third"}`,
+		`{"id": "4", "headline": "fourth"}`,
+		`{"id": "5", "headline": "fifth"}`,
+	}, "\n")
+
+	tests := []struct {
+		name                  string
+		opts                  ImportOpts
+		input                 io.Reader
+		expectedIds           []string
+		expectedCreationTimes map[string]time.Time
+		expectedProgress      ImportProgress
+		expectedFailure       int
+		expectedErr           string
+	}{
+		{
+			name:                  "import",
+			opts:                  ImportOpts{BatchSize: 2},
+			input:                 strings.NewReader(input),
+			expectedIds:           []string{"1", "2", "failing", "4", "5"},
+			expectedCreationTimes: map[string]time.Time{"1": time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+			expectedProgress:      ImportProgress{Line: 6, Imported: 4, Failed: 1},
+			expectedFailure:       3,
+		},
+		{
+			name:             "resume",
+			opts:             ImportOpts{BatchSize: 2, StartLine: 4},
+			input:            strings.NewReader(input),
+			expectedIds:      []string{"4", "5"},
+			expectedProgress: ImportProgress{Line: 6, Imported: 2},
+		},
+		{
+			name:             "invalid line",
+			opts:             ImportOpts{BatchSize: 2},
+			input:            strings.NewReader(`{"id": "1"}` + "\n" + `{"id": "2"}` + "\n" + `{"id":`),
+			expectedIds:      []string{"1", "2"},
+			expectedProgress: ImportProgress{Line: 2, Imported: 2},
+			expectedErr:      "unmarshaling news of line 2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var actualIds []string
+			actualCreationTimes := map[string]time.Time{}
+			elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+				var items []map[string]any
+				scanner := bufio.NewScanner(r.Body)
+				for scanner.Scan() {
+					scanner.Scan()
+					var news News
+					json.Unmarshal(scanner.Bytes(), &news)
+					actualIds = append(actualIds, news.Id)
+					actualCreationTimes[news.Id] = news.CreationTime
+
+					item := map[string]any{"_id": news.Id, "status": http.StatusCreated}
+					if news.Id == "failing" {
+						item["status"] = http.StatusBadRequest
+						item["error"] = map[string]any{"type": "mapper_parsing_exception", "reason": "failed to parse"}
+					}
+					items = append(items, map[string]any{"index": item})
+				}
+				json.NewEncoder(w).Encode(map[string]any{"took": 1, "errors": true, "items": items})
+			})
+			repository, err := NewNewsRepository(elastic)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			progress, err := repository.Import(context.Background(), tt.input, tt.opts)
+
+			assert.Equal(t, tt.expectedIds, actualIds)
+			assert.Equal(t, tt.expectedProgress, progress)
+			// The creation time of the file is kept, news without one get the time of the import
+			for id, creationTime := range actualCreationTimes {
+				if expected, ok := tt.expectedCreationTimes[id]; ok {
+					assert.True(t, expected.Equal(creationTime), "creation time of %s is %s", id, creationTime)
+				} else {
+					assert.False(t, creationTime.IsZero(), "creation time of %s", id)
+				}
+			}
+			if tt.expectedErr != "" {
+				assert.ErrorContains(t, err, tt.expectedErr)
+				return
+			}
+			var bulkErr *BulkError
+			if tt.expectedFailure == 0 {
+				assert.NoError(t, err)
+			} else if assert.ErrorAs(t, err, &bulkErr) && assert.Len(t, bulkErr.Failures, 1) {
+				assert.Equal(t, tt.expectedFailure, bulkErr.Failures[0].Index)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package nwelastic
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrNewsNotFound = errors.New("news not found")
+)
+
+// GetById returns the news with id, or ErrNewsNotFound. The body of a news stored with BodyStorageChunks is
+// reassembled.
+func (b NewsRepository) GetById(ctx context.Context, id string) (*News, error) {
+	news, _, err := b.GetMany(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(news) == 0 {
+		return nil, errors.Wrap(ErrNewsNotFound, id)
+	}
+
+	return news[0], nil
+}
+
+// GetMany returns the news with ids in the order of ids, and the ids that weren't found. The bodies of news stored with
+// BodyStorageChunks are reassembled.
+func (b NewsRepository) GetMany(ctx context.Context, ids []string) (news []*News, missing []string, err error) {
+	if len(ids) == 0 {
+		return nil, nil, nil
+	}
+
+	var found map[string]*News
+	if b.opts.Partitioning == PartitionNone {
+		found, err = b.mget(ctx, ids)
+	} else {
+		found, err = b.partitionsMget(ctx, ids)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, id := range ids {
+		newsItem, ok := found[id]
+		if !ok {
+			missing = append(missing, id)
+			continue
+		}
+
+		err = b.ReassembleBody(ctx, newsItem)
+		if err != nil {
+			return nil, nil, err
+		}
+		news = append(news, newsItem)
+	}
+
+	return news, missing, nil
+}
+
+// Exists returns true if a news with id exists
+func (b NewsRepository) Exists(ctx context.Context, id string) (bool, error) {
+	if b.opts.Partitioning == PartitionNone {
+		exists, err := b.elastic.TypedClient.Exists(b.Index, id).Do(ctx)
+		if err != nil {
+			return false, errors.Wrapf(err, "checking if news %s exists", id)
+		}
+		return exists, nil
+	}
+
+	partitions, err := b.newsPartitions(ctx, []string{id})
+	if err != nil {
+		return false, errors.Wrapf(err, "checking if news %s exists", id)
+	}
+	return len(partitions[id]) > 0, nil
+}
+
+// mget gets news by id from a single index
+func (b NewsRepository) mget(ctx context.Context, ids []string) (map[string]*News, error) {
+	res, err := b.elastic.TypedClient.Mget().Index(b.Index).Ids(ids...).Do(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting news")
+	}
+
+	found := make(map[string]*News, len(res.Docs))
+	for i, doc := range res.Docs {
+		news := &News{}
+		ok, err := mgetSource(doc, news)
+		if err != nil {
+			return nil, errors.Wrapf(err, "news %s", ids[i])
+		}
+		if ok {
+			found[ids[i]] = news
+		}
+	}
+
+	return found, nil
+}
+
+// partitionsMget gets news by id from every partition with a realtime multi get, the read alias of partitions doesn't
+// support multi get. If a news is in several partitions, the one created last is returned.
+func (b NewsRepository) partitionsMget(ctx context.Context, ids []string) (map[string]*News, error) {
+	docs, err := b.partitionDocs(ctx, ids, true)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]*News, len(docs))
+	for id, copies := range docs {
+		for _, doc := range copies {
+			news := &News{}
+			err = json.Unmarshal(doc.Source_, news)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unmarshaling news %s", id)
+			}
+			if last, ok := found[id]; !ok || news.CreationTime.After(last.CreationTime) {
+				found[id] = news
+			}
+		}
+	}
+
+	return found, nil
+}
+
+func idsQuery(ids []string) *types.Query {
+	return &types.Query{Ids: &types.IdsQuery{Values: ids}}
+}
@@ -0,0 +1,137 @@
+package nwelastic
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewsRepository_GetMany(t *testing.T) {
+	tests := []struct {
+		name            string
+		partitioning    string
+		response        string
+		ids             []string
+		expectedPath    string
+		expectedIds     []string
+		expectedMissing []string
+	}{
+		{
+			name:         "multi get",
+			response:     `{"docs": [{"_id": "2", "found": true, "_source": {"id": 2}}, {"_id": "3", "found": false}, {"_id": "1", "found": true, "_source": {"id": "1"}}]}`,
+			ids:          []string{"2", "3", "1"},
+			expectedPath: "/_mget",
+			// Numeric ids are decoded as strings
+			expectedIds:     []string{"2", "1"},
+			expectedMissing: []string{"3"},
+		},
+		{
+			name:         "partitioned",
+			partitioning: PartitionMonthly,
+			response: `{"docs": [
+				{"_index": "nwelastic_tests-2024.01", "_id": "1", "found": true, "_source": {"id": "1", "headline": "older", "creationTime": "2024-01-01T00:00:00Z"}},
+				{"_index": "nwelastic_tests-2024.02", "_id": "1", "found": true, "_source": {"id": "1", "headline": "newer", "creationTime": "2024-02-01T00:00:00Z"}},
+				{"_index": "nwelastic_tests-2024.01", "_id": "2", "found": true, "_source": {"id": 2}},
+				{"_index": "nwelastic_tests-2024.02", "_id": "2", "found": false},
+				{"_index": "nwelastic_tests-2024.01", "_id": "3", "found": false},
+				{"_index": "nwelastic_tests-2024.02", "_id": "3", "found": false}
+			]}`,
+			ids:             []string{"3", "2", "1"},
+			expectedPath:    "/_mget",
+			expectedIds:     []string{"2", "1"},
+			expectedMissing: []string{"3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var actualPath string
+			elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/_resolve/index/nwelastic_tests-*" {
+					w.Write([]byte(`{"indices": [{"name": "nwelastic_tests-2024.01"}, {"name": "nwelastic_tests-2024.02"}], "aliases": [], "data_streams": []}`))
+					return
+				}
+				actualPath = r.URL.Path
+				w.Write([]byte(tt.response))
+			})
+			repository, err := NewNewsRepository(elastic, NewsRepositoryOpts{Partitioning: tt.partitioning})
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			news, missing, err := repository.GetMany(context.Background(), tt.ids)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			assert.True(t, strings.HasSuffix(actualPath, tt.expectedPath), actualPath)
+			actualIds := make([]string, len(news))
+			for i, newsItem := range news {
+				actualIds[i] = newsItem.Id
+			}
+			assert.Equal(t, tt.expectedIds, actualIds)
+			assert.Equal(t, tt.expectedMissing, missing)
+			if tt.partitioning != PartitionNone {
+				assert.Equal(t, "newer", news[1].Headline)
+			}
+		})
+	}
+}
+
+func TestNewsRepository_GetById(t *testing.T) {
+	elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"docs": [{"_id": "1", "found": false}]}`))
+	})
+	repository, err := NewNewsRepository(elastic)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	news, err := repository.GetById(context.Background(), "1")
+	assert.Nil(t, news)
+	assert.ErrorIs(t, err, ErrNewsNotFound)
+}
+
+func TestNewsRepository_Exists(t *testing.T) {
+	elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/_doc/1") {
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	repository, err := NewNewsRepository(elastic)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	exists, err := repository.Exists(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = repository.Exists(context.Background(), "2")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestNewsRepository_Exists_partitioned(t *testing.T) {
+	partitions := []string{"nwelastic_tests-2024.01", "nwelastic_tests-2024.02"}
+	elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+		if !servePartitions(w, r, partitions, map[string][]string{"1": {"nwelastic_tests-2024.02"}}) {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	repository, err := NewNewsRepository(elastic, NewsRepositoryOpts{Partitioning: PartitionMonthly})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	exists, err := repository.Exists(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = repository.Exists(context.Background(), "2")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
@@ -0,0 +1,52 @@
+package nwelastic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// IdPolicyNone keeps News.Id, Elasticsearch generates a random id if it's empty
+	IdPolicyNone = ""
+	// IdPolicyProvider derives the id from News.Source and News.ProviderId, falling back to IdPolicyContent if the
+	// news has no provider id
+	IdPolicyProvider = "provider"
+	// IdPolicyContent derives the id from News.Headline, News.PublicationTime and News.Source
+	IdPolicyContent = "content"
+)
+
+var (
+	ErrInvalidIdPolicy = errors.New("invalid id policy")
+	ErrAlreadyExists   = errors.New("news already exists")
+)
+
+func validateIdPolicy(policy string) error {
+	switch policy {
+	case IdPolicyNone, IdPolicyProvider, IdPolicyContent:
+		return nil
+	}
+	return errors.Wrap(ErrInvalidIdPolicy, policy)
+}
+
+// ensureId sets the id of news from the id policy if it's empty, so a news sent twice is stored once
+func (b NewsRepository) ensureId(news *News) {
+	if news.Id != "" || b.opts.IdPolicy == IdPolicyNone {
+		return
+	}
+
+	if b.opts.IdPolicy == IdPolicyProvider && news.ProviderId != "" {
+		news.Id = hashId(news.Source, news.ProviderId)
+		return
+	}
+	news.Id = hashId(news.Headline, news.PublicationTime.UTC().Format(time.RFC3339Nano), news.Source)
+}
+
+// hashId returns the hex encoded sha256 of parts, separated so that moving characters between parts changes the id
+func hashId(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,40 @@
+package nwelastic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewsRepository_ensureId(t *testing.T) {
+	publicationTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	contentId := hashId("headline", "2024-01-01T00:00:00Z", "SOURCE")
+	providerId := hashId("SOURCE", "provider-1")
+
+	tests := []struct {
+		name       string
+		policy     string
+		news       News
+		expectedId string
+	}{
+		{"no policy", IdPolicyNone, News{Headline: "headline", Source: "SOURCE"}, ""},
+		{"id is kept", IdPolicyContent, News{Id: "1", Headline: "headline", Source: "SOURCE"}, "1"},
+		{"content", IdPolicyContent, News{Headline: "headline", Source: "SOURCE", PublicationTime: publicationTime}, contentId},
+		{"content ignores the time zone", IdPolicyContent, News{Headline: "headline", Source: "SOURCE", PublicationTime: publicationTime.In(time.FixedZone("EST", -5*3600))}, contentId},
+		{"provider", IdPolicyProvider, News{ProviderId: "provider-1", Headline: "other", Source: "SOURCE"}, providerId},
+		{"provider without provider id", IdPolicyProvider, News{Headline: "headline", Source: "SOURCE", PublicationTime: publicationTime}, contentId},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := NewsRepository{opts: NewsRepositoryOpts{IdPolicy: tt.policy}}
+			repository.ensureId(&tt.news)
+			assert.Equal(t, tt.expectedId, tt.news.Id)
+		})
+	}
+}
+
+func TestNewNewsRepository_invalidIdPolicy(t *testing.T) {
+	_, err := NewNewsRepository(newFakeElastic(t, nil), NewsRepositoryOpts{IdPolicy: "random"})
+	assert.ErrorIs(t, err, ErrInvalidIdPolicy)
+}
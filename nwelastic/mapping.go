@@ -0,0 +1,469 @@
+package nwelastic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrSchemaOutdated      = errors.New("news index schema is outdated, call EnsureNewsIndex")
+	ErrIncompatibleMapping = errors.New("the mapping of an existing field can't be changed, the index must be reindexed with ReindexNews")
+)
+
+// newsAnalysis defines the analyzers and normalizers used by newsMappings
+const newsAnalysis = `{
+	"analyzer": {
+		"news_text": {
+			"type": "custom",
+			"tokenizer": "standard",
+			"filter": ["lowercase", "asciifolding"]
+		}
+	},
+	"normalizer": {
+		"uppercase": {
+			"type": "custom",
+			"filter": ["uppercase"]
+		}
+	}
+}`
+
+// newsMappings is the mapping of News. Unknown fields are kept in _source but not indexed.
+const newsMappings = `{
+	"dynamic": false,
+	"properties": {
+		"id": {"type": "keyword"},
+		"providerId": {"type": "keyword"},
+		"headline": {
+			"type": "text",
+			"analyzer": "news_text",
+			"fields": {"keyword": {"type": "keyword", "ignore_above": 1024}}
+		},
+		"body": {"type": "text", "analyzer": "news_text"},
+		"tickers": {"type": "keyword", "normalizer": "uppercase"},
+		"source": {"type": "keyword"},
+		"publicationTime": {"type": "date"},
+		"receivedTime": {"type": "date"},
+		"creationTime": {"type": "date"},
+		"categoryCodes": {"type": "keyword"},
+		"industryCodes": {"type": "keyword"},
+		"regionCodes": {"type": "keyword"},
+		"ciks": {"type": "long"},
+		"link": {"type": "keyword", "ignore_above": 2048},
+		"revision": {"type": "long"},
+		"bodyTruncated": {"type": "boolean"},
+		"bodyLength": {"type": "long"},
+		"bodyStorage": {"type": "keyword"},
+		"bodyChunks": {"type": "integer"}
+	}
+}`
+
+// SchemaMigration is the document stored in the migrations index of the news index for each migration applied
+type SchemaMigration struct {
+	Version     int       `json:"version"`
+	Description string    `json:"description"`
+	AppliedAt   time.Time `json:"appliedAt"`
+}
+
+type migration struct {
+	version     int
+	description string
+	apply       func(ctx context.Context, e *Elastic, index string, version int, opts NewsIndexOpts) error
+}
+
+// newsMigrations are applied in order by EnsureNewsIndex. Mapping changes must be additive, they are made to
+// newsMappings and released with a new migration that applies it, a released migration must never be removed.
+var newsMigrations = []migration{
+	{
+		version:     1,
+		description: "news index template and mapping",
+		apply:       applyNewsSchema,
+	},
+	{
+		version:     2,
+		description: "providerId field",
+		apply:       applyNewsSchema,
+	},
+	{
+		version:     3,
+		description: "revision field and history index",
+		apply:       applyNewsSchema,
+	},
+	{
+		version:     4,
+		description: "body storage fields and body chunks index",
+		apply: func(ctx context.Context, e *Elastic, index string, version int, opts NewsIndexOpts) error {
+			if err := applyNewsSchema(ctx, e, index, version, opts); err != nil {
+				return err
+			}
+			return putBodyChunksTemplate(ctx, e, index, version)
+		},
+	},
+	{
+		version:     5,
+		description: "body chunk fields used to delete news by query",
+		apply: func(ctx context.Context, e *Elastic, index string, version int, opts NewsIndexOpts) error {
+			if err := putBodyChunksTemplate(ctx, e, index, version); err != nil {
+				return err
+			}
+
+			exists, err := e.TypedClient.Indices.Exists(bodyChunksIndex(index)).Do(ctx)
+			if err != nil {
+				return errors.Wrap(err, "checking if body chunks index exists")
+			}
+			if !exists {
+				return nil
+			}
+			return putAnalysisAndMappings(ctx, e, bodyChunksIndex(index), bodyChunksMappings)
+		},
+	},
+}
+
+// NewsSchemaVersion is the version of the latest migration of the news index
+func NewsSchemaVersion() int {
+	return newsMigrations[len(newsMigrations)-1].version
+}
+
+// applyNewsSchema puts the current index templates, labeled with version, and mapping to index or its partitions
+func applyNewsSchema(ctx context.Context, e *Elastic, index string, version int, opts NewsIndexOpts) error {
+	if err := putNewsTemplate(ctx, e, index, version); err != nil {
+		return err
+	}
+
+	if opts.Partitioning == PartitionNone {
+		return ensureNewsMappings(ctx, e, index)
+	}
+
+	if err := putNewsPartitionTemplate(ctx, e, index, version, opts); err != nil {
+		return err
+	}
+	return ensurePartitionMappings(ctx, e, index)
+}
+
+// EnsureNewsIndex applies the pending migrations of the news index elastic.Config.NewsIndex: it creates or updates the
+// index template, creates the index if it doesn't exist and updates the mapping of an existing index. Applied
+// migrations are recorded in the migrations index, "<index>_migrations", so it can be called on every startup.
+//
+// With PartitionMonthly, the partitions get the mapping from their own index template and are created by RolloverNews,
+// which EnsureNewsIndex calls. The index name is then a read alias, ErrIndexNotPartitioned is returned if a concrete
+// index has that name.
+func EnsureNewsIndex(ctx context.Context, elastic Elastic, opts ...NewsIndexOpts) error {
+	var o NewsIndexOpts
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	err := validatePartitioning(o.Partitioning)
+	if err != nil {
+		return err
+	}
+
+	err = elastic.StartTypedClient()
+	if err != nil {
+		return err
+	}
+
+	index := elastic.Config.NewsIndex
+	if o.Partitioning != PartitionNone {
+		err = verifyNotConcrete(ctx, &elastic, index)
+		if err != nil {
+			return err
+		}
+	}
+
+	version, err := newsIndexVersion(ctx, &elastic, index)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range newsMigrations {
+		if m.version <= version {
+			continue
+		}
+
+		err = m.apply(ctx, &elastic, index, m.version, o)
+		if err != nil {
+			return errors.Wrapf(err, "applying migration %d of index %s", m.version, index)
+		}
+
+		_, err = elastic.TypedClient.Index(migrationsIndex(index)).
+			Id(strconv.Itoa(m.version)).
+			Request(SchemaMigration{Version: m.version, Description: m.description, AppliedAt: time.Now()}).
+			Do(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "recording migration %d of index %s", m.version, index)
+		}
+	}
+
+	if o.Partitioning == PartitionNone {
+		return nil
+	}
+
+	// The partition template is put even if no migration is pending, in case partitioning or the lifecycle policy
+	// changed
+	err = putNewsPartitionTemplate(ctx, &elastic, index, NewsSchemaVersion(), o)
+	if err != nil {
+		return err
+	}
+	return RolloverNews(ctx, elastic, time.Now())
+}
+
+// VerifyNewsIndex returns ErrSchemaOutdated if a migration of the news index elastic.Config.NewsIndex wasn't applied,
+// services that don't own the index can call it at startup instead of EnsureNewsIndex.
+func VerifyNewsIndex(ctx context.Context, elastic Elastic) error {
+	err := elastic.StartTypedClient()
+	if err != nil {
+		return err
+	}
+
+	version, err := newsIndexVersion(ctx, &elastic, elastic.Config.NewsIndex)
+	if err != nil {
+		return err
+	}
+
+	if version < NewsSchemaVersion() {
+		return errors.Wrapf(ErrSchemaOutdated, "index %s is at version %d, expected %d", elastic.Config.NewsIndex, version, NewsSchemaVersion())
+	}
+
+	return nil
+}
+
+// newsIndexVersion returns the version of the last consecutive migration applied to index, zero if none
+func newsIndexVersion(ctx context.Context, e *Elastic, index string) (int, error) {
+	version := 0
+	for _, m := range newsMigrations {
+		res, err := e.TypedClient.Get(migrationsIndex(index), strconv.Itoa(m.version)).Do(ctx)
+		if err != nil {
+			return 0, errors.Wrapf(err, "getting migration %d of index %s", m.version, index)
+		}
+		if !res.Found {
+			break
+		}
+		version = m.version
+	}
+
+	return version, nil
+}
+
+func migrationsIndex(index string) string {
+	return index + "_migrations"
+}
+
+// historyIndex is the index where NewsRepositoryOpts.KeepHistory copies the versions of news in index
+func historyIndex(index string) string {
+	return index + "_history"
+}
+
+// bodyChunksMappings is the mapping of the body chunks index, the text is only kept in _source. The fields of the news
+// used to delete news by query are copied so the same query deletes the chunks.
+const bodyChunksMappings = `{
+	"dynamic": false,
+	"properties": {
+		"newsId": {"type": "keyword"},
+		"seq": {"type": "integer"},
+		"source": {"type": "keyword"},
+		"tickers": {"type": "keyword", "normalizer": "uppercase"},
+		"publicationTime": {"type": "date"}
+	}
+}`
+
+func putBodyChunksTemplate(ctx context.Context, e *Elastic, index string, version int) error {
+	template, err := json.Marshal(map[string]any{
+		"index_patterns": []string{bodyChunksIndex(index)},
+		"priority":       100,
+		"version":        version,
+		"_meta": map[string]any{
+			"description": "Chunks of news bodies, managed by nwelastic.EnsureNewsIndex",
+		},
+		"template": map[string]any{
+			"settings": map[string]any{
+				"analysis": json.RawMessage(newsAnalysis),
+			},
+			"mappings": json.RawMessage(bodyChunksMappings),
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshaling body chunks index template")
+	}
+
+	_, err = e.TypedClient.Indices.PutIndexTemplate(bodyChunksIndex(index)).Raw(strings.NewReader(string(template))).Do(ctx)
+	if err != nil {
+		return errors.Wrap(err, "putting body chunks index template")
+	}
+
+	return nil
+}
+
+// newsTemplate returns the index template applied to index, its partitions, "<index>-*", and its history index
+func newsTemplate(index string, version int) ([]byte, error) {
+	return json.Marshal(map[string]any{
+		"index_patterns": []string{index, index + "-*", historyIndex(index)},
+		"priority":       100,
+		"version":        version,
+		"_meta": map[string]any{
+			"description": "News documents, managed by nwelastic.EnsureNewsIndex",
+		},
+		"template": map[string]any{
+			"settings": map[string]any{
+				"analysis": json.RawMessage(newsAnalysis),
+			},
+			"mappings": json.RawMessage(newsMappings),
+		},
+	})
+}
+
+func putNewsTemplate(ctx context.Context, e *Elastic, index string, version int) error {
+	template, err := newsTemplate(index, version)
+	if err != nil {
+		return errors.Wrap(err, "marshaling index template")
+	}
+
+	_, err = e.TypedClient.Indices.PutIndexTemplate(index).Raw(strings.NewReader(string(template))).Do(ctx)
+	if err != nil {
+		return errors.Wrap(err, "putting index template")
+	}
+
+	return nil
+}
+
+// ensureNewsMappings creates index with the template or, if it already exists, adds the analysis settings and the
+// mapping to it. Changing the type of an existing field isn't possible, such an index must be reindexed with
+// ReindexNews.
+func ensureNewsMappings(ctx context.Context, e *Elastic, index string) error {
+	exists, err := e.TypedClient.Indices.Exists(index).Do(ctx)
+	if err != nil {
+		return errors.Wrap(err, "checking if index exists")
+	}
+
+	if !exists {
+		_, err = e.TypedClient.Indices.Create(index).Do(ctx)
+		if err != nil {
+			return errors.Wrap(err, "creating index")
+		}
+		return nil
+	}
+
+	return putAnalysisAndMappings(ctx, e, index, newsMappings)
+}
+
+// putAnalysisAndMappings adds the analysis settings and mappings to the existing indices. ErrIncompatibleMapping is
+// returned, before any change, if a field already exists with another type, analyzer or normalizer. The analysis
+// settings can only be added to a closed index, so indices missing them are briefly closed and reopened.
+func putAnalysisAndMappings(ctx context.Context, e *Elastic, indices string, mappings string) error {
+	err := checkMappings(ctx, e, indices, mappings)
+	if err != nil {
+		return err
+	}
+
+	analysis, err := hasNewsAnalysis(ctx, e, indices)
+	if err != nil {
+		return err
+	}
+	if !analysis {
+		_, err = e.TypedClient.Indices.PutSettings().Indices(indices).Reopen(true).Raw(strings.NewReader(`{"analysis": ` + newsAnalysis + `}`)).Do(ctx)
+		if err != nil {
+			return errors.Wrapf(err, "updating analysis settings of %s", indices)
+		}
+	}
+
+	_, err = e.TypedClient.Indices.PutMapping(indices).Raw(strings.NewReader(mappings)).Do(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "updating mapping of %s, it may need to be reindexed", indices)
+	}
+
+	return nil
+}
+
+// mappedField holds the parameters of a field mapping that can't be changed on an existing index
+type mappedField struct {
+	Type       string `json:"type"`
+	Analyzer   string `json:"analyzer"`
+	Normalizer string `json:"normalizer"`
+}
+
+// checkMappings returns ErrIncompatibleMapping if a field of mappings already exists in indices with another type,
+// analyzer or normalizer
+func checkMappings(ctx context.Context, e *Elastic, indices string, mappings string) error {
+	var expected struct {
+		Properties map[string]mappedField `json:"properties"`
+	}
+	err := json.Unmarshal([]byte(mappings), &expected)
+	if err != nil {
+		return errors.Wrap(err, "unmarshaling mappings")
+	}
+
+	res, err := e.TypedClient.Indices.GetMapping().Index(indices).Do(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "getting mapping of %s", indices)
+	}
+
+	var conflicts []string
+	for _, index := range slices.Sorted(maps.Keys(res)) {
+		for _, name := range slices.Sorted(maps.Keys(expected.Properties)) {
+			property, ok := res[index].Mappings.Properties[name]
+			if !ok {
+				continue
+			}
+			propertyJson, err := json.Marshal(property)
+			if err != nil {
+				return errors.Wrapf(err, "marshaling mapping of %s.%s", index, name)
+			}
+			var actual mappedField
+			err = json.Unmarshal(propertyJson, &actual)
+			if err != nil {
+				return errors.Wrapf(err, "unmarshaling mapping of %s.%s", index, name)
+			}
+
+			if actual != expected.Properties[name] {
+				conflicts = append(conflicts, fmt.Sprintf("%s.%s is %+v, expected %+v", index, name, actual, expected.Properties[name]))
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		return errors.Wrap(ErrIncompatibleMapping, strings.Join(conflicts, ", "))
+	}
+
+	return nil
+}
+
+// hasNewsAnalysis returns true if every index of indices has the analyzers and normalizers of newsAnalysis
+func hasNewsAnalysis(ctx context.Context, e *Elastic, indices string) (bool, error) {
+	var expected struct {
+		Analyzer   map[string]json.RawMessage `json:"analyzer"`
+		Normalizer map[string]json.RawMessage `json:"normalizer"`
+	}
+	err := json.Unmarshal([]byte(newsAnalysis), &expected)
+	if err != nil {
+		return false, errors.Wrap(err, "unmarshaling analysis")
+	}
+
+	res, err := e.TypedClient.Indices.GetSettings().Index(indices).Do(ctx)
+	if err != nil {
+		return false, errors.Wrapf(err, "getting settings of %s", indices)
+	}
+
+	for _, state := range res {
+		if state.Settings == nil || state.Settings.Index == nil || state.Settings.Index.Analysis == nil {
+			return false, nil
+		}
+		analysis := state.Settings.Index.Analysis
+		for name := range expected.Analyzer {
+			if _, ok := analysis.Analyzer[name]; !ok {
+				return false, nil
+			}
+		}
+		for name := range expected.Normalizer {
+			if _, ok := analysis.Normalizer[name]; !ok {
+				return false, nil
+			}
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,252 @@
+package nwelastic
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/refresh"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestNewsTemplate(t *testing.T) {
+	template, err := newsTemplate("news", 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var actual struct {
+		IndexPatterns []string `json:"index_patterns"`
+		Version       int      `json:"version"`
+		Template      struct {
+			Mappings struct {
+				Properties map[string]struct {
+					Type string `json:"type"`
+				} `json:"properties"`
+			} `json:"mappings"`
+		} `json:"template"`
+	}
+	if !assert.NoError(t, json.Unmarshal(template, &actual)) {
+		return
+	}
+
+	assert.Equal(t, []string{"news", "news-*", "news_history"}, actual.IndexPatterns)
+	assert.Equal(t, 1, actual.Version)
+
+	// Every field of News must be mapped
+	newsType := reflect.TypeOf(News{})
+	for i := range newsType.NumField() {
+		field := strings.Split(newsType.Field(i).Tag.Get("json"), ",")[0]
+		assert.Contains(t, actual.Template.Mappings.Properties, field)
+	}
+
+	for _, field := range []string{"id", "tickers", "source", "categoryCodes", "industryCodes", "regionCodes"} {
+		assert.Equal(t, "keyword", actual.Template.Mappings.Properties[field].Type, field)
+	}
+	for _, field := range []string{"publicationTime", "receivedTime", "creationTime"} {
+		assert.Equal(t, "date", actual.Template.Mappings.Properties[field].Type, field)
+	}
+}
+
+func TestPutAnalysisAndMappings(t *testing.T) {
+	const analysis = `{"nwelastic_tests": {"settings": {"index": {"analysis": {
+		"analyzer": {"news_text": {"type": "custom", "tokenizer": "standard"}},
+		"normalizer": {"uppercase": {"type": "custom", "filter": ["uppercase"]}}
+	}}}}}`
+
+	tests := []struct {
+		name             string
+		mapping          string
+		settings         string
+		expectedRequests []string
+		expectedErr      error
+	}{
+		{
+			name:             "analysis missing",
+			mapping:          `{"nwelastic_tests": {"mappings": {"properties": {"tickers": {"type": "keyword", "normalizer": "uppercase"}}}}}`,
+			settings:         `{"nwelastic_tests": {"settings": {"index": {}}}}`,
+			expectedRequests: []string{"GET /nwelastic_tests/_mapping", "GET /nwelastic_tests/_settings", "PUT /nwelastic_tests/_settings", "PUT /nwelastic_tests/_mapping"},
+		},
+		{
+			name:             "analysis present",
+			mapping:          `{"nwelastic_tests": {"mappings": {"properties": {"headline": {"type": "text", "analyzer": "news_text"}}}}}`,
+			settings:         analysis,
+			expectedRequests: []string{"GET /nwelastic_tests/_mapping", "GET /nwelastic_tests/_settings", "PUT /nwelastic_tests/_mapping"},
+		},
+		{
+			name:             "type changed",
+			mapping:          `{"nwelastic_tests": {"mappings": {"properties": {"tickers": {"type": "text"}}}}}`,
+			settings:         analysis,
+			expectedRequests: []string{"GET /nwelastic_tests/_mapping"},
+			expectedErr:      ErrIncompatibleMapping,
+		},
+		{
+			name:             "analyzer changed",
+			mapping:          `{"nwelastic_tests": {"mappings": {"properties": {"body": {"type": "text"}}}}}`,
+			settings:         analysis,
+			expectedRequests: []string{"GET /nwelastic_tests/_mapping"},
+			expectedErr:      ErrIncompatibleMapping,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var actualRequests []string
+			elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+				actualRequests = append(actualRequests, r.Method+" "+r.URL.Path)
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/nwelastic_tests/_mapping":
+					w.Write([]byte(tt.mapping))
+				case r.Method == http.MethodGet && r.URL.Path == "/nwelastic_tests/_settings":
+					w.Write([]byte(tt.settings))
+				default:
+					w.Write([]byte(`{"acknowledged": true}`))
+				}
+			})
+			if !assert.NoError(t, elastic.StartTypedClient()) {
+				return
+			}
+
+			err := putAnalysisAndMappings(context.Background(), &elastic, "nwelastic_tests", newsMappings)
+			assert.ErrorIs(t, err, tt.expectedErr)
+			assert.Equal(t, tt.expectedRequests, actualRequests)
+		})
+	}
+}
+
+func TestReindexNews(t *testing.T) {
+	var actualRequests []string
+	var actualAliases map[string]any
+	elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if strings.HasPrefix(path, "/nwelastic_tests_reindexed_") {
+			path = "/<dest>"
+		}
+		actualRequests = append(actualRequests, r.Method+" "+path)
+		switch {
+		case r.Method == http.MethodGet && path == "/nwelastic_tests":
+			w.Write([]byte(`{"nwelastic_tests": {}}`))
+		case path == "/_reindex":
+			w.Write([]byte(`{"total": 2, "created": 2, "failures": []}`))
+		case path == "/_aliases":
+			json.NewDecoder(r.Body).Decode(&actualAliases)
+			w.Write([]byte(`{"acknowledged": true}`))
+		default:
+			w.Write([]byte(`{"acknowledged": true, "index": "dest"}`))
+		}
+	})
+
+	err := ReindexNews(context.Background(), elastic)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []string{"GET /nwelastic_tests", "PUT /<dest>", "POST /_reindex", "POST /_aliases"}, actualRequests)
+	actions := actualAliases["actions"].([]any)
+	if assert.Len(t, actions, 2) {
+		add := actions[0].(map[string]any)["add"].(map[string]any)
+		assert.Equal(t, "nwelastic_tests", add["alias"])
+		assert.True(t, strings.HasPrefix(add["index"].(string), "nwelastic_tests_reindexed_"))
+		assert.Equal(t, map[string]any{"remove_index": map[string]any{"index": "nwelastic_tests"}}, actions[1])
+	}
+}
+
+func TestReindexNews_partitioned(t *testing.T) {
+	elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"nwelastic_tests-2024.01": {}, "nwelastic_tests-2024.02": {}}`))
+	})
+
+	assert.ErrorIs(t, ReindexNews(context.Background(), elastic), ErrReindexPartitioned)
+}
+
+// mappingSuite performs integration tests
+type mappingSuite struct {
+	suite.Suite
+	elastic Elastic
+}
+
+func (m *mappingSuite) SetupTest() {
+	config := TestElasticConfig
+	config.NewsIndex = "nwelastic_tests_mapping_" + strconv.Itoa(rand.Int())
+	m.elastic = NewElastic(config)
+	if err := m.elastic.StartTypedClient(); err != nil {
+		m.FailNow(err.Error())
+	}
+}
+
+func (m *mappingSuite) TearDownTest() {
+	index := m.elastic.Config.NewsIndex
+	_, _ = m.elastic.TypedClient.Indices.Delete(index).Do(context.Background())
+	_, _ = m.elastic.TypedClient.Indices.Delete(index + "_reindexed_*").Do(context.Background())
+	_, _ = m.elastic.TypedClient.Indices.Delete(migrationsIndex(index)).Do(context.Background())
+	_, _ = m.elastic.TypedClient.Indices.DeleteIndexTemplate(index).Do(context.Background())
+	_, _ = m.elastic.TypedClient.Indices.DeleteIndexTemplate(bodyChunksIndex(index)).Do(context.Background())
+}
+
+func (m *mappingSuite) TestEnsureNewsIndex() {
+	tests := []struct {
+		name          string
+		existingIndex bool
+	}{
+		{"new index", false},
+		{"existing index", true},
+	}
+	for _, tt := range tests {
+		m.Run(tt.name, func() {
+			ctx := context.Background()
+			index := m.elastic.Config.NewsIndex
+			m.TearDownTest()
+			if tt.existingIndex {
+				_, err := m.elastic.TypedClient.Indices.Create(index).Do(ctx)
+				m.Require().NoError(err)
+			}
+
+			m.ErrorIs(VerifyNewsIndex(ctx, m.elastic), ErrSchemaOutdated)
+
+			m.Require().NoError(EnsureNewsIndex(ctx, m.elastic))
+			// Applied migrations are skipped
+			m.Require().NoError(EnsureNewsIndex(ctx, m.elastic))
+			m.NoError(VerifyNewsIndex(ctx, m.elastic))
+
+			res, err := m.elastic.TypedClient.Indices.GetMapping().Index(index).Do(ctx)
+			m.Require().NoError(err)
+			tickers, ok := res[index].Mappings.Properties["tickers"]
+			m.Require().True(ok)
+			m.IsType(&types.KeywordProperty{}, tickers)
+		})
+	}
+}
+
+func (m *mappingSuite) TestReindexNews() {
+	ctx := context.Background()
+	index := m.elastic.Config.NewsIndex
+	_, err := m.elastic.TypedClient.Indices.Create(index).Raw(strings.NewReader(`{"mappings": {"properties": {"tickers": {"type": "text"}}}}`)).Do(ctx)
+	m.Require().NoError(err)
+	_, err = m.elastic.TypedClient.Index(index).Id("1").Request(News{Id: "1", Tickers: []string{"aapl"}}).Refresh(refresh.True).Do(ctx)
+	m.Require().NoError(err)
+
+	m.ErrorIs(EnsureNewsIndex(ctx, m.elastic), ErrIncompatibleMapping)
+
+	m.Require().NoError(ReindexNews(ctx, m.elastic))
+	m.Require().NoError(EnsureNewsIndex(ctx, m.elastic))
+
+	res, err := m.elastic.TypedClient.Count().Index(index).Query(&types.Query{Term: map[string]types.TermQuery{"tickers": {Value: "AAPL"}}}).Do(ctx)
+	m.Require().NoError(err)
+	m.Equal(int64(1), res.Count)
+}
+
+func TestMappingSuite(t *testing.T) {
+	integration := os.Getenv("INTEGRATION")
+	if integration == "" {
+		t.Skip("skipping: set INTEGRATION env to run this test")
+	}
+
+	suite.Run(t, new(mappingSuite))
+}
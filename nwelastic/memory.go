@@ -0,0 +1,436 @@
+package nwelastic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/pkg/errors"
+)
+
+// MemoryRepository is a Repository keeping news in memory, for tests of code storing news without an Elasticsearch
+// cluster. It follows the id policy, CreateOnly and the version policy of its options, and filters, sorts and pages
+// searches like NewsRepository. NewsFilter.Query matches news having any of its words in the headline or the body,
+// case-insensitively, a match in the headline scores twice. The other options, such as partitions, body strategies and
+// history, are ignored.
+type MemoryRepository struct {
+	// policies applies the options shared with NewsRepository
+	policies NewsRepository
+	mu       sync.RWMutex
+	news     map[string]*News
+	// versions holds the version of each stored news with a version policy
+	versions map[string]int64
+	// lastId is incremented to generate the ids of news without one
+	lastId int
+}
+
+// NewMemoryRepository creates an empty MemoryRepository
+func NewMemoryRepository(opts ...NewsRepositoryOpts) (*MemoryRepository, error) {
+	var o NewsRepositoryOpts
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	err := validateIdPolicy(o.IdPolicy)
+	if err != nil {
+		return nil, err
+	}
+	err = validateVersionPolicy(o)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MemoryRepository{
+		policies: NewsRepository{opts: o},
+		news:     map[string]*News{},
+		versions: map[string]int64{},
+	}, nil
+}
+
+// Insert stores a copy of news. With CreateOnly, ErrAlreadyExists is returned if a news with the same id exists. With a
+// version policy, ErrVersionConflict is returned if a newer version of the news exists.
+func (m *MemoryRepository) Insert(news *News) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.insert(news)
+	return err
+}
+
+// InsertNews stores a copy of news.
+//
+// Deprecated: use Insert
+func (m *MemoryRepository) InsertNews(news News) error {
+	return m.Insert(&news)
+}
+
+// Upsert is like Insert, created is false if news replaced a news with the same id
+func (m *MemoryRepository) Upsert(ctx context.Context, news *News) (created bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insert(news)
+}
+
+// InsertBatch stores copies of news, the insertedCallback is called once with the amount of news stored and the index
+// of the last news. News that can't be stored are reported in a *BulkError, the rest of the batch is stored.
+func (m *MemoryRepository) InsertBatch(news []*News, insertedCallback func(totalIndexed int, lastIndex int)) error {
+	if len(news) == 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	var failures []BulkFailure
+	for i, newsItem := range news {
+		_, err := m.insert(newsItem)
+		if err != nil {
+			failures = append(failures, memoryBulkFailure(i, newsItem, err))
+		}
+	}
+	m.mu.Unlock()
+
+	if insertedCallback != nil {
+		insertedCallback(len(news)-len(failures), len(news)-1)
+	}
+	if len(failures) > 0 {
+		return &BulkError{Failures: failures}
+	}
+	return nil
+}
+
+// memoryBulkFailure reports err, returned by insert for the news at index of a batch, with the status and type
+// Elasticsearch would answer
+func memoryBulkFailure(index int, news *News, err error) BulkFailure {
+	failure := BulkFailure{Id: news.Id, Index: index, Reason: err.Error()}
+	for _, conflictErr := range []error{ErrAlreadyExists, ErrVersionConflict} {
+		if errors.Is(err, conflictErr) {
+			failure.Status = http.StatusConflict
+			failure.Type = "version_conflict_engine_exception"
+			failure.Err = conflictErr
+			return failure
+		}
+	}
+
+	failure.Status = http.StatusInternalServerError
+	failure.Type = "exception"
+	return failure
+}
+
+// insert stores news, m.mu must be locked
+func (m *MemoryRepository) insert(news *News) (created bool, err error) {
+	news.CreationTime = time.Now()
+	m.policies.ensureId(news)
+
+	id := news.Id
+	if id == "" {
+		// Like Elasticsearch, the generated id isn't set in the news
+		m.lastId++
+		id = "memory-" + strconv.Itoa(m.lastId)
+	}
+
+	_, exists := m.news[id]
+	if exists && m.policies.opts.CreateOnly {
+		return false, errors.Wrapf(ErrAlreadyExists, "news %s", id)
+	}
+	if m.policies.opts.VersionPolicy != VersionPolicyNone {
+		version := m.policies.newsVersion(news)
+		if exists && version < m.versions[id] {
+			return false, errors.Wrapf(ErrVersionConflict, "news %s", id)
+		}
+		m.versions[id] = version
+	}
+
+	m.news[id] = cloneNews(news)
+	return !exists, nil
+}
+
+// GetById returns a copy of the news with id, or ErrNewsNotFound
+func (m *MemoryRepository) GetById(ctx context.Context, id string) (*News, error) {
+	news, _, err := m.GetMany(ctx, []string{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(news) == 0 {
+		return nil, errors.Wrap(ErrNewsNotFound, id)
+	}
+
+	return news[0], nil
+}
+
+// GetMany returns copies of the news with ids in the order of ids, and the ids that weren't found
+func (m *MemoryRepository) GetMany(ctx context.Context, ids []string) (news []*News, missing []string, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, id := range ids {
+		newsItem, ok := m.news[id]
+		if !ok {
+			missing = append(missing, id)
+			continue
+		}
+		news = append(news, cloneNews(newsItem))
+	}
+
+	return news, missing, nil
+}
+
+// Exists returns true if a news with id exists
+func (m *MemoryRepository) Exists(ctx context.Context, id string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.news[id]
+	return ok, nil
+}
+
+// Delete deletes the news with id, ErrNewsNotFound is returned if it doesn't exist
+func (m *MemoryRepository) Delete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.news[id]; !ok {
+		return errors.Wrap(ErrNewsNotFound, id)
+	}
+	delete(m.news, id)
+	delete(m.versions, id)
+	return nil
+}
+
+// Update sets fields, the JSON fields of News, on the news with id. It returns false if the news already had these
+// values, and ErrNewsNotFound if it doesn't exist.
+func (m *MemoryRepository) Update(ctx context.Context, id string, fields map[string]any) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	news, ok := m.news[id]
+	if !ok {
+		return false, errors.Wrap(ErrNewsNotFound, id)
+	}
+
+	newsJson, err := json.Marshal(news)
+	if err != nil {
+		return false, errors.Wrap(err, "marshaling news")
+	}
+	var document map[string]any
+	err = json.Unmarshal(newsJson, &document)
+	if err != nil {
+		return false, errors.Wrap(err, "unmarshaling news")
+	}
+	maps.Copy(document, fields)
+	documentJson, err := json.Marshal(document)
+	if err != nil {
+		return false, errors.Wrap(err, "marshaling fields")
+	}
+	updated := &News{}
+	err = json.Unmarshal(documentJson, updated)
+	if err != nil {
+		return false, errors.Wrap(err, "unmarshaling fields")
+	}
+
+	updatedJson, err := json.Marshal(updated)
+	if err != nil {
+		return false, errors.Wrap(err, "marshaling news")
+	}
+	if bytes.Equal(newsJson, updatedJson) {
+		return false, nil
+	}
+	m.news[id] = updated
+	return true, nil
+}
+
+// memoryHit is a news matching a search with its sort values
+type memoryHit struct {
+	id    string
+	news  *News
+	value float64
+}
+
+// Search returns a page of the news matching filter, sorted by filter.Sort with the id as tiebreaker. Like
+// NewsRepository.Search, the first page has a cursor only with filter.Paginate.
+func (m *MemoryRepository) Search(ctx context.Context, filter NewsFilter) (SearchResult, error) {
+	req, err := newsSearchRequest(filter)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	after, err := memoryCursor(req.SearchAfter)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	desc := filter.Order != SortAsc
+
+	m.mu.RLock()
+	var hits []memoryHit
+	for id, news := range m.news {
+		score, ok := matchNews(news, filter)
+		if !ok {
+			continue
+		}
+
+		hit := memoryHit{id: id, news: news, value: score}
+		switch filter.Sort {
+		case "", SortPublicationTime:
+			hit.value = float64(news.PublicationTime.UnixMilli())
+		case SortReceivedTime:
+			hit.value = float64(news.ReceivedTime.UnixMilli())
+		}
+		hits = append(hits, hit)
+	}
+	m.mu.RUnlock()
+
+	slices.SortFunc(hits, func(a, b memoryHit) int {
+		if desc {
+			return compareHits(b, a)
+		}
+		return compareHits(a, b)
+	})
+
+	result := SearchResult{News: []News{}, Total: int64(len(hits))}
+	if after != nil {
+		start, _ := slices.BinarySearchFunc(hits, *after, func(hit memoryHit, after memoryHit) int {
+			c := compareHits(hit, after)
+			if desc {
+				c = -c
+			}
+			// Hits equal to the cursor were on the previous page
+			if c == 0 {
+				return -1
+			}
+			return c
+		})
+		hits = hits[start:]
+	}
+
+	size := *req.Size
+	for _, hit := range hits[:min(size, len(hits))] {
+		news := *cloneNews(hit.news)
+		// Like NewsRepository.Search, news inserted without id get the generated id
+		news.Id = hit.id
+		result.News = append(result.News, news)
+	}
+	if paginated(filter) && len(hits) >= size {
+		last := hits[size-1]
+		result.Cursor, err = encodeCursor("", []types.FieldValue{last.value, last.id})
+		if err != nil {
+			return SearchResult{}, err
+		}
+	}
+
+	return result, nil
+}
+
+func compareHits(a, b memoryHit) int {
+	if a.value != b.value {
+		if a.value < b.value {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a.id, b.id)
+}
+
+// memoryCursor returns the sort values of a cursor returned by MemoryRepository.Search, nil if it's empty
+func memoryCursor(sortValues []types.FieldValue) (*memoryHit, error) {
+	if sortValues == nil {
+		return nil, nil
+	}
+	if len(sortValues) != 2 {
+		return nil, ErrInvalidCursor
+	}
+
+	number, ok := sortValues[0].(json.Number)
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	value, err := number.Float64()
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	id, ok := sortValues[1].(string)
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+
+	return &memoryHit{id: id, value: value}, nil
+}
+
+// matchNews returns true if news matches filter, and its score for filter.Query
+func matchNews(news *News, filter NewsFilter) (float64, bool) {
+	// Tickers are compared in uppercase, like the normalizer of the tickers field
+	if !matchAny(upperAll(news.Tickers), upperAll(filter.Tickers)) ||
+		!matchAny([]string{news.Source}, filter.Sources) ||
+		!matchAny(news.Ciks, filter.Ciks) ||
+		!matchAny(news.CategoryCodes, filter.CategoryCodes) ||
+		!matchAny(news.IndustryCodes, filter.IndustryCodes) ||
+		!matchAny(news.RegionCodes, filter.RegionCodes) {
+		return 0, false
+	}
+	if !filter.From.IsZero() && news.PublicationTime.Before(filter.From) {
+		return 0, false
+	}
+	if !filter.To.IsZero() && !news.PublicationTime.Before(filter.To) {
+		return 0, false
+	}
+
+	if filter.Query == "" {
+		return 1, true
+	}
+	headline, body := queryWords(news.Headline), queryWords(news.Body)
+	var score float64
+	for _, word := range queryWords(filter.Query) {
+		if slices.Contains(headline, word) {
+			score += 2
+		}
+		if slices.Contains(body, word) {
+			score++
+		}
+	}
+	return score, score > 0
+}
+
+// matchAny returns true if filter is empty or values has any of its values
+func matchAny[T comparable](values []T, filter []T) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if slices.Contains(filter, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// upperAll returns values in uppercase
+func upperAll(values []string) []string {
+	upper := make([]string, len(values))
+	for i, value := range values {
+		upper[i] = strings.ToUpper(value)
+	}
+	return upper
+}
+
+// queryWords splits text in lowercase words
+func queryWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// cloneNews copies news so the stored news isn't shared with callers
+func cloneNews(news *News) *News {
+	clone := *news
+	clone.Tickers = slices.Clone(news.Tickers)
+	clone.CategoryCodes = slices.Clone(news.CategoryCodes)
+	clone.IndustryCodes = slices.Clone(news.IndustryCodes)
+	clone.RegionCodes = slices.Clone(news.RegionCodes)
+	clone.Ciks = slices.Clone(news.Ciks)
+	return &clone
+}
@@ -0,0 +1,288 @@
+package nwelastic
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryRepository_Insert(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        NewsRepositoryOpts
+		news        []*News
+		expectedErr error
+		expected    *News
+	}{
+		{
+			"overwrites",
+			NewsRepositoryOpts{},
+			[]*News{{Id: "1", Headline: "first"}, {Id: "1", Headline: "second"}},
+			nil,
+			&News{Id: "1", Headline: "second"},
+		},
+		{
+			"create only",
+			NewsRepositoryOpts{CreateOnly: true},
+			[]*News{{Id: "1", Headline: "first"}, {Id: "1", Headline: "second"}},
+			ErrAlreadyExists,
+			&News{Id: "1", Headline: "first"},
+		},
+		{
+			"older revision",
+			NewsRepositoryOpts{IdPolicy: IdPolicyProvider, VersionPolicy: VersionPolicyRevision},
+			[]*News{{Id: "1", Headline: "first", Revision: 2}, {Id: "1", Headline: "second", Revision: 1}},
+			ErrVersionConflict,
+			&News{Id: "1", Headline: "first", Revision: 2},
+		},
+		{
+			"same revision",
+			NewsRepositoryOpts{IdPolicy: IdPolicyProvider, VersionPolicy: VersionPolicyRevision},
+			[]*News{{Id: "1", Headline: "first", Revision: 2}, {Id: "1", Headline: "second", Revision: 2}},
+			nil,
+			&News{Id: "1", Headline: "second", Revision: 2},
+		},
+		{
+			"provider id policy",
+			NewsRepositoryOpts{IdPolicy: IdPolicyProvider},
+			[]*News{{Source: "source", ProviderId: "a"}},
+			nil,
+			&News{Id: hashId("source", "a"), Source: "source", ProviderId: "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository, err := NewMemoryRepository(tt.opts)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			for _, news := range tt.news {
+				err = repository.Insert(news)
+			}
+			assert.ErrorIs(t, err, tt.expectedErr)
+
+			actual, err := repository.GetById(context.Background(), tt.expected.Id)
+			if !assert.NoError(t, err) {
+				return
+			}
+			actual.CreationTime = time.Time{}
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
+func TestMemoryRepository_InsertBatch(t *testing.T) {
+	repository, err := NewMemoryRepository(NewsRepositoryOpts{CreateOnly: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	totalIndexed, lastIndex := 0, 0
+	err = repository.InsertBatch([]*News{{Id: "1"}, {Id: "2"}, {Id: "1"}, {}}, func(indexed int, last int) {
+		totalIndexed, lastIndex = indexed, last
+	})
+
+	var bulkErr *BulkError
+	if assert.ErrorAs(t, err, &bulkErr) && assert.Len(t, bulkErr.Failures, 1) {
+		assert.Equal(t, "1", bulkErr.Failures[0].Id)
+		assert.Equal(t, 2, bulkErr.Failures[0].Index)
+		assert.True(t, bulkErr.Failures[0].AlreadyExists())
+	}
+	assert.Equal(t, 3, totalIndexed)
+	assert.Equal(t, 3, lastIndex)
+
+	result, err := repository.Search(context.Background(), NewsFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), result.Total)
+}
+
+func TestMemoryRepository_InsertNews(t *testing.T) {
+	repository, err := NewMemoryRepository()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, repository.InsertNews(News{Headline: "without id"}))
+
+	// Like Elasticsearch, search returns the generated id
+	result, err := repository.Search(context.Background(), NewsFilter{})
+	if assert.NoError(t, err) && assert.Len(t, result.News, 1) {
+		assert.Equal(t, "memory-1", result.News[0].Id)
+		assert.Equal(t, "without id", result.News[0].Headline)
+	}
+}
+
+func TestMemoryRepository_InsertBatch_versionConflict(t *testing.T) {
+	repository, err := NewMemoryRepository(NewsRepositoryOpts{IdPolicy: IdPolicyProvider, VersionPolicy: VersionPolicyRevision})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = repository.InsertBatch([]*News{{Id: "1", Revision: 2}, {Id: "1", Revision: 1}}, nil)
+
+	var bulkErr *BulkError
+	if assert.ErrorAs(t, err, &bulkErr) && assert.Len(t, bulkErr.Failures, 1) {
+		assert.Equal(t, 1, bulkErr.Failures[0].Index)
+		assert.Equal(t, http.StatusConflict, bulkErr.Failures[0].Status)
+		assert.True(t, bulkErr.Failures[0].VersionConflict())
+		assert.False(t, bulkErr.Failures[0].AlreadyExists())
+	}
+}
+
+func TestMemoryRepository_GetMany(t *testing.T) {
+	repository, err := NewMemoryRepository()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, repository.InsertBatch([]*News{{Id: "1", Tickers: []string{"AAPL"}}, {Id: "2"}}, nil))
+
+	news, missing, err := repository.GetMany(context.Background(), []string{"2", "3", "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2", "1"}, newsIds(derefNews(news)))
+	assert.Equal(t, []string{"3"}, missing)
+
+	// The stored news isn't shared with callers
+	news[1].Tickers[0] = "MSFT"
+	stored, err := repository.GetById(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AAPL"}, stored.Tickers)
+}
+
+func TestMemoryRepository_Delete(t *testing.T) {
+	repository, err := NewMemoryRepository()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, repository.Insert(&News{Id: "1"}))
+
+	assert.NoError(t, repository.Delete(context.Background(), "1"))
+	exists, err := repository.Exists(context.Background(), "1")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	assert.ErrorIs(t, repository.Delete(context.Background(), "1"), ErrNewsNotFound)
+	_, err = repository.GetById(context.Background(), "1")
+	assert.ErrorIs(t, err, ErrNewsNotFound)
+}
+
+func TestMemoryRepository_Search(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	news := []*News{
+		{Id: "1", Headline: "Apple earnings", Source: "a", Tickers: []string{"AAPL"}, PublicationTime: start, ReceivedTime: start.Add(4 * time.Hour)},
+		{Id: "2", Headline: "Microsoft deal", Body: "apple", Source: "b", Tickers: []string{"msft"}, PublicationTime: start.Add(time.Hour), ReceivedTime: start.Add(3 * time.Hour)},
+		{Id: "3", Headline: "Markets", Source: "a", Ciks: []int{320193}, PublicationTime: start.Add(2 * time.Hour), ReceivedTime: start.Add(2 * time.Hour)},
+		{Id: "4", Headline: "Apple and Microsoft", Source: "b", Tickers: []string{"AAPL", "MSFT"}, PublicationTime: start.Add(2 * time.Hour), ReceivedTime: start},
+	}
+
+	tests := []struct {
+		name        string
+		filter      NewsFilter
+		expectedIds []string
+		expectedErr error
+	}{
+		{"default sort", NewsFilter{}, []string{"4", "3", "2", "1"}, nil},
+		{"ascending", NewsFilter{Order: SortAsc}, []string{"1", "2", "3", "4"}, nil},
+		{"received time", NewsFilter{Sort: SortReceivedTime}, []string{"1", "2", "3", "4"}, nil},
+		{"tickers", NewsFilter{Tickers: []string{"AAPL"}}, []string{"4", "1"}, nil},
+		{"tickers in lowercase", NewsFilter{Tickers: []string{"aapl"}}, []string{"4", "1"}, nil},
+		{"tickers and sources", NewsFilter{Tickers: []string{"MSFT"}, Sources: []string{"b"}, Order: SortAsc}, []string{"2", "4"}, nil},
+		{"ciks", NewsFilter{Ciks: []int{320193}}, []string{"3"}, nil},
+		{"time range", NewsFilter{From: start.Add(time.Hour), To: start.Add(2 * time.Hour)}, []string{"2"}, nil},
+		{"query", NewsFilter{Query: "APPLE", Sort: SortRelevance}, []string{"4", "1", "2"}, nil},
+		{"invalid sort", NewsFilter{Sort: "headline"}, nil, ErrInvalidSort},
+		{"invalid cursor", NewsFilter{Cursor: "invalid"}, nil, ErrInvalidCursor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository, err := NewMemoryRepository()
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.NoError(t, repository.InsertBatch(news, nil))
+
+			result, err := repository.Search(context.Background(), tt.filter)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedIds, newsIds(result.News))
+			assert.Equal(t, int64(len(tt.expectedIds)), result.Total)
+		})
+	}
+
+	t.Run("pages", func(t *testing.T) {
+		repository, err := NewMemoryRepository()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NoError(t, repository.InsertBatch(news, nil))
+
+		var ids []string
+		filter := NewsFilter{Size: 3, Paginate: true}
+		for {
+			result, err := repository.Search(context.Background(), filter)
+			if !assert.NoError(t, err) {
+				return
+			}
+			ids = append(ids, newsIds(result.News)...)
+			if result.Cursor == "" {
+				break
+			}
+			filter.Cursor = result.Cursor
+		}
+		assert.Equal(t, []string{"4", "3", "2", "1"}, ids)
+	})
+}
+
+func derefNews(news []*News) []News {
+	values := make([]News, len(news))
+	for i, newsItem := range news {
+		values[i] = *newsItem
+	}
+	return values
+}
+
+func TestMemoryRepository_Upsert(t *testing.T) {
+	repository, err := NewMemoryRepository()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	created, err := repository.Upsert(context.Background(), &News{Id: "1", Headline: "first"})
+	assert.NoError(t, err)
+	assert.True(t, created)
+	created, err = repository.Upsert(context.Background(), &News{Id: "1", Headline: "corrected"})
+	assert.NoError(t, err)
+	assert.False(t, created)
+
+	news, err := repository.GetById(context.Background(), "1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "corrected", news.Headline)
+	}
+}
+
+func TestMemoryRepository_Update(t *testing.T) {
+	repository, err := NewMemoryRepository()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, repository.Insert(&News{Id: "1", Headline: "first", Tickers: []string{"AAPL"}}))
+
+	updated, err := repository.Update(context.Background(), "1", map[string]any{"headline": "corrected"})
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	updated, err = repository.Update(context.Background(), "1", map[string]any{"headline": "corrected"})
+	assert.NoError(t, err)
+	assert.False(t, updated)
+	_, err = repository.Update(context.Background(), "2", map[string]any{"headline": "corrected"})
+	assert.ErrorIs(t, err, ErrNewsNotFound)
+
+	news, err := repository.GetById(context.Background(), "1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "corrected", news.Headline)
+		assert.Equal(t, []string{"AAPL"}, news.Tickers)
+	}
+}
@@ -8,7 +8,9 @@ import (
 
 // News describes a document that can be inserted to ElasticSearch. Each field is commented with the sources it applies to.
 type News struct {
-	Id              string    `json:"id,omitempty"`
+	Id string `json:"id,omitempty"`
+	// ProviderId is the id given to the news by its provider, used by IdPolicyProvider
+	ProviderId      string    `json:"providerId,omitempty"`
 	Headline        string    `json:"headline"`
 	Body            string    `json:"body,omitempty"`
 	Tickers         []string  `json:"tickers,omitempty"`
@@ -28,6 +30,18 @@ type News struct {
 	Ciks []int `json:"ciks,omitempty"`
 	// Link only applies to SEC
 	Link string `json:"link,omitempty"`
+	// Revision is the revision given to the news by its provider, used by VersionPolicyRevision
+	Revision int64 `json:"revision,omitempty"`
+
+	// BodyTruncated is set if Body isn't the full body, BodyStorage tells where the rest is. Set by insert functions
+	// when NewsRepositoryOpts.BodyStrategy shortens the body.
+	BodyTruncated bool `json:"bodyTruncated,omitempty"`
+	// BodyLength is the length in bytes of the full body of a truncated news
+	BodyLength int `json:"bodyLength,omitempty"`
+	// BodyStorage is BodyStorageTruncated or BodyStorageChunks if the body was shortened, empty otherwise
+	BodyStorage string `json:"bodyStorage,omitempty"`
+	// BodyChunks is the number of chunks of a news stored with BodyStorageChunks
+	BodyChunks int `json:"bodyChunks,omitempty"`
 }
 
 func (n *News) UnmarshalJSON(data []byte) error {
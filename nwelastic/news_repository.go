@@ -1,13 +1,18 @@
 package nwelastic
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"flag"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
 	"time"
 
-	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/optype"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/result"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/versiontype"
 	"github.com/pkg/errors"
 )
 
@@ -16,17 +21,82 @@ var (
 	maxQuerySize int = 90e6
 )
 
+const (
+	defaultBulkRetries      = 3
+	defaultBulkRetryBackoff = 500 * time.Millisecond
+	defaultMaxBulkDocs      = 10000
+)
+
+// Repository stores news, it is implemented by NewsRepository and by MemoryRepository for tests
 type Repository interface {
-	InsertNews(News) error
+	// Deprecated: use Insert
+	InsertNews(news News) error
+	Insert(news *News) error
+	Upsert(ctx context.Context, news *News) (created bool, err error)
+	InsertBatch(news []*News, insertedCallback func(totalIndexed int, lastIndex int)) error
+	GetById(ctx context.Context, id string) (*News, error)
+	GetMany(ctx context.Context, ids []string) (news []*News, missing []string, err error)
+	Exists(ctx context.Context, id string) (bool, error)
+	Search(ctx context.Context, filter NewsFilter) (SearchResult, error)
+	Delete(ctx context.Context, id string) error
+	Update(ctx context.Context, id string, fields map[string]any) (bool, error)
 }
 
+var (
+	_ Repository = NewsRepository{}
+	_ Repository = &MemoryRepository{}
+)
+
 type NewsRepository struct {
 	elastic Elastic
 	Index   string // Defaults to "news"
+	opts    NewsRepositoryOpts
+}
+
+type NewsRepositoryOpts struct {
+	// BulkRetries is the number of times a news rejected for a transient reason, such as a 429, is sent again by
+	// InsertBatch. Defaults to 3, a negative value disables retries.
+	BulkRetries int `yaml:"bulkRetries"`
+	// BulkRetryBackoff is the wait before the first retry, it doubles on each retry. Defaults to 500ms.
+	BulkRetryBackoff time.Duration `yaml:"bulkRetryBackoff"`
+	// OnBulkStats, if set, is called at the end of each InsertBatch call
+	OnBulkStats func(BulkStats) `yaml:"-"`
+	// MaxBulkBytes is the maximum size of a bulk request body, news that are bigger once serialized are rejected.
+	// Defaults to 90MB, the maximum HTTP request size is 100MB in ElasticSearch.
+	MaxBulkBytes int `yaml:"maxBulkBytes"`
+	// MaxBulkDocs is the maximum number of news in a bulk request, defaults to 10000
+	MaxBulkDocs int `yaml:"maxBulkDocs"`
+	// FlushConcurrency is the number of bulk requests InsertBatch sends at the same time, defaults to 1
+	FlushConcurrency int `yaml:"flushConcurrency"`
+	// IdPolicy derives the id of news without one, IdPolicyNone, IdPolicyProvider or IdPolicyContent. Defaults to
+	// IdPolicyNone.
+	IdPolicy string `yaml:"idPolicy"`
+	// CreateOnly doesn't overwrite news that already exist, Insert returns ErrAlreadyExists and InsertBatch reports
+	// them as failures with a 409 status
+	CreateOnly bool `yaml:"createOnly"`
+	// VersionPolicy is VersionPolicyNone, VersionPolicyReceivedTime or VersionPolicyRevision. With a version policy, a
+	// news older than the stored one isn't written, Insert returns ErrVersionConflict and InsertBatch reports it as a
+	// failure with a 409 status. It requires an IdPolicy and can't be combined with CreateOnly.
+	VersionPolicy string `yaml:"versionPolicy"`
+	// KeepHistory copies every version written to the history index, "<index>_history". It requires a version policy.
+	KeepHistory bool `yaml:"keepHistory"`
+	// Partitioning is PartitionNone or PartitionMonthly, it must match NewsIndexOpts.Partitioning. With
+	// PartitionMonthly, news are written to the partition of their publication time, or through the write alias if it's
+	// zero, and read through the read alias. A news that already exists is written again to the partition holding it,
+	// even if its publication time changed to another month, so it isn't duplicated. Defaults to PartitionNone.
+	Partitioning string `yaml:"partitioning"`
+	// BodyStrategy is BodyReject, BodyTruncate or BodySplit, it handles bodies longer than MaxBodyBytes. Defaults to
+	// BodyReject, which only rejects news bigger than MaxBulkBytes.
+	BodyStrategy string `yaml:"bodyStrategy"`
+	// MaxBodyBytes is the maximum length of a body kept in the news with BodyTruncate and BodySplit, and the size of
+	// the chunks with BodySplit. Defaults to half of MaxBulkBytes, up to 1MB.
+	MaxBodyBytes int `yaml:"maxBodyBytes"`
+	// SearchKeepAlive is how long the point in time of a Search is kept between two pages, defaults to 1m
+	SearchKeepAlive time.Duration `yaml:"searchKeepAlive"`
 }
 
 // NewNewsRepository creates a NewsRepository, if the context is a test, an index other than "news" must be passed otherwise it will fail.
-func NewNewsRepository(elastic Elastic) (NewsRepository, error) {
+func NewNewsRepository(elastic Elastic, opts ...NewsRepositoryOpts) (NewsRepository, error) {
 	if flag.Lookup("test.v") != nil && elastic.Config.NewsIndex == "news" {
 		return NewsRepository{}, errors.New("can't use index 'news' for tests")
 	}
@@ -41,86 +111,320 @@ func NewNewsRepository(elastic Elastic) (NewsRepository, error) {
 		return NewsRepository{}, err
 	}
 
+	var o NewsRepositoryOpts
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	err = validateIdPolicy(o.IdPolicy)
+	if err != nil {
+		return NewsRepository{}, err
+	}
+	err = validateVersionPolicy(o)
+	if err != nil {
+		return NewsRepository{}, err
+	}
+	err = validatePartitioning(o.Partitioning)
+	if err != nil {
+		return NewsRepository{}, err
+	}
+	err = validateBodyStrategy(o.BodyStrategy)
+	if err != nil {
+		return NewsRepository{}, err
+	}
+	if o.BulkRetries == 0 {
+		o.BulkRetries = defaultBulkRetries
+	}
+	if o.BulkRetries < 0 {
+		o.BulkRetries = 0
+	}
+	if o.BulkRetryBackoff <= 0 {
+		o.BulkRetryBackoff = defaultBulkRetryBackoff
+	}
+	if o.MaxBulkDocs <= 0 {
+		o.MaxBulkDocs = defaultMaxBulkDocs
+	}
+	if o.FlushConcurrency <= 0 {
+		o.FlushConcurrency = 1
+	}
+
 	return NewsRepository{
 		Index:   elastic.Config.NewsIndex,
 		elastic: elastic,
+		opts:    o,
 	}, nil
 }
 
-// InsertBatch inserts a batch of news, if the batch is too big, it is uploaded in sub-batches.
-// news must be ordered from [oldest... newest]. The insertedCallback is called after a sub-batch is inserted
-// it sends as arguments the total amount of news in the sub-batch and the batch index of the last item in the
-// sub-batch.
+// InsertBatch inserts a batch of news, if the batch is too big, it is uploaded in sub-batches of at most MaxBulkBytes
+// serialized bytes and MaxBulkDocs news, up to FlushConcurrency sub-batches are sent at the same time.
+// news must be ordered from [oldest... newest]. The insertedCallback is called in order after each sub-batch is
+// inserted, it sends as arguments the amount of news indexed in the sub-batch and the batch index of the last item in
+// the sub-batch.
+//
+// News rejected for a transient reason are retried. If some news still couldn't be indexed, or are bigger than
+// MaxBulkBytes, the rest of the batch is inserted and a *BulkError listing them is returned.
 func (b NewsRepository) InsertBatch(news []*News, insertedCallback func(totalIndexed int, lastIndex int)) error {
+	return b.insertBatch(news, false, insertedCallback)
+}
+
+// insertBatch is InsertBatch, keepCreationTime keeps the creation time of news that have one, for news restored by
+// Import
+func (b NewsRepository) insertBatch(news []*News, keepCreationTime bool, insertedCallback func(totalIndexed int, lastIndex int)) error {
 	if len(news) == 0 {
 		return nil
 	}
 
-	fromIndex := 0
-	for {
-		toIndex := fromIndex
-		bodySizes := 0
-		creationTime := time.Now()
+	stats := BulkStats{Added: uint64(len(news))}
+	var failures []BulkFailure
+	if b.opts.OnBulkStats != nil {
+		start := time.Now()
+		defer func() {
+			stats.Failed = uint64(len(failures))
+			stats.Duration = time.Since(start)
+			b.opts.OnBulkStats(stats)
+		}()
+	}
 
-		for _, newsItem := range news[fromIndex:] {
-			toIndex += 1
-			newsItem.CreationTime = creationTime
-			if len(newsItem.Body) > maxQuerySize {
-				newsItem.Body = ""
-			}
+	subBatches, err := b.planSubBatches(news, keepCreationTime)
+	if err != nil {
+		return err
+	}
+
+	var statsMutex sync.Mutex
+	for window := range slices.Chunk(subBatches, b.opts.FlushConcurrency) {
+		var wg sync.WaitGroup
+		for i := range window {
+			wg.Add(1)
+			go func(subBatch *subBatch) {
+				defer wg.Done()
+				var subBatchStats BulkStats
+				subBatch.indexed, subBatch.bulkFailures, subBatch.err = b.bulkWithRetries(subBatch.items, &subBatchStats)
+				if subBatch.err == nil {
+					// Chunks are only written for news that were indexed, a rejected news must not replace the chunks
+					// of the stored version
+					var chunkFailures []BulkFailure
+					chunkFailures, subBatch.err = b.insertBulkChunks(subBatch.indexed, &subBatchStats)
+					subBatch.bulkFailures = append(subBatch.bulkFailures, chunkFailures...)
+				}
+				if subBatch.err == nil && b.opts.KeepHistory && len(subBatch.indexed) > 0 {
+					var historyFailures []BulkFailure
+					historyFailures, subBatch.err = b.insertHistory(subBatch.indexed, &subBatchStats)
+					subBatch.bulkFailures = append(subBatch.bulkFailures, historyFailures...)
+				}
 
-			bodySizes += len(newsItem.Body)
-			if bodySizes >= maxQuerySize {
-				break
+				statsMutex.Lock()
+				defer statsMutex.Unlock()
+				stats.add(subBatchStats)
+			}(&window[i])
+		}
+		wg.Wait()
+
+		for _, subBatch := range window {
+			stats.Indexed += uint64(len(subBatch.indexed))
+			failures = append(failures, subBatch.failures...)
+			failures = append(failures, subBatch.bulkFailures...)
+			if subBatch.err != nil {
+				return subBatch.err
 			}
+
+			insertedCallback(len(subBatch.indexed), subBatch.lastIndex)
+		}
+	}
+
+	if len(failures) > 0 {
+		slices.SortFunc(failures, func(a, b BulkFailure) int { return a.Index - b.Index })
+		return &BulkError{Failures: failures}
+	}
+
+	return nil
+}
+
+// subBatch is the part of a batch sent in a bulk request
+type subBatch struct {
+	items []bulkItem
+	// lastIndex is the batch index of the last news covered by the sub-batch
+	lastIndex int
+	// failures are the news of the sub-batch bigger than MaxBulkBytes, they aren't sent
+	failures []BulkFailure
+
+	// indexed are the news indexed
+	indexed      []bulkItem
+	bulkFailures []BulkFailure
+	err          error
+}
+
+// planSubBatches serializes news into bulk items and splits them into sub-batches
+func (b NewsRepository) planSubBatches(news []*News, keepCreationTime bool) ([]subBatch, error) {
+	maxBytes := b.maxBulkBytes()
+	creationTime := time.Now()
+
+	for _, newsItem := range news {
+		if !keepCreationTime || newsItem.CreationTime.IsZero() {
+			newsItem.CreationTime = creationTime
 		}
+		b.ensureId(newsItem)
+	}
+	partitions, err := b.existingPartitions(context.Background(), news...)
+	if err != nil {
+		return nil, err
+	}
 
-		bulkIndexer, err := b.elastic.bulkIndexer(b.Index)
+	var (
+		subBatches []subBatch
+		current    subBatch
+		size       int
+	)
+	for i, newsItem := range news {
+		item, err := b.newsBulkItem(i, newsItem, partitions)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		for _, newsItem := range news[fromIndex:toIndex] {
-			newsItemBytes, err := json.Marshal(newsItem)
-			if err != nil {
-				return err
-			}
-			err = bulkIndexer.Add(context.Background(), esutil.BulkIndexerItem{
-				Index:      b.Index,
-				DocumentID: newsItem.Id,
-				Action:     "index",
-				Body:       bytes.NewReader(newsItemBytes),
-			})
-			if err != nil {
-				return err
-			}
+		items := append([]bulkItem{item}, item.chunks...)
+		if tooLarge := slices.IndexFunc(items, func(item bulkItem) bool { return item.size() > maxBytes }); tooLarge >= 0 {
+			current.failures = append(current.failures, newsTooLargeFailure(items[tooLarge], maxBytes))
+			current.lastIndex = i
+			continue
 		}
 
-		if err := bulkIndexer.Close(context.Background()); err != nil {
-			return err
+		// The body chunks of a news are sent after the sub-batch, by insertBulkChunks
+		if len(current.items) > 0 && (size+item.size() > maxBytes || len(current.items) >= b.opts.MaxBulkDocs) {
+			subBatches = append(subBatches, current)
+			current, size = subBatch{}, 0
 		}
+		current.items = append(current.items, item)
+		size += item.size()
+		current.lastIndex = i
+	}
 
-		insertedCallback(toIndex-fromIndex, toIndex-1)
+	return append(subBatches, current), nil
+}
+
+// newsBulkItem applies the body strategy to the news at index of a batch and serializes it with its body chunks
+func (b NewsRepository) newsBulkItem(index int, news *News, partitions map[string]string) (bulkItem, error) {
+	chunks := b.applyBodyStrategy(news)
+	item, err := b.newBulkItem(index, news, partitions)
+	if err != nil {
+		return bulkItem{}, err
+	}
 
-		if toIndex >= len(news) {
-			break
+	for _, chunk := range chunks {
+		chunkItem, err := b.newBodyChunkItem(index, chunk)
+		if err != nil {
+			return bulkItem{}, err
 		}
-		fromIndex = toIndex
+		item.chunks = append(item.chunks, chunkItem)
 	}
+	return item, nil
+}
 
-	return nil
+// writeIndex is the index news is written to, partitions are the partitions of the news that already exist
+func (b NewsRepository) writeIndex(news *News, partitions map[string]string) string {
+	if b.opts.Partitioning == PartitionNone {
+		return b.Index
+	}
+
+	if partition, ok := partitions[news.Id]; ok {
+		return partition
+	}
+	if news.PublicationTime.IsZero() {
+		return writeAlias(b.Index)
+	}
+	return partitionIndex(b.Index, news.PublicationTime)
 }
 
+// existingPartitions returns the partition holding each of news that already exists, nil without partitioning
+func (b NewsRepository) existingPartitions(ctx context.Context, news ...*News) (map[string]string, error) {
+	if b.opts.Partitioning == PartitionNone {
+		return nil, nil
+	}
+
+	ids := make([]string, len(news))
+	for i, newsItem := range news {
+		ids[i] = newsItem.Id
+	}
+	copies, err := b.newsPartitions(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := make(map[string]string, len(copies))
+	for id, indices := range copies {
+		partitions[id] = indices[0]
+	}
+	return partitions, nil
+}
+
+// maxBulkBytes is read on each call since tests change maxQuerySize after creating the repository
+func (b NewsRepository) maxBulkBytes() int {
+	if b.opts.MaxBulkBytes > 0 {
+		return b.opts.MaxBulkBytes
+	}
+	return maxQuerySize
+}
+
+// Insert inserts news, ErrNewsTooLarge is returned if it is bigger than MaxBulkBytes once serialized. With CreateOnly,
+// ErrAlreadyExists is returned if a news with the same id exists. With a version policy, ErrVersionConflict is returned if
+// a newer version of the news exists.
 func (b NewsRepository) Insert(news *News) error {
+	_, err := b.insert(context.Background(), news)
+	return err
+}
+
+// InsertNews inserts a copy of news.
+//
+// Deprecated: use Insert
+func (b NewsRepository) InsertNews(news News) error {
+	return b.Insert(&news)
+}
+
+// Upsert is like Insert, created is false if news replaced a news with the same id. It's a single index request, so
+// created is reported by Elasticsearch rather than checked beforehand.
+func (b NewsRepository) Upsert(ctx context.Context, news *News) (created bool, err error) {
+	return b.insert(ctx, news)
+}
+
+func (b NewsRepository) insert(ctx context.Context, news *News) (created bool, err error) {
 	news.CreationTime = time.Now()
-	if len(news.Body) > maxQuerySize {
-		news.Body = ""
+	b.ensureId(news)
+	partitions, err := b.existingPartitions(ctx, news)
+	if err != nil {
+		return false, err
 	}
+	chunks := b.applyBodyStrategy(news)
+	item, err := b.newBulkItem(0, news, partitions)
+	if err != nil {
+		return false, err
+	}
+	if item.size() > b.maxBulkBytes() {
+		return false, errors.Wrapf(ErrNewsTooLarge, "news %s has %d bytes, the maximum is %d", news.Id, item.size(), b.maxBulkBytes())
+	}
+
+	req := b.elastic.TypedClient.Index(b.writeIndex(news, partitions)).Request(news).Id(news.Id)
+	if b.opts.CreateOnly {
+		req.OpType(optype.Create)
+	}
+	if b.opts.VersionPolicy != VersionPolicyNone {
+		req.Version(strconv.FormatInt(item.version, 10)).VersionType(versiontype.Externalgte)
+	}
+	res, err := req.Do(ctx)
+	if err != nil {
+		var esErr *types.ElasticsearchError
+		if errors.As(err, &esErr) && esErr.Status == http.StatusConflict {
+			return false, errors.Wrapf(b.conflictErr(), "news %s", news.Id)
+		}
+		return false, errors.Wrap(err, "failed to insert news")
+	}
+	created = res.Result == result.Created
 
-	_, err := b.elastic.TypedClient.Index(b.Index).Request(news).Id(news.Id).Do(context.Background())
+	// Chunks are written once the news is, a rejected news must not replace the chunks of the stored version
+	err = b.insertBodyChunks(ctx, chunks)
 	if err != nil {
-		return errors.Wrap(err, "failed to insert news")
+		return false, err
 	}
 
-	return nil
+	if b.opts.KeepHistory {
+		return created, b.insertHistoryNews(ctx, news)
+	}
+
+	return created, nil
 }
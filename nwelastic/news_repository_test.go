@@ -1,14 +1,17 @@
 package nwelastic
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"net/http"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
 	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -70,6 +73,8 @@ func (r *newsRepositorySuite) TestNewsRepository_InsertBatch() {
 		name         string
 		insertNews   []*News
 		expectedNews []*News
+		// expectedFailedIds are the news reported in a *BulkError
+		expectedFailedIds []string
 	}{
 		{
 			"insert news",
@@ -104,6 +109,7 @@ func (r *newsRepositorySuite) TestNewsRepository_InsertBatch() {
 				},
 			},
 			nil,
+			nil,
 		},
 		{
 			"limit query size",
@@ -111,17 +117,18 @@ func (r *newsRepositorySuite) TestNewsRepository_InsertBatch() {
 				{
 					Id:              "1",
 					Headline:        "1",
-					Body:            generateBody(maxQuerySize),
+					Body:            generateBody(maxQuerySize / 2),
 					PublicationTime: defaultTime.Add(time.Minute),
 					ReceivedTime:    defaultTime.Add(time.Minute),
 				},
 				{
 					Id:              "2",
-					Headline:        generateBody(maxQuerySize),
+					Headline:        generateBody(maxQuerySize / 2),
 					PublicationTime: defaultTime,
 				},
 			},
 			nil,
+			nil,
 		},
 		{
 			"body bigger than max query size",
@@ -140,21 +147,8 @@ func (r *newsRepositorySuite) TestNewsRepository_InsertBatch() {
 					PublicationTime: defaultTime,
 				},
 			},
-			[]*News{
-				{
-					Id:              "1",
-					Headline:        "1",
-					Body:            "",
-					PublicationTime: defaultTime.Add(time.Minute),
-					ReceivedTime:    defaultTime.Add(time.Minute),
-				},
-				{
-					Id:              "2",
-					Headline:        "2",
-					Body:            "",
-					PublicationTime: defaultTime,
-				},
-			},
+			[]*News{},
+			[]string{"1", "2"},
 		},
 		{
 			"multiple batches",
@@ -203,12 +197,22 @@ func (r *newsRepositorySuite) TestNewsRepository_InsertBatch() {
 				},
 			},
 			nil,
+			nil,
 		},
 	}
 	for _, tt := range tests {
 		r.Run(tt.name, func() {
 			err := r.newsRepository.InsertBatch(tt.insertNews, func(int, int) {})
-			if !r.NoError(err) {
+			if tt.expectedFailedIds != nil {
+				var bulkErr *BulkError
+				r.Require().ErrorAs(err, &bulkErr)
+				actualFailedIds := make([]string, len(bulkErr.Failures))
+				for i, failure := range bulkErr.Failures {
+					r.Equal("news_too_large", failure.Type)
+					actualFailedIds[i] = failure.Id
+				}
+				r.Equal(tt.expectedFailedIds, actualFailedIds)
+			} else if !r.NoError(err) {
 				r.FailNow("")
 			}
 
@@ -217,6 +221,14 @@ func (r *newsRepositorySuite) TestNewsRepository_InsertBatch() {
 				r.FailNow("")
 			}
 
+			// Sorting fails on an empty index without mapping
+			if len(tt.expectedNews) == 0 && tt.expectedNews != nil {
+				count, err := r.newsRepository.elastic.TypedClient.Count().Index(r.newsRepository.Index).Do(context.Background())
+				r.Require().NoError(err)
+				r.Zero(count.Count)
+				return
+			}
+
 			actualNews := make([]*News, 0)
 			resp, err := r.newsRepository.elastic.TypedClient.
 				Search().
@@ -333,6 +345,203 @@ func (r *newsRepositorySuite) TestNewsRepository_Insert() {
 	}
 }
 
+func (r *newsRepositorySuite) TestNewsRepository_Insert_createOnly() {
+	repository, err := NewNewsRepository(r.newsRepository.elastic, NewsRepositoryOpts{IdPolicy: IdPolicyContent, CreateOnly: true})
+	r.Require().NoError(err)
+
+	r.Run("news sent twice", func() {
+		news := News{Headline: "headline", Source: "SOURCE", PublicationTime: time.Now()}
+		first, second := news, news
+		r.Require().NoError(repository.Insert(&first))
+		r.ErrorIs(repository.Insert(&second), ErrAlreadyExists)
+		r.Equal(first.Id, second.Id)
+	})
+}
+
+func (r *newsRepositorySuite) TestNewsRepository_Insert_versioned() {
+	repository, err := NewNewsRepository(r.newsRepository.elastic, NewsRepositoryOpts{IdPolicy: IdPolicyProvider, VersionPolicy: VersionPolicyRevision, KeepHistory: true})
+	r.Require().NoError(err)
+	defer func() {
+		_, _ = r.newsRepository.elastic.TypedClient.Indices.Delete(historyIndex(repository.Index)).Do(context.Background())
+	}()
+
+	r.Run("stale revision", func() {
+		r.Require().NoError(repository.Insert(&News{Id: "1", Headline: "correction", Revision: 2}))
+		r.ErrorIs(repository.Insert(&News{Id: "1", Headline: "original", Revision: 1}), ErrVersionConflict)
+
+		res, err := r.newsRepository.elastic.TypedClient.Get(repository.Index, "1").Do(context.Background())
+		r.Require().NoError(err)
+		var actualNews News
+		r.Require().NoError(json.Unmarshal(res.Source_, &actualNews))
+		r.Equal("correction", actualNews.Headline)
+
+		history, err := r.newsRepository.elastic.TypedClient.Get(historyIndex(repository.Index), historyId("1", 2)).Do(context.Background())
+		r.Require().NoError(err)
+		r.True(history.Found)
+	})
+}
+
+func (r *newsRepositorySuite) TestNewsRepository_Insert_split() {
+	repository, err := NewNewsRepository(r.newsRepository.elastic, NewsRepositoryOpts{BodyStrategy: BodySplit, MaxBodyBytes: 100})
+	r.Require().NoError(err)
+	defer func() {
+		_, _ = r.newsRepository.elastic.TypedClient.Indices.Delete(bodyChunksIndex(repository.Index)).Do(context.Background())
+	}()
+
+	r.Run("body longer than max body bytes", func() {
+		body := generateBody(250)
+		r.Require().NoError(repository.Insert(&News{Id: "1", Headline: "headline", Body: body}))
+
+		res, err := r.newsRepository.elastic.TypedClient.Get(repository.Index, "1").Do(context.Background())
+		r.Require().NoError(err)
+		var actualNews News
+		r.Require().NoError(json.Unmarshal(res.Source_, &actualNews))
+		r.Equal(body[:100], actualNews.Body)
+		r.Equal(BodyStorageChunks, actualNews.BodyStorage)
+		r.Equal(250, actualNews.BodyLength)
+
+		r.Require().NoError(repository.ReassembleBody(context.Background(), &actualNews))
+		r.Equal(body, actualNews.Body)
+	})
+}
+
+func (r *newsRepositorySuite) TestNewsRepository_get() {
+	r.Run("get inserted news", func() {
+		ctx := context.Background()
+		r.Require().NoError(r.newsRepository.Insert(&News{Id: "1", Headline: "headline"}))
+
+		news, err := r.newsRepository.GetById(ctx, "1")
+		r.Require().NoError(err)
+		r.Equal("headline", news.Headline)
+
+		_, err = r.newsRepository.GetById(ctx, "2")
+		r.ErrorIs(err, ErrNewsNotFound)
+
+		many, missing, err := r.newsRepository.GetMany(ctx, []string{"2", "1"})
+		r.Require().NoError(err)
+		r.Len(many, 1)
+		r.Equal([]string{"2"}, missing)
+
+		exists, err := r.newsRepository.Exists(ctx, "1")
+		r.Require().NoError(err)
+		r.True(exists)
+	})
+}
+
+func (r *newsRepositorySuite) TestNewsRepository_delete() {
+	r.Run("delete by id and query", func() {
+		ctx := context.Background()
+		now := time.Now()
+		r.Require().NoError(r.newsRepository.InsertBatch([]*News{
+			{Id: "1", Source: "SEC", PublicationTime: now.Add(-48 * time.Hour)},
+			{Id: "2", Source: "SEC", PublicationTime: now},
+			{Id: "3", Source: "PR", PublicationTime: now.Add(-48 * time.Hour)},
+			{Id: "4", Source: "PR", PublicationTime: now},
+		}, func(int, int) {}))
+
+		r.Require().NoError(r.newsRepository.Delete(ctx, "4"))
+		r.ErrorIs(r.newsRepository.Delete(ctx, "4"), ErrNewsNotFound)
+
+		_, err := r.newsRepository.elastic.TypedClient.Indices.Refresh().Index(r.newsRepository.Index).Do(ctx)
+		r.Require().NoError(err)
+		taskId, err := r.newsRepository.DeleteByQuery(ctx, DeleteFilter{Sources: []string{"SEC"}, To: now.Add(-24 * time.Hour)})
+		r.Require().NoError(err)
+		progress, err := r.newsRepository.WaitDelete(ctx, taskId, 100*time.Millisecond, nil)
+		r.Require().NoError(err)
+		r.Equal(int64(1), progress.Deleted)
+
+		news, missing, err := r.newsRepository.GetMany(ctx, []string{"1", "2", "3", "4"})
+		r.Require().NoError(err)
+		r.Len(news, 2)
+		r.Equal([]string{"1", "4"}, missing)
+	})
+}
+
+func (r *newsRepositorySuite) TestNewsRepository_exportImport() {
+	r.Run("export and import", func() {
+		ctx := context.Background()
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		r.Require().NoError(r.newsRepository.InsertBatch([]*News{
+			{Id: "1", Source: "SEC", Headline: "first", PublicationTime: now.Add(-time.Hour)},
+			{Id: "2", Source: "SEC", Headline: "second", PublicationTime: now},
+			{Id: "3", Source: "PR", Headline: "third", PublicationTime: now},
+		}, func(int, int) {}))
+		_, err := r.newsRepository.elastic.TypedClient.Indices.Refresh().Index(r.newsRepository.Index).Do(ctx)
+		r.Require().NoError(err)
+
+		var output bytes.Buffer
+		progress, err := r.newsRepository.Export(ctx, NewsFilter{Sources: []string{"SEC"}}, &output, ExportOpts{BatchSize: 1})
+		r.Require().NoError(err)
+		r.Equal(ExportProgress{Exported: 2, Total: 2}, progress)
+
+		r.Require().NoError(r.newsRepository.Delete(ctx, "1"))
+		r.Require().NoError(r.newsRepository.Delete(ctx, "2"))
+		importProgress, err := r.newsRepository.Import(ctx, &output)
+		r.Require().NoError(err)
+		r.Equal(ImportProgress{Line: 2, Imported: 2}, importProgress)
+
+		news, err := r.newsRepository.GetById(ctx, "1")
+		r.Require().NoError(err)
+		r.Equal("first", news.Headline)
+		r.True(news.PublicationTime.Equal(now.Add(-time.Hour)))
+	})
+}
+
+func (r *newsRepositorySuite) TestNewsRepository_aggregations() {
+	r.Run("aggregations", func() {
+		ctx := context.Background()
+		start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		r.Require().NoError(r.newsRepository.InsertBatch([]*News{
+			{Id: "1", Source: "SEC", Tickers: []string{"AAPL"}, PublicationTime: start, ReceivedTime: start.Add(time.Second)},
+			{Id: "2", Source: "SEC", Tickers: []string{"AAPL", "MSFT"}, PublicationTime: start.Add(2 * time.Hour), ReceivedTime: start.Add(2*time.Hour + time.Second)},
+			{Id: "3", Source: "PR", Tickers: []string{"MSFT"}, CategoryCodes: []string{"MNA"}, PublicationTime: start.Add(2 * time.Hour)},
+			// Stored with the zero publication time, it would make the histogram start at year 1
+			{Id: "4", Source: "PR", ReceivedTime: start},
+		}, func(int, int) {}))
+		_, err := r.newsRepository.elastic.TypedClient.Indices.Refresh().Index(r.newsRepository.Index).Do(ctx)
+		r.Require().NoError(err)
+
+		histogram, err := r.newsRepository.PublicationHistogram(ctx, NewsFilter{}, time.Hour)
+		r.Require().NoError(err)
+		r.Equal([]TimeBucket{{start, 1}, {start.Add(time.Hour), 0}, {start.Add(2 * time.Hour), 2}}, histogram)
+
+		tickers, err := r.newsRepository.TopTickers(ctx, NewsFilter{Sources: []string{"SEC"}}, 1)
+		r.Require().NoError(err)
+		r.Equal([]TermCount{{"AAPL", 2}}, tickers)
+
+		categories, err := r.newsRepository.TopCategories(ctx, NewsFilter{}, 0)
+		r.Require().NoError(err)
+		r.Equal([]TermCount{{"MNA", 1}}, categories)
+
+		// PR has no news with both times
+		latencies, err := r.newsRepository.IngestionLatency(ctx, NewsFilter{}, 50)
+		r.Require().NoError(err)
+		r.Equal([]SourceLatency{{Source: "SEC", Count: 2, Percentiles: map[float64]time.Duration{50: time.Second}}}, latencies)
+	})
+}
+
+func TestNewsRepository_Upsert(t *testing.T) {
+	results := []string{"created", "updated"}
+	elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/nwelastic_tests/_doc/1" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"_index": "nwelastic_tests", "_id": "1", "result": results[0]})
+		results = results[1:]
+	})
+	repository, err := NewNewsRepository(elastic)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	created, err := repository.Upsert(context.Background(), &News{Id: "1"})
+	assert.NoError(t, err)
+	assert.True(t, created)
+	created, err = repository.Upsert(context.Background(), &News{Id: "1"})
+	assert.NoError(t, err)
+	assert.False(t, created)
+}
+
 func TestNewsRepositorySuite(t *testing.T) {
 	integration := os.Getenv("INTEGRATION")
 	if integration == "" {
@@ -0,0 +1,277 @@
+package nwelastic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/core/mget"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	// PartitionNone stores news in a single index
+	PartitionNone = ""
+	// PartitionMonthly stores news in monthly partitions, "<index>-2006.01", by publication time. The index name is
+	// a read alias over every partition.
+	PartitionMonthly = "monthly"
+
+	partitionLayout = "2006.01"
+	// maxPartitionLookupDocs is the maximum number of documents in a multi get of partitionDocs
+	maxPartitionLookupDocs = 10000
+)
+
+var (
+	ErrInvalidPartitioning = errors.New("invalid partitioning")
+	ErrIndexNotPartitioned = errors.New("a concrete index has the name of the read alias, it must be reindexed into partitions")
+)
+
+// NewsIndexOpts configures the news index managed by EnsureNewsIndex
+type NewsIndexOpts struct {
+	// Partitioning is PartitionNone or PartitionMonthly, NewsRepositoryOpts.Partitioning must match it. Defaults to
+	// PartitionNone.
+	Partitioning string `yaml:"partitioning"`
+	// LifecyclePolicy, if set, is the ILM policy of the partitions, see PutNewsLifecyclePolicy
+	LifecyclePolicy string `yaml:"lifecyclePolicy"`
+}
+
+func validatePartitioning(partitioning string) error {
+	switch partitioning {
+	case PartitionNone, PartitionMonthly:
+		return nil
+	}
+	return errors.Wrap(ErrInvalidPartitioning, partitioning)
+}
+
+// partitionIndex returns the partition of index holding news published at t
+func partitionIndex(index string, t time.Time) string {
+	return index + "-" + t.UTC().Format(partitionLayout)
+}
+
+// writeAlias is the alias pointing to the partition of the current month
+func writeAlias(index string) string {
+	return index + "_write"
+}
+
+// partitions returns the existing partitions of the news index
+func (b NewsRepository) partitions(ctx context.Context) ([]string, error) {
+	res, err := b.elastic.TypedClient.Indices.ResolveIndex(b.Index + "-*").Do(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving partitions")
+	}
+
+	partitions := make([]string, len(res.Indices))
+	for i, index := range res.Indices {
+		partitions[i] = index.Name
+	}
+	return partitions, nil
+}
+
+// partitionDocs gets the news with ids from every partition, by id. Multi get is realtime, unlike a search through the
+// read alias it finds news written before the last refresh. The source is only returned with source.
+func (b NewsRepository) partitionDocs(ctx context.Context, ids []string, source bool) (map[string][]*types.GetResult, error) {
+	partitions, err := b.partitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(partitions) == 0 {
+		return nil, nil
+	}
+
+	docs := make(map[string][]*types.GetResult, len(ids))
+	chunkSize := max(1, maxPartitionLookupDocs/len(partitions))
+	for chunk := range slices.Chunk(ids, chunkSize) {
+		req := &mget.Request{Docs: make([]types.MgetOperation, 0, len(chunk)*len(partitions))}
+		for _, id := range chunk {
+			for _, partition := range partitions {
+				operation := types.MgetOperation{Id_: id, Index_: &partition}
+				if !source {
+					operation.Source_ = false
+				}
+				req.Docs = append(req.Docs, operation)
+			}
+		}
+
+		res, err := b.elastic.TypedClient.Mget().Request(req).Do(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "getting news from partitions")
+		}
+
+		for _, doc := range res.Docs {
+			switch doc := doc.(type) {
+			case *types.GetResult:
+				if doc.Found {
+					docs[doc.Id_] = append(docs[doc.Id_], doc)
+				}
+			case *types.MultiGetError:
+				// A partition deleted by its lifecycle policy after being resolved no longer holds news
+				if doc.Error.Type == "index_not_found_exception" {
+					continue
+				}
+				return nil, errors.Errorf("getting news %s from partitions: %s", doc.Id_, stringValue(doc.Error.Reason))
+			default:
+				return nil, errors.Errorf("unexpected multi get item %T", doc)
+			}
+		}
+	}
+	return docs, nil
+}
+
+func partitionTemplateName(index string) string {
+	return index + "_partitions"
+}
+
+// newsPartitionTemplate returns the index template of the partitions of index, it has a higher priority than
+// newsTemplate and adds the partitions to the read alias
+func newsPartitionTemplate(index string, version int, opts NewsIndexOpts) ([]byte, error) {
+	settings := map[string]any{
+		"analysis": json.RawMessage(newsAnalysis),
+	}
+	if opts.LifecyclePolicy != "" {
+		settings["index.lifecycle.name"] = opts.LifecyclePolicy
+	}
+
+	return json.Marshal(map[string]any{
+		"index_patterns": []string{index + "-*"},
+		"priority":       200,
+		"version":        version,
+		"_meta": map[string]any{
+			"description": "Monthly partitions of news, managed by nwelastic.EnsureNewsIndex",
+		},
+		"template": map[string]any{
+			"settings": settings,
+			"mappings": json.RawMessage(newsMappings),
+			"aliases":  map[string]any{index: map[string]any{}},
+		},
+	})
+}
+
+func putNewsPartitionTemplate(ctx context.Context, e *Elastic, index string, version int, opts NewsIndexOpts) error {
+	template, err := newsPartitionTemplate(index, version, opts)
+	if err != nil {
+		return errors.Wrap(err, "marshaling partition index template")
+	}
+
+	_, err = e.TypedClient.Indices.PutIndexTemplate(partitionTemplateName(index)).Raw(strings.NewReader(string(template))).Do(ctx)
+	if err != nil {
+		return errors.Wrap(err, "putting partition index template")
+	}
+
+	return nil
+}
+
+// ensurePartitionMappings adds the analysis settings and the mapping to the existing partitions of index, new
+// partitions get them from the partition template
+func ensurePartitionMappings(ctx context.Context, e *Elastic, index string) error {
+	partitions := index + "-*"
+	exists, err := e.TypedClient.Indices.Exists(partitions).AllowNoIndices(false).Do(ctx)
+	if err != nil {
+		return errors.Wrap(err, "checking if partitions exist")
+	}
+	if !exists {
+		return nil
+	}
+
+	return putAnalysisAndMappings(ctx, e, partitions, newsMappings)
+}
+
+// verifyNotConcrete returns ErrIndexNotPartitioned if index is a concrete index rather than the read alias
+func verifyNotConcrete(ctx context.Context, e *Elastic, index string) error {
+	exists, err := e.TypedClient.Indices.Exists(index).Do(ctx)
+	if err != nil {
+		return errors.Wrap(err, "checking if index exists")
+	}
+	if !exists {
+		return nil
+	}
+
+	isAlias, err := e.TypedClient.Indices.ExistsAlias(index).Do(ctx)
+	if err != nil {
+		return errors.Wrap(err, "checking if index is an alias")
+	}
+	if !isAlias {
+		return errors.Wrap(ErrIndexNotPartitioned, index)
+	}
+
+	return nil
+}
+
+// RolloverNews creates the partitions of elastic.Config.NewsIndex for the month of now and the next one, and points
+// the write alias, "<index>_write", to the partition of now. NewsRepository writes news without a publication time
+// through the write alias. It is called by EnsureNewsIndex and must be called at least monthly, for example daily by a
+// cron job. Partitions created by RolloverNews start their lifecycle at the beginning of their month, partitions
+// created when inserting older news start it when they are created.
+func RolloverNews(ctx context.Context, elastic Elastic, now time.Time) error {
+	err := elastic.StartTypedClient()
+	if err != nil {
+		return err
+	}
+
+	index := elastic.Config.NewsIndex
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for _, start := range []time.Time{month, month.AddDate(0, 1, 0)} {
+		err = createPartition(ctx, &elastic, index, start)
+		if err != nil {
+			return err
+		}
+	}
+
+	current := partitionIndex(index, month)
+	actions := fmt.Sprintf(`{"actions": [
+		{"remove": {"index": "%s-*", "alias": "%s", "must_exist": false}},
+		{"add": {"index": "%s", "alias": "%s", "is_write_index": true}}
+	]}`, index, writeAlias(index), current, writeAlias(index))
+	_, err = elastic.TypedClient.Indices.UpdateAliases().Raw(strings.NewReader(actions)).Do(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "pointing write alias to %s", current)
+	}
+
+	return nil
+}
+
+// createPartition creates the partition of index starting at start if it doesn't exist
+func createPartition(ctx context.Context, e *Elastic, index string, start time.Time) error {
+	partition := partitionIndex(index, start)
+	exists, err := e.TypedClient.Indices.Exists(partition).Do(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "checking if partition %s exists", partition)
+	}
+	if exists {
+		return nil
+	}
+
+	settings := fmt.Sprintf(`{"settings": {"index.lifecycle.origination_date": %d}}`, start.UnixMilli())
+	_, err = e.TypedClient.Indices.Create(partition).Raw(strings.NewReader(settings)).Do(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "creating partition %s", partition)
+	}
+
+	return nil
+}
+
+// PutNewsLifecyclePolicy creates or updates the ILM policy name, it deletes partitions once retention has passed since
+// the beginning of their month. Set NewsIndexOpts.LifecyclePolicy to name to apply it to the partitions.
+func PutNewsLifecyclePolicy(ctx context.Context, elastic Elastic, name string, retention time.Duration) error {
+	err := elastic.StartTypedClient()
+	if err != nil {
+		return err
+	}
+
+	policy := fmt.Sprintf(`{"policy": {
+		"_meta": {"description": "Deletes news partitions, managed by nwelastic.PutNewsLifecyclePolicy"},
+		"phases": {
+			"hot": {"min_age": "0ms", "actions": {}},
+			"delete": {"min_age": "%dms", "actions": {"delete": {}}}
+		}
+	}}`, retention.Milliseconds())
+	_, err = elastic.TypedClient.Ilm.PutLifecycle(name).Raw(strings.NewReader(policy)).Do(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "putting lifecycle policy %s", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package nwelastic
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestNewsPartitionTemplate(t *testing.T) {
+	template, err := newsPartitionTemplate("news", 1, NewsIndexOpts{Partitioning: PartitionMonthly, LifecyclePolicy: "news-retention"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var actual struct {
+		IndexPatterns []string `json:"index_patterns"`
+		Priority      int      `json:"priority"`
+		Template      struct {
+			Settings map[string]any            `json:"settings"`
+			Aliases  map[string]map[string]any `json:"aliases"`
+		} `json:"template"`
+	}
+	if !assert.NoError(t, json.Unmarshal(template, &actual)) {
+		return
+	}
+
+	assert.Equal(t, []string{"news-*"}, actual.IndexPatterns)
+	assert.Greater(t, actual.Priority, 100)
+	assert.Equal(t, "news-retention", actual.Template.Settings["index.lifecycle.name"])
+	assert.Contains(t, actual.Template.Aliases, "news")
+}
+
+func TestNewsRepository_InsertBatch_partitioned(t *testing.T) {
+	var actualIndices map[string]string
+	elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+		// News 4 was published in another month before its publication time was corrected
+		if servePartitions(w, r, []string{"nwelastic_tests-2026.08", "nwelastic_tests-2026.09"}, map[string][]string{"4": {"nwelastic_tests-2026.08"}}) {
+			return
+		}
+
+		actualIndices = map[string]string{}
+		var items []map[string]any
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action map[string]struct {
+				Index string `json:"_index"`
+				Id    string `json:"_id"`
+			}
+			json.Unmarshal(scanner.Bytes(), &action)
+			scanner.Scan()
+
+			actualIndices[action["index"].Id] = action["index"].Index
+			items = append(items, map[string]any{"index": map[string]any{"_id": action["index"].Id, "status": http.StatusCreated}})
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{"took": 1, "errors": false, "items": items})
+	})
+
+	repository, err := NewNewsRepository(elastic, NewsRepositoryOpts{Partitioning: PartitionMonthly})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = repository.InsertBatch([]*News{
+		{Id: "1", PublicationTime: time.Date(2026, 9, 30, 23, 0, 0, 0, time.UTC)},
+		// Partitions are in UTC
+		{Id: "2", PublicationTime: time.Date(2026, 9, 30, 21, 0, 0, 0, time.FixedZone("EST", -5*3600))},
+		{Id: "3"},
+		{Id: "4", PublicationTime: time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)},
+	}, func(int, int) {})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	index := repository.Index
+	assert.Equal(t, map[string]string{
+		"1": index + "-2026.09",
+		"2": index + "-2026.10",
+		"3": writeAlias(index),
+		"4": index + "-2026.08",
+	}, actualIndices)
+}
+
+func TestNewsRepository_partitionDocs_chunks(t *testing.T) {
+	var actualDocs []int
+	elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_mget" {
+			var req struct {
+				Docs []any `json:"docs"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			actualDocs = append(actualDocs, len(req.Docs))
+			w.Write([]byte(`{"docs": []}`))
+			return
+		}
+		servePartitions(w, r, []string{"nwelastic_tests-2024.01", "nwelastic_tests-2024.02", "nwelastic_tests-2024.03"}, nil)
+	})
+	repository, err := NewNewsRepository(elastic, NewsRepositoryOpts{Partitioning: PartitionMonthly})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ids := make([]string, 4000)
+	for i := range ids {
+		ids[i] = strconv.Itoa(i)
+	}
+	_, err = repository.partitionDocs(context.Background(), ids, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{9999, 2001}, actualDocs)
+}
+
+// servePartitions answers the requests of NewsRepository.partitionDocs, the partitions are resolved to partitions and
+// copies are the partitions holding each news, by id. It returns false for other requests.
+func servePartitions(w http.ResponseWriter, r *http.Request, partitions []string, copies map[string][]string) bool {
+	switch r.URL.Path {
+	case "/_resolve/index/nwelastic_tests-*":
+		indices := make([]map[string]any, len(partitions))
+		for i, partition := range partitions {
+			indices[i] = map[string]any{"name": partition, "attributes": []string{"open"}}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"indices": indices, "aliases": []any{}, "data_streams": []any{}})
+	case "/_mget":
+		var req struct {
+			Docs []struct {
+				Index  string `json:"_index"`
+				Id     string `json:"_id"`
+				Source *bool  `json:"_source"`
+			} `json:"docs"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		docs := make([]map[string]any, len(req.Docs))
+		for i, op := range req.Docs {
+			doc := map[string]any{"_index": op.Index, "_id": op.Id, "found": slices.Contains(copies[op.Id], op.Index)}
+			if doc["found"] == true && op.Source == nil {
+				doc["_source"] = map[string]any{"id": op.Id}
+			}
+			docs[i] = doc
+		}
+		json.NewEncoder(w).Encode(map[string]any{"docs": docs})
+	default:
+		return false
+	}
+	return true
+}
+
+// partitionSuite performs integration tests
+type partitionSuite struct {
+	suite.Suite
+	elastic Elastic
+}
+
+func (p *partitionSuite) SetupTest() {
+	config := TestElasticConfig
+	config.NewsIndex = "nwelastic_tests_partitions_" + strconv.Itoa(rand.Int())
+	p.elastic = NewElastic(config)
+	p.Require().NoError(p.elastic.StartTypedClient())
+}
+
+func (p *partitionSuite) TearDownTest() {
+	ctx := context.Background()
+	index := p.elastic.Config.NewsIndex
+	_, _ = p.elastic.TypedClient.Indices.Delete(index + "-*").Do(ctx)
+	_, _ = p.elastic.TypedClient.Indices.Delete(index).Do(ctx)
+	_, _ = p.elastic.TypedClient.Indices.Delete(migrationsIndex(index)).Do(ctx)
+	_, _ = p.elastic.TypedClient.Indices.DeleteIndexTemplate(partitionTemplateName(index)).Do(ctx)
+	_, _ = p.elastic.TypedClient.Indices.DeleteIndexTemplate(index).Do(ctx)
+	_, _ = p.elastic.TypedClient.Indices.DeleteIndexTemplate(bodyChunksIndex(index)).Do(ctx)
+}
+
+func (p *partitionSuite) TestPartitionedNews() {
+	ctx := context.Background()
+	index := p.elastic.Config.NewsIndex
+	p.Require().NoError(EnsureNewsIndex(ctx, p.elastic, NewsIndexOpts{Partitioning: PartitionMonthly}))
+
+	repository, err := NewNewsRepository(p.elastic, NewsRepositoryOpts{Partitioning: PartitionMonthly})
+	p.Require().NoError(err)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	lastYear := now.AddDate(-1, 0, 0)
+	p.Require().NoError(repository.InsertBatch([]*News{
+		{Id: "1", Headline: "old", PublicationTime: lastYear},
+		{Id: "2", Headline: "new", PublicationTime: now},
+	}, func(int, int) {}))
+	_, err = p.elastic.TypedClient.Indices.Refresh().Index(index).Do(ctx)
+	p.Require().NoError(err)
+
+	// Search reads every partition through the read alias
+	result, err := repository.Search(ctx, NewsFilter{})
+	p.Require().NoError(err)
+	p.Equal([]string{"2", "1"}, newsIds(result.News))
+
+	// Correcting the publication time keeps the news in its partition, even before a refresh
+	p.Require().NoError(repository.Insert(&News{Id: "1", Headline: "old", PublicationTime: now.Add(-time.Hour)}))
+	p.Require().NoError(repository.Insert(&News{Id: "3", Headline: "quick", PublicationTime: lastYear}))
+	p.Require().NoError(repository.Insert(&News{Id: "3", Headline: "quick", PublicationTime: now}))
+	partitions, err := repository.newsPartitions(ctx, []string{"1", "3"})
+	p.Require().NoError(err)
+	p.Equal([]string{partitionIndex(index, lastYear)}, partitions["1"])
+	p.Equal([]string{partitionIndex(index, lastYear)}, partitions["3"])
+	news, err := repository.GetById(ctx, "3")
+	p.Require().NoError(err)
+	p.True(now.Equal(news.PublicationTime))
+	exists, err := repository.Exists(ctx, "3")
+	p.Require().NoError(err)
+	p.True(exists)
+
+	// The partition of old news is created from the partition template
+	mapping, err := p.elastic.TypedClient.Indices.GetMapping().Index(partitionIndex(index, lastYear)).Do(ctx)
+	p.Require().NoError(err)
+	p.Contains(mapping[partitionIndex(index, lastYear)].Mappings.Properties, "tickers")
+
+	// The write alias moves to the partition of the next month
+	nextMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)
+	p.Require().NoError(RolloverNews(ctx, p.elastic, nextMonth))
+	aliases, err := p.elastic.TypedClient.Indices.GetAlias().Name(writeAlias(index)).Do(ctx)
+	p.Require().NoError(err)
+	p.Len(aliases, 1)
+	p.Contains(aliases, partitionIndex(index, nextMonth))
+}
+
+func (p *partitionSuite) TestEnsureNewsIndex_concreteIndex() {
+	ctx := context.Background()
+	_, err := p.elastic.TypedClient.Indices.Create(p.elastic.Config.NewsIndex).Do(ctx)
+	p.Require().NoError(err)
+
+	p.ErrorIs(EnsureNewsIndex(ctx, p.elastic, NewsIndexOpts{Partitioning: PartitionMonthly}), ErrIndexNotPartitioned)
+}
+
+func TestPartitionSuite(t *testing.T) {
+	integration := os.Getenv("INTEGRATION")
+	if integration == "" {
+		t.Skip("skipping: set INTEGRATION env to run this test")
+	}
+
+	suite.Run(t, new(partitionSuite))
+}
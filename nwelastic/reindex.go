@@ -0,0 +1,77 @@
+package nwelastic
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrReindexPartitioned = errors.New("partitioned news indices can't be reindexed by ReindexNews")
+)
+
+// ReindexNews copies the news index elastic.Config.NewsIndex into a new index with the current mapping,
+// "<index>_reindexed_<unix time>", and atomically replaces the old index with an alias of the same name pointing to
+// it. It's the migration for ErrIncompatibleMapping, EnsureNewsIndex must be called again once it returns. News
+// written during the copy are lost, writers must be stopped first. It can be called again later, the alias is then
+// moved to the next copy. Partitioned indices aren't supported, ErrReindexPartitioned is returned.
+func ReindexNews(ctx context.Context, elastic Elastic) error {
+	err := elastic.StartTypedClient()
+	if err != nil {
+		return err
+	}
+
+	index := elastic.Config.NewsIndex
+	res, err := elastic.TypedClient.Indices.Get(index).Do(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "getting index %s", index)
+	}
+	if len(res) != 1 {
+		return errors.Wrapf(ErrReindexPartitioned, "%s has %d indices", index, len(res))
+	}
+	var source string
+	for name := range res {
+		source = name
+	}
+
+	dest := index + "_reindexed_" + strconv.FormatInt(time.Now().Unix(), 10)
+	body, err := json.Marshal(map[string]any{
+		"settings": map[string]any{"analysis": json.RawMessage(newsAnalysis)},
+		"mappings": json.RawMessage(newsMappings),
+	})
+	if err != nil {
+		return errors.Wrap(err, "marshaling index")
+	}
+	_, err = elastic.TypedClient.Indices.Create(dest).Raw(strings.NewReader(string(body))).Do(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "creating index %s", dest)
+	}
+
+	reindexRes, err := elastic.TypedClient.Reindex().
+		Source(&types.ReindexSource{Index: []string{source}}).
+		Dest(&types.ReindexDestination{Index: dest}).
+		WaitForCompletion(true).
+		Refresh(true).
+		Do(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "reindexing %s into %s", source, dest)
+	}
+	if len(reindexRes.Failures) > 0 {
+		return errors.Errorf("reindexing %s into %s: %d failures, the first is %s", source, dest, len(reindexRes.Failures), stringValue(reindexRes.Failures[0].Cause.Reason))
+	}
+
+	_, err = elastic.TypedClient.Indices.UpdateAliases().Actions(
+		types.IndicesAction{Add: &types.AddAction{Index: &dest, Alias: &index}},
+		types.IndicesAction{RemoveIndex: &types.RemoveIndexAction{Index: &source}},
+	).Do(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "replacing %s with %s", source, dest)
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package nwelastic
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultRetentionInterval = 24 * time.Hour
+)
+
+var (
+	ErrInvalidRetentionRule = errors.New("invalid retention rule")
+)
+
+// RetentionRule deletes the news of Source published more than MaxAge ago
+type RetentionRule struct {
+	Source string        `yaml:"source"`
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+type RetentionOpts struct {
+	Rules []RetentionRule `yaml:"rules"`
+	// Interval is the time between runs, defaults to 24h
+	Interval time.Duration `yaml:"interval"`
+	// PollInterval is the time between polls of the progress of a deletion, defaults to 5s
+	PollInterval time.Duration `yaml:"pollInterval"`
+	// IncludeHistory also deletes the versions kept by NewsRepositoryOpts.KeepHistory
+	IncludeHistory bool `yaml:"includeHistory"`
+	// OnResult, if set, is called with the result of each rule on each run
+	OnResult func(RetentionResult) `yaml:"-"`
+}
+
+// RetentionResult is the result of applying a RetentionRule
+type RetentionResult struct {
+	Source string
+	// Before is the publication time before which news were deleted
+	Before time.Time
+	// Deleted is the number of documents deleted, including body chunks and history versions
+	Deleted int64
+	Err     error
+}
+
+// RetentionRunner deletes old news of each source on a schedule
+type RetentionRunner struct {
+	repository NewsRepository
+	opts       RetentionOpts
+	running    bool
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// NewRetentionRunner creates a RetentionRunner, ErrInvalidRetentionRule is returned if a rule has no source or a
+// non-positive max age
+func NewRetentionRunner(repository NewsRepository, opts RetentionOpts) (*RetentionRunner, error) {
+	for _, rule := range opts.Rules {
+		if rule.Source == "" || rule.MaxAge <= 0 {
+			return nil, errors.Wrapf(ErrInvalidRetentionRule, "source %q with max age %s", rule.Source, rule.MaxAge)
+		}
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultRetentionInterval
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultDeletePollInterval
+	}
+
+	return &RetentionRunner{
+		repository: repository,
+		opts:       opts,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// Run applies every rule once, one after the other, and returns their results
+func (r *RetentionRunner) Run(ctx context.Context, now time.Time) []RetentionResult {
+	results := make([]RetentionResult, 0, len(r.opts.Rules))
+	for _, rule := range r.opts.Rules {
+		result := RetentionResult{Source: rule.Source, Before: now.Add(-rule.MaxAge)}
+		result.Deleted, result.Err = r.apply(ctx, result)
+		if r.opts.OnResult != nil {
+			r.opts.OnResult(result)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (r *RetentionRunner) apply(ctx context.Context, result RetentionResult) (int64, error) {
+	taskId, err := r.repository.DeleteByQuery(ctx, DeleteFilter{
+		Sources:        []string{result.Source},
+		To:             result.Before,
+		IncludeHistory: r.opts.IncludeHistory,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	progress, err := r.repository.WaitDelete(ctx, taskId, r.opts.PollInterval, nil)
+	return progress.Deleted, err
+}
+
+// Start runs the rules now and then each interval until Close is called
+func (r *RetentionRunner) Start() {
+	r.running = true
+	go func() {
+		defer close(r.done)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-r.stop
+			cancel()
+		}()
+
+		ticker := time.NewTicker(r.opts.Interval)
+		defer ticker.Stop()
+		for {
+			r.Run(ctx, time.Now())
+
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Close stops the runner, cancelling a run in progress, and waits for it to return. A deletion task already started
+// keeps running in Elasticsearch.
+func (r *RetentionRunner) Close() {
+	select {
+	case <-r.stop:
+		return
+	default:
+		close(r.stop)
+	}
+	if r.running {
+		<-r.done
+	}
+}
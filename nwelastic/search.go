@@ -0,0 +1,339 @@
+package nwelastic
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/core/search"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/sortorder"
+	"github.com/encypher-studio/newsware-utils/api/response"
+	"github.com/pkg/errors"
+)
+
+const (
+	SortPublicationTime = "publicationTime"
+	SortReceivedTime    = "receivedTime"
+	// SortRelevance sorts by the score of NewsFilter.Query
+	SortRelevance = "relevance"
+
+	SortDesc = "desc"
+	SortAsc  = "asc"
+
+	defaultSearchSize      = 20
+	maxSearchSize          = 1000
+	defaultSearchKeepAlive = time.Minute
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid search cursor")
+	ErrCursorExpired = errors.New("search cursor expired")
+	ErrInvalidSort   = errors.New("invalid search sort")
+)
+
+// NewsFilter selects the news returned by NewsRepository.Search, empty fields don't filter. A news matches if it has
+// any of the values of each non-empty field.
+type NewsFilter struct {
+	Tickers       []string `json:"tickers,omitempty"`
+	Sources       []string `json:"sources,omitempty"`
+	Ciks          []int    `json:"ciks,omitempty"`
+	CategoryCodes []string `json:"categoryCodes,omitempty"`
+	IndustryCodes []string `json:"industryCodes,omitempty"`
+	RegionCodes   []string `json:"regionCodes,omitempty"`
+	// From and To limit the publication time, From is inclusive and To exclusive
+	From time.Time `json:"from,omitzero"`
+	To   time.Time `json:"to,omitzero"`
+	// Query is matched against the headline and the body
+	Query string `json:"query,omitempty"`
+	// Sort is SortPublicationTime, SortReceivedTime or SortRelevance, defaults to SortPublicationTime
+	Sort string `json:"sort,omitempty"`
+	// Order is SortDesc or SortAsc, defaults to SortDesc
+	Order string `json:"order,omitempty"`
+	// Size is the number of news per page, defaults to 20 and can't exceed 1000
+	Size int `json:"size,omitempty"`
+	// Paginate returns a Cursor with the first page, the first page has no Cursor without it
+	Paginate bool `json:"paginate,omitempty"`
+	// Cursor is SearchResult.Cursor of the previous page, empty for the first page
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// SearchResult is a page of news. Cursor is passed in NewsFilter to get the next page, it's empty on the last page.
+type SearchResult struct {
+	News   []News
+	Cursor string
+	// Total is the number of news matching the filter, it is a lower bound if there are more than 10000
+	Total int64
+}
+
+// Pagination returns the pagination of the result for an api response
+func (r SearchResult) Pagination() response.Pagination {
+	pagination := response.Pagination{Total: &r.Total}
+	if r.Cursor != "" {
+		pagination.Cursor = r.Cursor
+	}
+	return pagination
+}
+
+// Search returns a page of the news matching filter, sorted by filter.Sort. With filter.Paginate, the first page opens
+// a point in time whose id is kept in the cursor, so the next pages see the same news and ties are broken by document,
+// including news without id. The point in time is kept for NewsRepositoryOpts.SearchKeepAlive between pages,
+// ErrCursorExpired is returned after. It's closed with the last page, call ClosePointInTime to stop paging earlier.
+// It relies on the mapping created by EnsureNewsIndex.
+func (b NewsRepository) Search(ctx context.Context, filter NewsFilter) (SearchResult, error) {
+	req, err := newsSearchRequest(filter)
+	if err != nil {
+		return SearchResult{}, err
+	}
+	if !paginated(filter) {
+		return b.searchPage(ctx, req)
+	}
+
+	keepAlive := b.opts.SearchKeepAlive
+	if keepAlive <= 0 {
+		keepAlive = defaultSearchKeepAlive
+	}
+	keepAliveMs := strconv.FormatInt(keepAlive.Milliseconds(), 10) + "ms"
+	if req.Pit == nil {
+		pit, err := b.elastic.TypedClient.OpenPointInTime(b.Index).KeepAlive(keepAliveMs).Do(ctx)
+		if err != nil {
+			return SearchResult{}, errors.Wrap(err, "opening point in time")
+		}
+		req.Pit = &types.PointInTimeReference{Id: pit.Id}
+	}
+	req.Pit.KeepAlive = keepAliveMs
+
+	res, err := b.elastic.TypedClient.Search().Request(req).Do(ctx)
+	if err != nil {
+		b.closePointInTime(req.Pit.Id)
+		var esErr *types.ElasticsearchError
+		if filter.Cursor != "" && errors.As(err, &esErr) && esErr.Status == http.StatusNotFound {
+			return SearchResult{}, errors.Wrap(ErrCursorExpired, err.Error())
+		}
+		return SearchResult{}, errors.Wrap(err, "searching news")
+	}
+	pitId := req.Pit.Id
+	if res.PitId != nil {
+		pitId = *res.PitId
+	}
+
+	result, err := searchResult(res.Hits)
+	if err != nil {
+		b.closePointInTime(pitId)
+		return SearchResult{}, err
+	}
+
+	// A full page might be followed by more news
+	if len(res.Hits.Hits) < *req.Size {
+		b.closePointInTime(pitId)
+		return result, nil
+	}
+	result.Cursor, err = encodeCursor(pitId, res.Hits.Hits[len(res.Hits.Hits)-1].Sort)
+	if err != nil {
+		return SearchResult{}, err
+	}
+
+	return result, nil
+}
+
+// searchPage returns a single page of news without cursor, no point in time is opened
+func (b NewsRepository) searchPage(ctx context.Context, req *search.Request) (SearchResult, error) {
+	res, err := b.elastic.TypedClient.Search().Index(b.Index).Request(req).Do(ctx)
+	if err != nil {
+		return SearchResult{}, errors.Wrap(err, "searching news")
+	}
+	return searchResult(res.Hits)
+}
+
+// ClosePointInTime closes the point in time of a cursor returned by Search, for callers that stop before the last
+// page. Cursors without point in time are ignored.
+func (b NewsRepository) ClosePointInTime(ctx context.Context, cursor string) error {
+	decoded, err := decodeCursor(cursor)
+	if err != nil {
+		return err
+	}
+	if decoded.PitId == "" {
+		return nil
+	}
+
+	_, err = b.elastic.TypedClient.ClosePointInTime().Id(decoded.PitId).Do(ctx)
+	if err != nil {
+		return errors.Wrap(err, "closing point in time")
+	}
+	return nil
+}
+
+// closePointInTime closes the point in time of a search, it expires after its keep alive if it can't be closed
+func (b NewsRepository) closePointInTime(pitId string) {
+	b.elastic.TypedClient.ClosePointInTime().Id(pitId).Do(context.Background())
+}
+
+// searchResult returns the news of the hits of a search, without cursor
+func searchResult(hits types.HitsMetadata) (SearchResult, error) {
+	result := SearchResult{News: make([]News, 0, len(hits.Hits))}
+	if hits.Total != nil {
+		result.Total = hits.Total.Value
+	}
+	for _, hit := range hits.Hits {
+		var news News
+		err := json.Unmarshal(hit.Source_, &news)
+		if err != nil {
+			return SearchResult{}, errors.Wrap(err, "unmarshaling news")
+		}
+		// News inserted without id only have the id generated by Elasticsearch
+		if news.Id == "" && hit.Id_ != nil {
+			news.Id = *hit.Id_
+		}
+		result.News = append(result.News, news)
+	}
+	return result, nil
+}
+
+// paginated returns true if the search of filter returns a cursor
+func paginated(filter NewsFilter) bool {
+	return filter.Paginate || filter.Cursor != ""
+}
+
+func newsSearchRequest(filter NewsFilter) (*search.Request, error) {
+	size := filter.Size
+	if size <= 0 {
+		size = defaultSearchSize
+	}
+	size = min(size, maxSearchSize)
+
+	sort, err := newsSort(filter, paginated(filter))
+	if err != nil {
+		return nil, err
+	}
+
+	boolQuery := &types.BoolQuery{}
+	addTerms := func(field string, values []types.FieldValue) {
+		if len(values) > 0 {
+			boolQuery.Filter = append(boolQuery.Filter, types.Query{
+				Terms: &types.TermsQuery{TermsQuery: map[string]types.TermsQueryField{field: values}},
+			})
+		}
+	}
+	addTerms("tickers", fieldValues(filter.Tickers))
+	addTerms("source", fieldValues(filter.Sources))
+	addTerms("ciks", fieldValues(filter.Ciks))
+	addTerms("categoryCodes", fieldValues(filter.CategoryCodes))
+	addTerms("industryCodes", fieldValues(filter.IndustryCodes))
+	addTerms("regionCodes", fieldValues(filter.RegionCodes))
+
+	if !filter.From.IsZero() || !filter.To.IsZero() {
+		boolQuery.Filter = append(boolQuery.Filter, publicationTimeRange(filter.From, filter.To))
+	}
+
+	if filter.Query != "" {
+		boolQuery.Must = append(boolQuery.Must, types.Query{
+			MultiMatch: &types.MultiMatchQuery{Query: filter.Query, Fields: []string{"headline^2", "body"}},
+		})
+	}
+
+	req := &search.Request{
+		Query: &types.Query{Bool: boolQuery},
+		Sort:  sort,
+		Size:  &size,
+	}
+
+	if filter.Cursor != "" {
+		cursor, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		req.SearchAfter = cursor.After
+		if cursor.PitId != "" {
+			req.Pit = &types.PointInTimeReference{Id: cursor.PitId}
+		}
+	}
+
+	return req, nil
+}
+
+func newsSort(filter NewsFilter, pit bool) ([]types.SortCombinations, error) {
+	order := &sortorder.Desc
+	switch filter.Order {
+	case "", SortDesc:
+	case SortAsc:
+		order = &sortorder.Asc
+	default:
+		return nil, errors.Wrap(ErrInvalidSort, filter.Order)
+	}
+
+	field := filter.Sort
+	switch field {
+	case "":
+		field = SortPublicationTime
+	case SortPublicationTime, SortReceivedTime:
+	case SortRelevance:
+		field = "_score"
+	default:
+		return nil, errors.Wrap(ErrInvalidSort, filter.Sort)
+	}
+
+	sort := []types.SortCombinations{map[string]types.FieldSort{field: {Order: order}}}
+	// _shard_doc requires a point in time, it's set by Search
+	if pit {
+		sort = append(sort, map[string]types.FieldSort{"_shard_doc": {Order: order}})
+	}
+	return sort, nil
+}
+
+// publicationTimeRange matches news published from, inclusive, to to, exclusive. A zero time doesn't limit.
+func publicationTimeRange(from, to time.Time) types.Query {
+	dateRange := types.DateRangeQuery{}
+	if !from.IsZero() {
+		gte := from.Format(time.RFC3339Nano)
+		dateRange.Gte = &gte
+	}
+	if !to.IsZero() {
+		lt := to.Format(time.RFC3339Nano)
+		dateRange.Lt = &lt
+	}
+	return types.Query{Range: map[string]types.RangeQuery{"publicationTime": dateRange}}
+}
+
+func fieldValues[T any](values []T) []types.FieldValue {
+	fieldValues := make([]types.FieldValue, len(values))
+	for i, value := range values {
+		fieldValues[i] = value
+	}
+	return fieldValues
+}
+
+// searchCursor is the position of the next page, the point in time searched and the sort values of the last hit
+type searchCursor struct {
+	PitId string             `json:"pit,omitempty"`
+	After []types.FieldValue `json:"after"`
+}
+
+// encodeCursor encodes the point in time and the sort values of the last hit of a page
+func encodeCursor(pitId string, sortValues []types.FieldValue) (string, error) {
+	cursorJson, err := json.Marshal(searchCursor{PitId: pitId, After: sortValues})
+	if err != nil {
+		return "", errors.Wrap(err, "marshaling cursor")
+	}
+	return base64.RawURLEncoding.EncodeToString(cursorJson), nil
+}
+
+func decodeCursor(encoded string) (searchCursor, error) {
+	cursorJson, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return searchCursor{}, ErrInvalidCursor
+	}
+
+	// Numbers are kept as json.Number so they are sent back to Elasticsearch unchanged
+	decoder := json.NewDecoder(bytes.NewReader(cursorJson))
+	decoder.UseNumber()
+	var cursor searchCursor
+	if err = decoder.Decode(&cursor); err != nil || len(cursor.After) == 0 {
+		return searchCursor{}, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
@@ -0,0 +1,313 @@
+package nwelastic
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestNewsSearchRequest(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cursor, _ := encodeCursor("pit-1", []types.FieldValue{1704067200000, 3})
+
+	tests := []struct {
+		name         string
+		filter       NewsFilter
+		expectedJson string
+		expectedErr  error
+	}{
+		{
+			name:   "defaults",
+			filter: NewsFilter{},
+			expectedJson: `{
+				"query": {"bool": {}},
+				"sort": [{"publicationTime": {"order": "desc"}}],
+				"size": 20
+			}`,
+		},
+		{
+			name:   "paginate",
+			filter: NewsFilter{Paginate: true},
+			expectedJson: `{
+				"query": {"bool": {}},
+				"sort": [{"publicationTime": {"order": "desc"}}, {"_shard_doc": {"order": "desc"}}],
+				"size": 20
+			}`,
+		},
+		{
+			name: "every filter",
+			filter: NewsFilter{
+				Tickers:       []string{"AAPL"},
+				Sources:       []string{"SEC"},
+				Ciks:          []int{320193},
+				CategoryCodes: []string{"M&A"},
+				IndustryCodes: []string{"TECH"},
+				RegionCodes:   []string{"US"},
+				From:          from,
+				To:            from.Add(24 * time.Hour),
+				Query:         "earnings",
+				Sort:          SortRelevance,
+				Order:         SortAsc,
+				Size:          5000,
+				Cursor:        cursor,
+			},
+			expectedJson: `{
+				"query": {"bool": {
+					"filter": [
+						{"terms": {"tickers": ["AAPL"]}},
+						{"terms": {"source": ["SEC"]}},
+						{"terms": {"ciks": [320193]}},
+						{"terms": {"categoryCodes": ["M&A"]}},
+						{"terms": {"industryCodes": ["TECH"]}},
+						{"terms": {"regionCodes": ["US"]}},
+						{"range": {"publicationTime": {"gte": "2024-01-01T00:00:00Z", "lt": "2024-01-02T00:00:00Z"}}}
+					],
+					"must": [{"multi_match": {"query": "earnings", "fields": ["headline^2", "body"]}}]
+				}},
+				"sort": [{"_score": {"order": "asc"}}, {"_shard_doc": {"order": "asc"}}],
+				"search_after": [1704067200000, 3],
+				"pit": {"id": "pit-1"},
+				"size": 1000
+			}`,
+		},
+		{
+			name:        "invalid sort",
+			filter:      NewsFilter{Sort: "headline"},
+			expectedErr: ErrInvalidSort,
+		},
+		{
+			name:        "invalid order",
+			filter:      NewsFilter{Order: "up"},
+			expectedErr: ErrInvalidSort,
+		},
+		{
+			name:        "invalid cursor",
+			filter:      NewsFilter{Cursor: "not a cursor"},
+			expectedErr: ErrInvalidCursor,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := newsSearchRequest(tt.filter)
+			if tt.expectedErr != nil {
+				assert.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			actualJson, err := json.Marshal(req)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.JSONEq(t, tt.expectedJson, string(actualJson))
+		})
+	}
+}
+
+func TestNewsRepository_Search(t *testing.T) {
+	var actualRequests []string
+	var actualPits []any
+	closedPits := []string{}
+	elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+		actualRequests = append(actualRequests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.URL.Path == "/nwelastic_tests/_pit":
+			w.Write([]byte(`{"id": "pit-1"}`))
+		case r.URL.Path == "/_pit" && r.Method == http.MethodDelete:
+			var body struct {
+				Id string `json:"id"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			closedPits = append(closedPits, body.Id)
+			w.Write([]byte(`{"succeeded": true, "num_freed": 1}`))
+		case r.URL.Path == "/nwelastic_tests/_search":
+			w.Write([]byte(`{"hits": {"total": {"value": 3, "relation": "eq"}, "hits": [
+				{"_id": "1", "_source": {"id": "1"}, "sort": [2]},
+				{"_id": "generated", "_source": {}, "sort": [2]}
+			]}}`))
+		case r.URL.Path == "/_search":
+			var search map[string]any
+			json.NewDecoder(r.Body).Decode(&search)
+			actualPits = append(actualPits, search["pit"])
+			if search["pit"].(map[string]any)["id"] == "expired" {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error": {"type": "search_context_missing_exception", "reason": "No search context found"}, "status": 404}`))
+				return
+			}
+			if search["search_after"] == nil {
+				w.Write([]byte(`{"pit_id": "pit-2", "hits": {"total": {"value": 3, "relation": "eq"}, "hits": [
+					{"_id": "1", "_source": {"id": "1"}, "sort": [2, 0]},
+					{"_id": "generated", "_source": {}, "sort": [2, 1]}
+				]}}`))
+				return
+			}
+			w.Write([]byte(`{"pit_id": "pit-3", "hits": {"total": {"value": 3, "relation": "eq"}, "hits": [
+				{"_id": "3", "_source": {"id": "3"}, "sort": [1, 2]}
+			]}}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	})
+	repository, err := NewNewsRepository(elastic)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	result, err := repository.Search(context.Background(), NewsFilter{Size: 2})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"1", "generated"}, newsIds(result.News))
+	assert.Empty(t, result.Cursor)
+
+	result, err = repository.Search(context.Background(), NewsFilter{Size: 2, Paginate: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"1", "generated"}, newsIds(result.News))
+	cursor, err := decodeCursor(result.Cursor)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "pit-2", cursor.PitId)
+	}
+	assert.Empty(t, closedPits)
+	firstCursor := result.Cursor
+
+	result, err = repository.Search(context.Background(), NewsFilter{Size: 2, Cursor: result.Cursor})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"3"}, newsIds(result.News))
+	assert.Empty(t, result.Cursor)
+	assert.Equal(t, []string{"pit-3"}, closedPits)
+
+	expired, _ := encodeCursor("expired", []types.FieldValue{1, 2})
+	_, err = repository.Search(context.Background(), NewsFilter{Size: 2, Cursor: expired})
+	assert.ErrorIs(t, err, ErrCursorExpired)
+
+	assert.NoError(t, repository.ClosePointInTime(context.Background(), firstCursor))
+	assert.Equal(t, []string{"pit-3", "expired", "pit-2"}, closedPits)
+
+	assert.Equal(t, []string{
+		"POST /nwelastic_tests/_search",
+		"POST /nwelastic_tests/_pit", "POST /_search",
+		"POST /_search", "DELETE /_pit",
+		"POST /_search", "DELETE /_pit",
+		"DELETE /_pit",
+	}, actualRequests)
+	assert.Equal(t, []any{
+		map[string]any{"id": "pit-1", "keep_alive": "60000ms"},
+		map[string]any{"id": "pit-2", "keep_alive": "60000ms"},
+		map[string]any{"id": "expired", "keep_alive": "60000ms"},
+	}, actualPits)
+}
+
+func TestSearchResult_Pagination(t *testing.T) {
+	paginationJson, _ := json.Marshal(SearchResult{Cursor: "cursor", Total: 2}.Pagination())
+	assert.JSONEq(t, `{"cursor": "cursor", "total": 2}`, string(paginationJson))
+
+	paginationJson, _ = json.Marshal(SearchResult{Total: 2}.Pagination())
+	assert.JSONEq(t, `{"total": 2}`, string(paginationJson))
+}
+
+// searchSuite performs integration tests
+type searchSuite struct {
+	suite.Suite
+	newsRepository NewsRepository
+}
+
+func (s *searchSuite) SetupSuite() {
+	config := TestElasticConfig
+	config.NewsIndex = "nwelastic_tests_search_" + strconv.Itoa(rand.Int())
+	elastic := NewElastic(config)
+	s.Require().NoError(EnsureNewsIndex(context.Background(), elastic))
+
+	var err error
+	s.newsRepository, err = NewNewsRepository(elastic)
+	s.Require().NoError(err)
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	news := []*News{
+		{Id: "1", Headline: "Apple earnings", Tickers: []string{"AAPL"}, Source: "SEC", PublicationTime: now.Add(-3 * time.Hour)},
+		{Id: "2", Headline: "Apple buyback", Tickers: []string{"aapl"}, Source: "PR", PublicationTime: now.Add(-2 * time.Hour)},
+		{Id: "3", Headline: "Microsoft earnings", Tickers: []string{"MSFT"}, Source: "SEC", PublicationTime: now.Add(-time.Hour)},
+	}
+	s.Require().NoError(s.newsRepository.InsertBatch(news, func(int, int) {}))
+	_, err = elastic.TypedClient.Indices.Refresh().Index(config.NewsIndex).Do(context.Background())
+	s.Require().NoError(err)
+}
+
+func (s *searchSuite) TearDownSuite() {
+	ctx := context.Background()
+	index := s.newsRepository.Index
+	_, _ = s.newsRepository.elastic.TypedClient.Indices.Delete(index).Do(ctx)
+	_, _ = s.newsRepository.elastic.TypedClient.Indices.Delete(migrationsIndex(index)).Do(ctx)
+	_, _ = s.newsRepository.elastic.TypedClient.Indices.DeleteIndexTemplate(index).Do(ctx)
+	_, _ = s.newsRepository.elastic.TypedClient.Indices.DeleteIndexTemplate(bodyChunksIndex(index)).Do(ctx)
+}
+
+func (s *searchSuite) TestSearch() {
+	tests := []struct {
+		name        string
+		filter      NewsFilter
+		expectedIds []string
+	}{
+		{"all news newest first", NewsFilter{}, []string{"3", "2", "1"}},
+		{"tickers are case insensitive", NewsFilter{Tickers: []string{"AAPL"}, Order: SortAsc}, []string{"1", "2"}},
+		{"source and query", NewsFilter{Sources: []string{"SEC"}, Query: "earnings"}, []string{"3", "1"}},
+	}
+	for _, tt := range tests {
+		s.Run(tt.name, func() {
+			result, err := s.newsRepository.Search(context.Background(), tt.filter)
+			s.Require().NoError(err)
+			s.Equal(tt.expectedIds, newsIds(result.News))
+			s.Empty(result.Cursor)
+		})
+	}
+}
+
+func (s *searchSuite) TestSearch_pagination() {
+	var actualIds []string
+	filter := NewsFilter{Size: 2, Paginate: true}
+	for page := 0; ; page++ {
+		s.Require().Less(page, 3)
+		result, err := s.newsRepository.Search(context.Background(), filter)
+		s.Require().NoError(err)
+		s.Equal(int64(3), result.Total)
+
+		actualIds = append(actualIds, newsIds(result.News)...)
+		if result.Cursor == "" {
+			break
+		}
+		filter.Cursor = result.Cursor
+	}
+
+	s.Equal([]string{"3", "2", "1"}, actualIds)
+}
+
+func newsIds(news []News) []string {
+	ids := make([]string, len(news))
+	for i, n := range news {
+		ids[i] = n.Id
+	}
+	return ids
+}
+
+func TestSearchSuite(t *testing.T) {
+	integration := os.Getenv("INTEGRATION")
+	if integration == "" {
+		t.Skip("skipping: set INTEGRATION env to run this test")
+	}
+
+	suite.Run(t, new(searchSuite))
+}
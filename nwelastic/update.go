@@ -0,0 +1,60 @@
+package nwelastic
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types"
+	"github.com/elastic/go-elasticsearch/v8/typedapi/types/enums/result"
+	"github.com/pkg/errors"
+)
+
+// Update sets fields, the JSON fields of News, on the news with id. It returns false if the news already had these
+// values, and ErrNewsNotFound if it doesn't exist. The update isn't checked against the version policy nor copied to
+// the history index. With partitioning, every partition holding the news is updated and the news isn't moved if its
+// publication time changes.
+func (b NewsRepository) Update(ctx context.Context, id string, fields map[string]any) (bool, error) {
+	indices := []string{b.Index}
+	if b.opts.Partitioning != PartitionNone {
+		partitions, err := b.newsPartitions(ctx, []string{id})
+		if err != nil {
+			return false, err
+		}
+		indices = partitions[id]
+		if len(indices) == 0 {
+			return false, errors.Wrap(ErrNewsNotFound, id)
+		}
+	}
+
+	updated := false
+	for _, index := range indices {
+		res, err := b.elastic.TypedClient.Update(index, id).Doc(fields).Do(ctx)
+		if err != nil {
+			var esErr *types.ElasticsearchError
+			if errors.As(err, &esErr) && esErr.Status == http.StatusNotFound {
+				return false, errors.Wrap(ErrNewsNotFound, id)
+			}
+			return false, errors.Wrapf(err, "updating news %s", id)
+		}
+		updated = updated || res.Result != result.Noop
+	}
+
+	return updated, nil
+}
+
+// newsPartitions returns the partitions holding a copy of the news with ids, by id. The lookup is realtime, so news
+// written just before are found.
+func (b NewsRepository) newsPartitions(ctx context.Context, ids []string) (map[string][]string, error) {
+	docs, err := b.partitionDocs(ctx, ids, false)
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := make(map[string][]string, len(docs))
+	for id, copies := range docs {
+		for _, doc := range copies {
+			partitions[id] = append(partitions[id], doc.Index_)
+		}
+	}
+	return partitions, nil
+}
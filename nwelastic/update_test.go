@@ -0,0 +1,88 @@
+package nwelastic
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewsRepository_Update(t *testing.T) {
+	tests := []struct {
+		name            string
+		opts            NewsRepositoryOpts
+		copies          map[string][]string
+		updateResponses map[string]string
+		expectedUpdated bool
+		expectedPaths   []string
+		expectedErr     error
+	}{
+		{
+			name:            "updated",
+			updateResponses: map[string]string{"nwelastic_tests": `{"result": "updated"}`},
+			expectedUpdated: true,
+			expectedPaths:   []string{"/nwelastic_tests/_update/1"},
+		},
+		{
+			name:            "same values",
+			updateResponses: map[string]string{"nwelastic_tests": `{"result": "noop"}`},
+			expectedPaths:   []string{"/nwelastic_tests/_update/1"},
+		},
+		{
+			name:          "not found",
+			expectedPaths: []string{"/nwelastic_tests/_update/1"},
+			expectedErr:   ErrNewsNotFound,
+		},
+		{
+			name:   "every partition",
+			opts:   NewsRepositoryOpts{Partitioning: PartitionMonthly},
+			copies: map[string][]string{"1": {"nwelastic_tests-2024.01", "nwelastic_tests-2024.02"}},
+			updateResponses: map[string]string{
+				"nwelastic_tests-2024.01": `{"result": "noop"}`,
+				"nwelastic_tests-2024.02": `{"result": "updated"}`,
+			},
+			expectedUpdated: true,
+			expectedPaths:   []string{"/_resolve/index/nwelastic_tests-*", "/_mget", "/nwelastic_tests-2024.01/_update/1", "/nwelastic_tests-2024.02/_update/1"},
+		},
+		{
+			name:          "not found in partitions",
+			opts:          NewsRepositoryOpts{Partitioning: PartitionMonthly},
+			expectedPaths: []string{"/_resolve/index/nwelastic_tests-*", "/_mget"},
+			expectedErr:   ErrNewsNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var actualPaths []string
+			elastic := newFakeElastic(t, func(w http.ResponseWriter, r *http.Request) {
+				actualPaths = append(actualPaths, r.URL.Path)
+				if servePartitions(w, r, []string{"nwelastic_tests-2024.01", "nwelastic_tests-2024.02"}, tt.copies) {
+					return
+				}
+
+				var body map[string]any
+				json.NewDecoder(r.Body).Decode(&body)
+				assert.Equal(t, map[string]any{"doc": map[string]any{"headline": "corrected"}}, body)
+				for index, response := range tt.updateResponses {
+					if r.URL.Path == "/"+index+"/_update/1" {
+						w.Write([]byte(response))
+						return
+					}
+				}
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error": {"type": "document_missing_exception", "reason": "[1]: document missing"}, "status": 404}`))
+			})
+			repository, err := NewNewsRepository(elastic, tt.opts)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			updated, err := repository.Update(context.Background(), "1", map[string]any{"headline": "corrected"})
+			assert.ErrorIs(t, err, tt.expectedErr)
+			assert.Equal(t, tt.expectedUpdated, updated)
+			assert.Equal(t, tt.expectedPaths, actualPaths)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package nwelastic
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// VersionPolicyNone overwrites news regardless of their version
+	VersionPolicyNone = ""
+	// VersionPolicyReceivedTime uses News.ReceivedTime as the version of news
+	VersionPolicyReceivedTime = "receivedTime"
+	// VersionPolicyRevision uses News.Revision as the version of news
+	VersionPolicyRevision = "revision"
+)
+
+var (
+	ErrInvalidVersionPolicy = errors.New("invalid version policy")
+	ErrVersionConflict      = errors.New("a newer version of the news exists")
+)
+
+func validateVersionPolicy(opts NewsRepositoryOpts) error {
+	switch opts.VersionPolicy {
+	case VersionPolicyNone, VersionPolicyReceivedTime, VersionPolicyRevision:
+	default:
+		return errors.Wrap(ErrInvalidVersionPolicy, opts.VersionPolicy)
+	}
+
+	if opts.VersionPolicy != VersionPolicyNone && opts.CreateOnly {
+		return errors.Wrap(ErrInvalidVersionPolicy, "versioning can't be combined with CreateOnly")
+	}
+	// Elasticsearch rejects versioned writes of news without id, an id policy ensures every news has one
+	if opts.VersionPolicy != VersionPolicyNone && opts.IdPolicy == IdPolicyNone {
+		return errors.Wrap(ErrInvalidVersionPolicy, "versioning requires an id policy")
+	}
+	if opts.VersionPolicy == VersionPolicyNone && opts.KeepHistory {
+		return errors.Wrap(ErrInvalidVersionPolicy, "KeepHistory requires a version policy")
+	}
+
+	return nil
+}
+
+// newsVersion returns the external version of news, a news is only written if its version is greater than or equal to
+// the stored one
+func (b NewsRepository) newsVersion(news *News) int64 {
+	switch b.opts.VersionPolicy {
+	case VersionPolicyReceivedTime:
+		if news.ReceivedTime.IsZero() {
+			return 0
+		}
+		return news.ReceivedTime.UnixNano()
+	case VersionPolicyRevision:
+		return news.Revision
+	}
+	return 0
+}
+
+// conflictErr is the error of a news rejected with a 409 status
+func (b NewsRepository) conflictErr() error {
+	if b.opts.CreateOnly {
+		return ErrAlreadyExists
+	}
+	return ErrVersionConflict
+}
+
+// historyId is the id of a revision of a news in the history index
+func historyId(id string, version int64) string {
+	return id + "_" + strconv.FormatInt(version, 10)
+}
+
+// insertHistory copies indexed news to the history index, a revision sent twice is stored once
+func (b NewsRepository) insertHistory(items []bulkItem, stats *BulkStats) ([]BulkFailure, error) {
+	historyItems := make([]bulkItem, len(items))
+	for i, item := range items {
+		action, err := json.Marshal(map[string]bulkActionMeta{"index": {Index: historyIndex(b.Index), Id: historyId(item.id, item.version)}})
+		if err != nil {
+			return nil, errors.Wrap(err, "marshaling history bulk action")
+		}
+
+		historyItems[i] = item
+		historyItems[i].action = action
+	}
+
+	_, failures, err := b.bulkWithRetries(historyItems, stats)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting history")
+	}
+	for i := range failures {
+		failures[i].Reason = "history: " + failures[i].Reason
+	}
+
+	return failures, nil
+}
+
+// insertHistoryNews copies a news indexed by Insert to the history index
+func (b NewsRepository) insertHistoryNews(ctx context.Context, news *News) error {
+	_, err := b.elastic.TypedClient.Index(historyIndex(b.Index)).Id(historyId(news.Id, b.newsVersion(news))).Request(news).Do(ctx)
+	if err != nil {
+		return errors.Wrap(err, "inserting history")
+	}
+	return nil
+}
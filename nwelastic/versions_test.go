@@ -0,0 +1,56 @@
+package nwelastic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateVersionPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        NewsRepositoryOpts
+		expectedErr error
+	}{
+		{"no policy", NewsRepositoryOpts{}, nil},
+		{"revision with history", NewsRepositoryOpts{IdPolicy: IdPolicyProvider, VersionPolicy: VersionPolicyRevision, KeepHistory: true}, nil},
+		{"unknown policy", NewsRepositoryOpts{IdPolicy: IdPolicyProvider, VersionPolicy: "creationTime"}, ErrInvalidVersionPolicy},
+		{"create only", NewsRepositoryOpts{IdPolicy: IdPolicyProvider, VersionPolicy: VersionPolicyReceivedTime, CreateOnly: true}, ErrInvalidVersionPolicy},
+		{"without id policy", NewsRepositoryOpts{VersionPolicy: VersionPolicyRevision}, ErrInvalidVersionPolicy},
+		{"history without policy", NewsRepositoryOpts{KeepHistory: true}, ErrInvalidVersionPolicy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateVersionPolicy(tt.opts)
+			if tt.expectedErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.expectedErr)
+			}
+		})
+	}
+}
+
+func TestNewsRepository_newsVersion(t *testing.T) {
+	receivedTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	news := &News{ReceivedTime: receivedTime, Revision: 3}
+
+	tests := []struct {
+		name            string
+		policy          string
+		news            *News
+		expectedVersion int64
+	}{
+		{"no policy", VersionPolicyNone, news, 0},
+		{"received time", VersionPolicyReceivedTime, news, receivedTime.UnixNano()},
+		{"zero received time", VersionPolicyReceivedTime, &News{}, 0},
+		{"revision", VersionPolicyRevision, news, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repository := NewsRepository{opts: NewsRepositoryOpts{VersionPolicy: tt.policy}}
+			assert.Equal(t, tt.expectedVersion, repository.newsVersion(tt.news))
+		})
+	}
+}
@@ -15,7 +15,10 @@ import (
 	"time"
 
 	"github.com/encypher-studio/newsware-utils/ecslogger"
+	"github.com/encypher-studio/newsware-utils/nwtrace"
 	"github.com/said1296/fsnotify"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
@@ -33,6 +36,8 @@ type NewFile struct {
 	RelativePath string
 	Bytes        []byte
 	ReceivedTime time.Time
+	// SpanContext identifies the span that emitted the file, consumers use it as parent of their own spans
+	SpanContext trace.SpanContext
 }
 
 type IFs interface {
@@ -251,9 +256,7 @@ func (f Fs) handleFileModification(event fsnotify.Event, chanFiles chan NewFile,
 	f.fileModificationTimers[event.Name].Reset(f.fileModificationTimeout)
 }
 
-func (f Fs) processNewFile(path string, chanFiles chan NewFile, info os.FileInfo) error {
-	var err error
-
+func (f Fs) processNewFile(path string, chanFiles chan NewFile, info os.FileInfo) (err error) {
 	f.logger.Info("new file detected", zap.String("path", path))
 	filename := filepath.Base(path)
 
@@ -262,6 +265,10 @@ func (f Fs) processNewFile(path string, chanFiles chan NewFile, info os.FileInfo
 		return nil
 	}
 
+	_, span := nwtrace.Start(context.Background(), "nwfs emit", trace.WithSpanKind(trace.SpanKindProducer))
+	defer func() { nwtrace.EndWithError(span, err) }()
+	span.SetAttributes(attribute.String("file.path", path))
+
 	var bytes []byte
 	if !f.SkipReadingContent {
 		bytes, err = os.ReadFile(path)
@@ -276,6 +283,7 @@ func (f Fs) processNewFile(path string, chanFiles chan NewFile, info os.FileInfo
 		RelativePath: strings.TrimPrefix(path, f.Dir+"/"),
 		Bytes:        bytes,
 		ReceivedTime: info.ModTime().UTC(),
+		SpanContext:  span.SpanContext(),
 	}
 
 	return nil
@@ -0,0 +1,88 @@
+// Package nwtrace wires OpenTelemetry tracing through the ingestion pipeline. Tracing is optional: spans are only
+// exported when a TracerProvider is registered with otel.SetTracerProvider, otherwise the global no-op provider is used.
+package nwtrace
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/encypher-studio/newsware-utils"
+
+var (
+	// Propagator is used to inject and extract trace context in HTTP and NATS headers. It defaults to W3C trace
+	// context and baggage, so propagation works without configuring the global otel propagator.
+	Propagator propagation.TextMapPropagator = propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	)
+)
+
+// Tracer returns the tracer used by every package in this module, resolved from the global TracerProvider on each call
+// so a provider registered after startup is picked up.
+func Tracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(instrumentationName)
+}
+
+// Start starts a span using Tracer()
+func Start(ctx context.Context, spanName string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, spanName, opts...)
+}
+
+// EndWithError records err in span, if any, and ends it
+func EndWithError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InjectHttp writes the trace context in ctx to header
+func InjectHttp(ctx context.Context, header http.Header) {
+	Propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// ExtractHttp returns a copy of ctx carrying the trace context found in header
+func ExtractHttp(ctx context.Context, header http.Header) context.Context {
+	return Propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// InjectNats writes the trace context in ctx to header
+func InjectNats(ctx context.Context, header nats.Header) {
+	Propagator.Inject(ctx, natsHeaderCarrier(header))
+}
+
+// ExtractNats returns a copy of ctx carrying the trace context found in header
+func ExtractNats(ctx context.Context, header nats.Header) context.Context {
+	if header == nil {
+		return ctx
+	}
+	return Propagator.Extract(ctx, natsHeaderCarrier(header))
+}
+
+// natsHeaderCarrier adapts nats.Header to propagation.TextMapCarrier. nats.Header keys are case-sensitive, unlike
+// http.Header, so propagation.HeaderCarrier can't be used directly.
+type natsHeaderCarrier nats.Header
+
+func (c natsHeaderCarrier) Get(key string) string {
+	return nats.Header(c).Get(key)
+}
+
+func (c natsHeaderCarrier) Set(key string, value string) {
+	nats.Header(c).Set(key, value)
+}
+
+func (c natsHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,61 @@
+package nwtrace
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/encypher-studio/newsware-utils/nwtrace/nwtracetest"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestInjectExtractHttp(t *testing.T) {
+	nwtracetest.Install(t)
+
+	ctx, span := Start(context.Background(), "test")
+	defer span.End()
+
+	header := http.Header{}
+	InjectHttp(ctx, header)
+	assert.NotEmpty(t, header.Get("traceparent"))
+
+	extracted := trace.SpanContextFromContext(ExtractHttp(context.Background(), header))
+	assert.Equal(t, span.SpanContext().TraceID(), extracted.TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), extracted.SpanID())
+}
+
+func TestInjectExtractNats(t *testing.T) {
+	nwtracetest.Install(t)
+
+	ctx, span := Start(context.Background(), "test")
+	defer span.End()
+
+	header := nats.Header{}
+	InjectNats(ctx, header)
+	assert.NotEmpty(t, header.Get("traceparent"))
+
+	extracted := trace.SpanContextFromContext(ExtractNats(context.Background(), header))
+	assert.Equal(t, span.SpanContext().TraceID(), extracted.TraceID())
+	assert.Equal(t, span.SpanContext().SpanID(), extracted.SpanID())
+}
+
+func TestExtractNats_nilHeader(t *testing.T) {
+	ctx := ExtractNats(context.Background(), nil)
+	assert.False(t, trace.SpanContextFromContext(ctx).IsValid())
+}
+
+func TestEndWithError(t *testing.T) {
+	exporter := nwtracetest.Install(t)
+
+	_, span := Start(context.Background(), "failing")
+	EndWithError(span, assert.AnError)
+
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 1) {
+		t.FailNow()
+	}
+	assert.Equal(t, "failing", spans[0].Name)
+	assert.Equal(t, assert.AnError.Error(), spans[0].Status.Description)
+}
@@ -0,0 +1,39 @@
+// Package nwtracetest provides helpers to assert spans emitted by this module in tests.
+package nwtracetest
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Install registers a global TracerProvider that synchronously exports to an in-memory exporter, the previous
+// provider is restored when the test finishes.
+func Install(t testing.TB) *tracetest.InMemoryExporter {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		_ = provider.Shutdown(context.Background())
+	})
+
+	return exporter
+}
+
+// SpanNames returns the names of the exported spans in the order they ended
+func SpanNames(exporter *tracetest.InMemoryExporter) []string {
+	spans := exporter.GetSpans()
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.Name
+	}
+	return names
+}